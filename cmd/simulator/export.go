@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+	"github.com/sebastiankruger/shopfloor-simulator/internal/opcua"
+)
+
+// exportNodeSet writes the OPC UA address space of the configuration as
+// NodeSet2 XML, to stdout unless -o names a file
+func exportNodeSet(args []string) error {
+	flags := flag.NewFlagSet("export-nodeset", flag.ExitOnError)
+	output := flags.String("o", "", "write the NodeSet2 XML to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export-nodeset [-o file]\n\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Exports the OPC UA address space as NodeSet2 XML. The configuration is read as for a normal start.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// Only problems are of interest, the XML may go to stdout
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return opcua.ExportNodeSet(cfg, w)
+}
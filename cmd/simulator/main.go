@@ -13,6 +13,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+	"github.com/sebastiankruger/shopfloor-simulator/internal/control"
 	"github.com/sebastiankruger/shopfloor-simulator/internal/erp"
 	"github.com/sebastiankruger/shopfloor-simulator/internal/health"
 	"github.com/sebastiankruger/shopfloor-simulator/internal/opcua"
@@ -31,6 +32,13 @@ func main() {
 		}
 	}()
 
+	if len(os.Args) > 1 && os.Args[1] == "export-nodeset" {
+		if err := exportNodeSet(os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Failed to export NodeSet")
+		}
+		return
+	}
+
 	log.Info().Msg("Starting Welding Robot Shopfloor Simulator")
 
 	// Load configuration
@@ -40,6 +48,7 @@ func main() {
 	}
 
 	log.Info().
+		Str("file", cfg.ConfigFile).
 		Str("name", cfg.SimulatorName).
 		Int("opcua_port", cfg.OPCUAPort).
 		Str("erp_endpoint", cfg.ERPEndpoint).
@@ -54,20 +63,45 @@ func main() {
 	// Initialize components
 	stateMachine := simulator.NewStateMachine(cfg)
 	tsGenerator := simulator.NewTimeseriesGenerator()
+	tsGenerator.SetTargets(cfg.TargetCurrent, cfg.TargetVoltage, cfg.TargetWireFeedSpeed,
+		cfg.TargetGasFlow, cfg.TargetTravelSpeed)
+	sensorHealth := simulator.NewSensorHealthModel(cfg)
+	energyModel := simulator.NewEnergyModel()
+	thermalModel := simulator.NewThermalModel(cfg)
+	oeeTracker := simulator.NewOEETracker(cfg)
+	var waveGenerator *simulator.WaveformGenerator
+	if cfg.HighRateMode {
+		waveGenerator = simulator.NewWaveformGenerator(float64(cfg.WaveformSampleRate))
+	}
 	erpClient := erp.NewClient(cfg)
 	orderGenerator := erp.NewOrderGenerator(cfg)
+	partCatalog, err := erp.LoadPartCatalog(cfg.PartCatalogFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load part catalog")
+	}
+	orderGenerator.SetCatalog(partCatalog)
+	stateMachine.SetPartCycleTimes(orderGenerator.GetPartCycleTime)
+	errorCatalog, err := simulator.LoadErrorCatalog(cfg.ErrorCatalogFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load error catalog")
+	}
+	stateMachine.SetErrorCatalog(errorCatalog)
 	shiftManager, err := erp.NewShiftManager(cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create shift manager")
 	}
 	healthHandler := health.NewHandler()
+	controlHandler := control.NewHandler(cfg, stateMachine, tsGenerator)
 
 	// Create OPC UA server
-	opcuaServer, err := opcua.NewServer(cfg.OPCUAPort, cfg.SimulatorName)
+	opcuaServer, err := opcua.NewServer(cfg, stateMachine, tsGenerator, orderGenerator.GetRecipe)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create OPC UA server")
 	}
 
+	// Outputs the signals are published to every tick
+	sinks := []simulator.Sink{opcuaServer}
+
 	// Setup callbacks
 	stateMachine.SetCallbacks(
 		// On state change
@@ -76,27 +110,62 @@ func main() {
 				Str("from", from.String()).
 				Str("to", to.String()).
 				Msg("State changed")
+
+			// Load the weld recipe of the order being set up
+			if to == simulator.StateSetup {
+				if order := stateMachine.GetCurrentOrder(); order != nil {
+					if recipe, ok := orderGenerator.GetPartRecipe(order.PartNumber); ok {
+						tsGenerator.ApplyRecipe(recipe)
+						log.Info().
+							Str("recipe", recipe.Name).
+							Str("transferMode", recipe.TransferMode.String()).
+							Msg("Weld recipe loaded")
+					}
+				}
+			}
 		},
-		// On cycle complete
-		func(isScrap bool) {
-			result := "good"
-			if isScrap {
-				result = "scrap"
+		// On cycle complete or aborted
+		func(isScrap bool, welded float64) {
+			oeeTracker.CompletePart(isScrap, stateMachine.CycleTime(), welded)
+			thermalModel.NewWorkpiece()
+			if welded < 1 {
+				energyModel.AbortPart()
+				log.Debug().
+					Float64("welded", welded).
+					Msg("Cycle aborted")
+			} else {
+				result := "good"
+				if isScrap {
+					result = "scrap"
+				}
+				partUtilities := energyModel.CompletePart()
+				log.Debug().
+					Str("result", result).
+					Float64("energyKWh", partUtilities.EnergyKWh).
+					Msg("Cycle completed")
 			}
-			log.Debug().Str("result", result).Msg("Cycle completed")
 
 			// Send order update to ERP
 			if order := stateMachine.GetCurrentOrder(); order != nil {
+				order.Utilities = energyModel.OrderTotals()
 				go erpClient.SendOrderUpdate(ctx, order)
 			}
 		},
-		// On order complete
+		// On order complete or cancelled
 		func(order *simulator.ProductionOrder) {
+			order.Utilities = energyModel.OrderTotals()
+			energyModel.ResetOrder()
+
+			msg := "Order completed"
+			if order.Status == simulator.OrderStatusCancelled {
+				msg = "Order cancelled"
+			}
 			log.Info().
 				Str("orderId", order.OrderID).
 				Int("completed", order.QuantityCompleted).
 				Int("scrap", order.QuantityScrap).
-				Msg("Order completed")
+				Float64("energyKWh", order.Utilities.EnergyKWh).
+				Msg(msg)
 
 			go erpClient.SendOrderUpdate(ctx, order)
 
@@ -115,6 +184,29 @@ func main() {
 				Str("message", err.Message).
 				Time("expectedEnd", err.ExpectedEnd).
 				Msg("Error occurred")
+
+			// Overheating is repaired by flushing the coolant circuit
+			if err.Code == simulator.ErrorTorchOverheat {
+				thermalModel.ServiceCooling()
+			}
+
+			opcuaServer.RaiseAlarm(err)
+		},
+		// On recovery step
+		func(err *simulator.ErrorInfo) {
+			log.Info().
+				Str("code", string(err.Code)).
+				Str("step", err.RecoveryStep).
+				Time("expectedEnd", err.ExpectedEnd).
+				Msg("Error repaired, recovery step started")
+		},
+		// On error cleared
+		func(err *simulator.ErrorInfo) {
+			log.Info().
+				Str("code", string(err.Code)).
+				Msg("Error cleared")
+
+			opcuaServer.ClearAlarm(err)
 		},
 	)
 
@@ -134,12 +226,14 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to start OPC UA server")
 	}
 	healthHandler.SetOPCUAReady(true)
+	healthHandler.SetCertificateExpiry(opcuaServer.CertificateExpiry)
 
 	// Start health check HTTP server
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler.HandleHealth)
 	mux.HandleFunc("/health/live", healthHandler.HandleLive)
 	mux.HandleFunc("/health/ready", healthHandler.HandleReady)
+	controlHandler.Register(mux)
 
 	healthServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.HealthPort),
@@ -156,14 +250,90 @@ func main() {
 	}()
 
 	// Initialize shift
-	currentShift := shiftManager.GetCurrentShift(time.Now())
+	currentShift, _ := shiftManager.HasShiftChanged(time.Now())
 	stateMachine.SetCurrentShift(currentShift)
-	go erpClient.SendShiftUpdate(ctx, currentShift)
-	log.Info().
-		Str("shift", currentShift.ShiftName).
-		Time("start", currentShift.StartTime).
-		Time("end", currentShift.EndTime).
-		Msg("Current shift initialized")
+	if currentShift != nil {
+		go erpClient.SendShiftUpdate(ctx, currentShift)
+		log.Info().
+			Str("shift", currentShift.ShiftName).
+			Time("start", currentShift.StartTime).
+			Time("end", currentShift.EndTime).
+			Msg("Current shift initialized")
+	} else {
+		log.Info().Msg("Starting in unscheduled time, waiting for the next shift")
+	}
+
+	// Reload configuration on SIGHUP or when the config file changes
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	defer signal.Stop(reloadSignal)
+
+	var configChanges <-chan struct{}
+	if cfg.ConfigFile != "" {
+		configChanges = config.WatchFile(ctx, cfg.ConfigFile, 2*time.Second)
+	}
+	// Calendar and catalog files are watched at their current paths, which a
+	// reload may change
+	calendarWatcher := config.NewFileWatcher(ctx, cfg.ShiftCalendarFile, 2*time.Second)
+	partCatalogWatcher := config.NewFileWatcher(ctx, cfg.PartCatalogFile, 2*time.Second)
+	errorCatalogWatcher := config.NewFileWatcher(ctx, cfg.ErrorCatalogFile, 2*time.Second)
+
+	reloadConfig := func(trigger string) {
+		next, err := config.Load()
+		if err != nil {
+			log.Error().Err(err).Str("trigger", trigger).Msg("Configuration reload failed, keeping current configuration")
+			return
+		}
+
+		if restart := cfg.ApplyReload(next); len(restart) > 0 {
+			log.Warn().Strs("settings", restart).Msg("Changed settings take effect after a restart")
+		}
+
+		// Watch calendar and catalog files set or moved by the reload
+		calendarWatcher.SetPath(cfg.ShiftCalendarFile)
+		partCatalogWatcher.SetPath(cfg.PartCatalogFile)
+		errorCatalogWatcher.SetPath(cfg.ErrorCatalogFile)
+
+		erpClient.Configure(cfg)
+		tsGenerator.SetTargets(cfg.TargetCurrent, cfg.TargetVoltage, cfg.TargetWireFeedSpeed,
+			cfg.TargetGasFlow, cfg.TargetTravelSpeed)
+
+		// New catalogs apply to the next generated order and error
+		if catalog, err := erp.LoadPartCatalog(cfg.PartCatalogFile); err != nil {
+			log.Error().Err(err).Msg("Part catalog reload failed, keeping current catalog")
+		} else {
+			orderGenerator.SetCatalog(catalog)
+		}
+		if catalog, err := simulator.LoadErrorCatalog(cfg.ErrorCatalogFile); err != nil {
+			log.Error().Err(err).Msg("Error catalog reload failed, keeping current catalog")
+		} else {
+			stateMachine.SetErrorCatalog(catalog)
+		}
+
+		// A new shift model may put us in a different shift; adopt it
+		// without resetting counters
+		if err := shiftManager.Reload(); err != nil {
+			log.Error().Err(err).Msg("Shift calendar reload failed, keeping current schedule")
+		}
+		if newShift, changed := shiftManager.HasShiftChanged(time.Now()); changed {
+			stateMachine.SetCurrentShift(newShift)
+			if newShift != nil {
+				log.Info().
+					Str("shift", newShift.ShiftName).
+					Msg("Shift changed by new shift model")
+				go erpClient.SendShiftUpdate(ctx, newShift)
+			} else {
+				log.Info().Msg("New shift model leaves the current time unscheduled")
+			}
+		}
+
+		log.Info().
+			Str("trigger", trigger).
+			Float64("scrapRate", cfg.ScrapRate).
+			Float64("errorRate", cfg.ErrorRate).
+			Str("shiftModel", cfg.ShiftModel).
+			Msg("Configuration reloaded")
+	}
 
 	// Main simulation loop
 	ticker := time.NewTicker(cfg.PublishInterval)
@@ -173,46 +343,110 @@ func main() {
 		Dur("interval", cfg.PublishInterval).
 		Msg("Starting simulation loop")
 
+	// Phase progress is kept while paused so the signals hold their shape
+	var phaseProgress float64
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info().Msg("Shutdown signal received")
 			goto shutdown
 
+		case <-reloadSignal:
+			reloadConfig("SIGHUP")
+
+		case <-configChanges:
+			reloadConfig("file change")
+
+		case <-calendarWatcher.Changes():
+			reloadConfig("shift calendar change")
+
+		case <-partCatalogWatcher.Changes():
+			reloadConfig("part catalog change")
+
+		case <-errorCatalogWatcher.Changes():
+			reloadConfig("error catalog change")
+
+		case cmd := <-controlHandler.Commands():
+			cmd.Execute(time.Now())
+
+		case cmd := <-opcuaServer.Commands():
+			cmd.Execute(time.Now())
+
 		case now := <-ticker.C:
 			// Check for shift change
 			if newShift, changed := shiftManager.HasShiftChanged(now); changed {
-				log.Info().
-					Str("shift", newShift.ShiftName).
-					Msg("Shift changed")
-
 				stateMachine.SetCurrentShift(newShift)
-				stateMachine.ResetCounters()
-				go erpClient.SendShiftUpdate(ctx, newShift)
+
+				if newShift != nil {
+					log.Info().
+						Str("shift", newShift.ShiftName).
+						Str("crew", newShift.Crew).
+						Msg("Shift changed")
+
+					stateMachine.ResetCounters()
+					energyModel.ResetShift()
+					oeeTracker.Reset()
+					go erpClient.SendShiftUpdate(ctx, newShift)
+				} else {
+					// Counters of the last shift stay visible until the next one
+					log.Info().Msg("Shift ended, machine is not scheduled until the next shift")
+				}
 			}
 
 			// Check if it's break time
-			isBreakTime := shiftManager.IsBreakTime(now, shiftManager.GetCurrentShiftRef())
+			shift := shiftManager.GetCurrentShiftRef()
+			isBreakTime := shiftManager.IsBreakTime(now, shift)
 
 			// Update state machine
-			stateMachine.Update(now, isBreakTime)
+			stateMachine.Update(now, isBreakTime, shift != nil)
 
 			// Get current state
 			state := stateMachine.GetState()
 
 			// Calculate phase progress
-			var phaseProgress float64
-			if state.State == simulator.StateRunning {
+			if state.State != simulator.StateRunning {
+				phaseProgress = 0
+			} else if !state.Paused {
 				phaseProgress = simulator.CalculatePhaseProgress(
 					state.CycleStartedAt,
-					cfg.CycleTime,
+					stateMachine.CycleTime(),
 					state.WeldPhase,
 				)
 			}
 
-			// Generate timeseries data
+			// Generate timeseries data; a past collision degrades the path
+			tsGenerator.SetPositionDegradation(stateMachine.PositionDegradation())
 			tsData := tsGenerator.Generate(state.State, state.WeldPhase, phaseProgress)
 
+			// Meter power and utility consumption and simulate torch, coolant
+			// and workpiece temperatures; a paused simulation freezes both
+			if !state.Paused {
+				energyModel.Update(&tsData, cfg.PublishInterval)
+				if overheating := thermalModel.Update(&tsData, cfg.PublishInterval); overheating &&
+					state.State == simulator.StateRunning {
+					stateMachine.TriggerError(simulator.ErrorTorchOverheat, now)
+				}
+			}
+			energyModel.Apply(&tsData)
+			thermalModel.Apply(&tsData)
+
+			// Degrade signals with active sensor faults
+			sensorHealth.Apply(now, &tsData)
+
+			// Synthesize high-rate arc waveforms for this publish window
+			if waveGenerator != nil {
+				tsData.CurrentWaveform, tsData.VoltageWaveform = waveGenerator.Generate(
+					tsData.TransferMode, tsData.WeldingCurrent, tsData.Voltage, cfg.PublishInterval)
+				tsData.WaveformSampleRate = waveGenerator.SampleRate()
+			}
+
+			// Account OEE time; a paused simulation does not advance it
+			if !state.Paused {
+				oeeTracker.Update(state.State, cfg.PublishInterval)
+			}
+			oeeTracker.Apply(&tsData)
+
 			// Add state information
 			goodParts, scrapParts, arcTime := stateMachine.GetCounters()
 			tsData.GoodParts = goodParts
@@ -229,10 +463,15 @@ func main() {
 				tsData.ErrorCode = string(state.CurrentError.Code)
 				tsData.ErrorMessage = state.CurrentError.Message
 				tsData.ErrorTimestamp = state.CurrentError.OccurredAt
+				tsData.RecoveryStep = state.CurrentError.RecoveryStep
 			}
 
-			// Update OPC UA values
-			opcuaServer.UpdateValues(&tsData)
+			// Publish the signals
+			samples := tsData.Samples()
+			for _, sink := range sinks {
+				sink.Publish(tsData.Timestamp, samples)
+			}
+			opcuaServer.UpdateProduction(state.CurrentOrder, state.OrderQueue, state.CurrentShift)
 
 			// Log periodic status
 			if now.Second()%10 == 0 {
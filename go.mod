@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/awcullen/opcua v1.2.2
 	github.com/rs/zerolog v1.32.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,18 +1,49 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config holds all configuration for the simulator
 type Config struct {
+	// Path of the configuration file, empty if none was loaded
+	ConfigFile string
+
 	// Core settings
 	SimulatorName string
 	OPCUAPort     int
 	HealthPort    int
 
+	// Values kept per historized OPC UA variable, 0 disables history
+	OPCUAHistorySize int
+
+	// OPC UA security settings
+	OPCUASecurityPolicies []string // Accepted security policies, see SecurityPolicies
+	OPCUASecurityModes    []string // Accepted message security modes for the secured policies
+	OPCUAAllowAnonymous   bool
+	OPCUAUsers            []string // user:password pairs for username authentication
+	OPCUAX509Users        bool     // Accept user certificates from the trust list
+	OPCUATrustAllClients  bool     // Skip client certificate validation
+
+	// OPC UA certificate settings
+	OPCUAPKIDir          string        // Server certificate and trust list directory
+	OPCUACertFile        string        // Provided certificate, empty for a managed self-signed one
+	OPCUAKeyFile         string        // Private key of OPCUACertFile
+	OPCUACertHostnames   []string      // DNS names of the managed certificate besides the hostname
+	OPCUACertIPs         []string      // IP addresses of the managed certificate
+	OPCUACertLifetime    time.Duration // Validity of a managed certificate
+	OPCUACertRenewBefore time.Duration // Renew the managed certificate this long before it expires
+
+	// OPC UA custom nodes
+	OPCUACompanionNodeSets []string // NodeSet2 files of companion specifications, e.g. DI and Machinery
+	OPCUANodeSetFile       string   // NodeSet2 file whose nodes are added to the address space
+	OPCUANodeSetBindings   []string // signal=nodeId pairs: NodeSet variables that mirror a simulator variable
+
 	// ERP settings
 	ERPEndpoint  string
 	ERPOrderPath string
@@ -23,79 +54,289 @@ type Config struct {
 	CycleTime       time.Duration
 	SetupTime       time.Duration
 
+	// Default welding setpoints, used until a part recipe is loaded
+	TargetCurrent       float64
+	TargetVoltage       float64
+	TargetWireFeedSpeed float64
+	TargetGasFlow       float64
+	TargetTravelSpeed   float64
+
 	// Production settings
 	ScrapRate   float64
 	ErrorRate   float64
 	OrderMinQty int
 	OrderMaxQty int
 
+	// Catalog files replacing or extending the built-in parts and errors
+	PartCatalogFile  string
+	ErrorCatalogFile string
+
 	// Shift settings
-	Timezone   string
-	ShiftModel string
+	Timezone          string
+	ShiftModel        string
+	ShiftCalendarFile string
+	StopPolicy        string
+
+	// Sensor health settings
+	SensorFaultRate        float64
+	SensorFaultMinDuration time.Duration
+	SensorFaultMaxDuration time.Duration
+
+	// High-rate waveform settings
+	HighRateMode       bool
+	WaveformSampleRate int
+
+	// Thermal settings
+	TorchRatedCurrent    float64
+	TorchDutyCycleRating float64
+	TorchMaxTemp         float64
 }
 
-// Load reads configuration from environment variables with defaults
-func Load() (*Config, error) {
-	cfg := &Config{
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
 		// Core settings
-		SimulatorName: getEnvOrDefault("SIMULATOR_NAME", "WeldingRobot-01"),
-		OPCUAPort:     getEnvAsIntOrDefault("OPCUA_PORT", 4840),
-		HealthPort:    getEnvAsIntOrDefault("HEALTH_PORT", 8081),
+		SimulatorName: "WeldingRobot-01",
+		OPCUAPort:     4840,
+		HealthPort:    8081,
+
+		// One hour at the default publish interval
+		OPCUAHistorySize: 3600,
+
+		// OPC UA security settings
+		OPCUASecurityPolicies: []string{SecurityPolicyNone, SecurityPolicyBasic256Sha256,
+			SecurityPolicyAes128Sha256RsaOaep, SecurityPolicyAes256Sha256RsaPss},
+		OPCUASecurityModes:  []string{SecurityModeSign, SecurityModeSignAndEncrypt},
+		OPCUAAllowAnonymous: true,
+
+		// OPC UA certificate settings
+		OPCUAPKIDir:        "./pki",
+		OPCUACertHostnames: []string{"localhost", "welding-simulator", "welding-robot"},
+		// The endpoint URL names 0.0.0.0 and some clients check it against the certificate
+		OPCUACertIPs:         []string{"127.0.0.1", "0.0.0.0"},
+		OPCUACertLifetime:    365 * 24 * time.Hour,
+		OPCUACertRenewBefore: 30 * 24 * time.Hour,
 
 		// ERP settings
-		ERPEndpoint:  getEnvOrDefault("ERP_ENDPOINT", "http://localhost:8080"),
-		ERPOrderPath: getEnvOrDefault("ERP_ORDER_PATH", "/api/v1/production-orders"),
-		ERPShiftPath: getEnvOrDefault("ERP_SHIFT_PATH", "/api/v1/shifts"),
+		ERPEndpoint:  "http://localhost:8080",
+		ERPOrderPath: "/api/v1/production-orders",
+		ERPShiftPath: "/api/v1/shifts",
 
 		// Timing settings
-		PublishInterval: getDurationOrDefault("PUBLISH_INTERVAL", 1*time.Second),
-		CycleTime:       getDurationOrDefault("CYCLE_TIME", 60*time.Second),
-		SetupTime:       getDurationOrDefault("SETUP_TIME", 45*time.Second),
+		PublishInterval: 1 * time.Second,
+		CycleTime:       60 * time.Second,
+		SetupTime:       45 * time.Second,
+
+		// Default welding setpoints for mild steel, 0.035-0.045" wire
+		TargetCurrent:       200.0,
+		TargetVoltage:       24.0,
+		TargetWireFeedSpeed: 9.6,
+		TargetGasFlow:       15.0,
+		TargetTravelSpeed:   10.0,
 
 		// Production settings
-		ScrapRate:   getEnvAsFloatOrDefault("SCRAP_RATE", 0.03),
-		ErrorRate:   getEnvAsFloatOrDefault("ERROR_RATE", 0.02),
-		OrderMinQty: getEnvAsIntOrDefault("ORDER_MIN_QTY", 50),
-		OrderMaxQty: getEnvAsIntOrDefault("ORDER_MAX_QTY", 500),
+		ScrapRate:   0.03,
+		ErrorRate:   0.02,
+		OrderMinQty: 50,
+		OrderMaxQty: 500,
 
 		// Shift settings
-		Timezone:   getEnvOrDefault("TIMEZONE", "Europe/Berlin"),
-		ShiftModel: getEnvOrDefault("SHIFT_MODEL", "3-shift"),
+		Timezone:   "Europe/Berlin",
+		ShiftModel: "3-shift",
+		StopPolicy: StopPolicyFinishCycle,
+
+		// Sensor health settings
+		SensorFaultRate:        0.0001,
+		SensorFaultMinDuration: 10 * time.Second,
+		SensorFaultMaxDuration: 2 * time.Minute,
+
+		// High-rate waveform settings
+		HighRateMode:       false,
+		WaveformSampleRate: 5000,
+
+		// Thermal settings
+		TorchRatedCurrent:    350,
+		TorchDutyCycleRating: 1.0,
+		TorchMaxTemp:         80,
+	}
+}
+
+// Load builds the configuration from defaults, the optional file named by
+// CONFIG_FILE and environment variables, in increasing precedence, and
+// validates the result
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+		cfg.ConfigFile = path
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
+// applyEnv overrides configuration values with environment variables
+func applyEnv(cfg *Config) error {
+	env := &envReader{}
+
+	// Core settings
+	env.String("SIMULATOR_NAME", &cfg.SimulatorName)
+	env.Int("OPCUA_PORT", &cfg.OPCUAPort)
+	env.Int("HEALTH_PORT", &cfg.HealthPort)
+	env.Int("OPCUA_HISTORY_SIZE", &cfg.OPCUAHistorySize)
+
+	// OPC UA security settings
+	env.List("OPCUA_SECURITY_POLICIES", &cfg.OPCUASecurityPolicies)
+	env.List("OPCUA_SECURITY_MODES", &cfg.OPCUASecurityModes)
+	env.Bool("OPCUA_ALLOW_ANONYMOUS", &cfg.OPCUAAllowAnonymous)
+	env.List("OPCUA_USERS", &cfg.OPCUAUsers)
+	env.Bool("OPCUA_X509_USERS", &cfg.OPCUAX509Users)
+	env.Bool("OPCUA_TRUST_ALL_CLIENTS", &cfg.OPCUATrustAllClients)
+
+	// OPC UA certificate settings
+	env.String("OPCUA_PKI_DIR", &cfg.OPCUAPKIDir)
+	env.String("OPCUA_CERT_FILE", &cfg.OPCUACertFile)
+	env.String("OPCUA_KEY_FILE", &cfg.OPCUAKeyFile)
+	env.List("OPCUA_CERT_HOSTNAMES", &cfg.OPCUACertHostnames)
+	env.List("OPCUA_CERT_IPS", &cfg.OPCUACertIPs)
+	env.Duration("OPCUA_CERT_LIFETIME", &cfg.OPCUACertLifetime)
+	env.Duration("OPCUA_CERT_RENEW_BEFORE", &cfg.OPCUACertRenewBefore)
+
+	// OPC UA custom nodes
+	env.List("OPCUA_COMPANION_NODESETS", &cfg.OPCUACompanionNodeSets)
+	env.String("OPCUA_NODESET_FILE", &cfg.OPCUANodeSetFile)
+	env.List("OPCUA_NODESET_BINDINGS", &cfg.OPCUANodeSetBindings)
+
+	// ERP settings
+	env.String("ERP_ENDPOINT", &cfg.ERPEndpoint)
+	env.String("ERP_ORDER_PATH", &cfg.ERPOrderPath)
+	env.String("ERP_SHIFT_PATH", &cfg.ERPShiftPath)
+
+	// Timing settings
+	env.Duration("PUBLISH_INTERVAL", &cfg.PublishInterval)
+	env.Duration("CYCLE_TIME", &cfg.CycleTime)
+	env.Duration("SETUP_TIME", &cfg.SetupTime)
+
+	// Default welding setpoints
+	env.Float("SETPOINT_CURRENT", &cfg.TargetCurrent)
+	env.Float("SETPOINT_VOLTAGE", &cfg.TargetVoltage)
+	env.Float("SETPOINT_WIRE_FEED_SPEED", &cfg.TargetWireFeedSpeed)
+	env.Float("SETPOINT_GAS_FLOW", &cfg.TargetGasFlow)
+	env.Float("SETPOINT_TRAVEL_SPEED", &cfg.TargetTravelSpeed)
+
+	// Production settings
+	env.Float("SCRAP_RATE", &cfg.ScrapRate)
+	env.Float("ERROR_RATE", &cfg.ErrorRate)
+	env.Int("ORDER_MIN_QTY", &cfg.OrderMinQty)
+	env.Int("ORDER_MAX_QTY", &cfg.OrderMaxQty)
+	env.String("PART_CATALOG_FILE", &cfg.PartCatalogFile)
+	env.String("ERROR_CATALOG_FILE", &cfg.ErrorCatalogFile)
+
+	// Shift settings
+	env.String("TIMEZONE", &cfg.Timezone)
+	env.String("SHIFT_MODEL", &cfg.ShiftModel)
+	env.String("SHIFT_CALENDAR_FILE", &cfg.ShiftCalendarFile)
+	env.String("SHIFT_STOP_POLICY", &cfg.StopPolicy)
+
+	// Sensor health settings
+	env.Float("SENSOR_FAULT_RATE", &cfg.SensorFaultRate)
+	env.Duration("SENSOR_FAULT_MIN_DURATION", &cfg.SensorFaultMinDuration)
+	env.Duration("SENSOR_FAULT_MAX_DURATION", &cfg.SensorFaultMaxDuration)
+
+	// High-rate waveform settings
+	env.Bool("HIGH_RATE_MODE", &cfg.HighRateMode)
+	env.Int("WAVEFORM_SAMPLE_RATE", &cfg.WaveformSampleRate)
+
+	// Thermal settings
+	env.Float("TORCH_RATED_CURRENT", &cfg.TorchRatedCurrent)
+	env.Float("TORCH_DUTY_CYCLE_RATING", &cfg.TorchDutyCycleRating)
+	env.Float("TORCH_MAX_TEMP", &cfg.TorchMaxTemp)
+
+	return env.Err()
+}
+
+// envReader reads typed environment variables and collects parse errors
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) String(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+// List reads a comma-separated list, dropping blank entries
+func (e *envReader) List(key string, target *[]string) {
 	if value := os.Getenv(key); value != "" {
-		return value
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*target = list
 	}
-	return defaultValue
 }
 
-func getEnvAsIntOrDefault(key string, defaultValue int) int {
+func (e *envReader) Int(key string, target *int) {
 	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+		intVal, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s=%q: must be an integer", key, value))
+			return
 		}
+		*target = intVal
 	}
-	return defaultValue
 }
 
-func getEnvAsFloatOrDefault(key string, defaultValue float64) float64 {
+func (e *envReader) Float(key string, target *float64) {
 	if value := os.Getenv(key); value != "" {
-		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
-			return floatVal
+		floatVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s=%q: must be a number", key, value))
+			return
 		}
+		*target = floatVal
 	}
-	return defaultValue
 }
 
-func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+func (e *envReader) Bool(key string, target *bool) {
 	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
+		boolVal, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s=%q: must be true or false", key, value))
+			return
 		}
+		*target = boolVal
+	}
+}
+
+func (e *envReader) Duration(key string, target *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s=%q: must be a duration like 30s or 5m", key, value))
+			return
+		}
+		*target = duration
+	}
+}
+
+// Err returns all collected parse errors
+func (e *envReader) Err() error {
+	if len(e.errs) == 0 {
+		return nil
 	}
-	return defaultValue
+	return fmt.Errorf("invalid environment variables:\n%w", errors.Join(e.errs...))
 }
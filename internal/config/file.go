@@ -0,0 +1,299 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration read from strings like "30s" or "5m"
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value like 30s or 5m", s)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// fileConfig mirrors the nested layout of the configuration file. Pointer
+// fields distinguish values that are absent from the file from zero values.
+type fileConfig struct {
+	Machine *machineSection `yaml:"machine" json:"machine"`
+	Shifts  *shiftSection   `yaml:"shifts" json:"shifts"`
+	Parts   *partSection    `yaml:"parts" json:"parts"`
+	Errors  *errorSection   `yaml:"errors" json:"errors"`
+	Outputs *outputSection  `yaml:"outputs" json:"outputs"`
+}
+
+type machineSection struct {
+	Name               *string          `yaml:"name" json:"name"`
+	PublishInterval    *Duration        `yaml:"publishInterval" json:"publishInterval"`
+	CycleTime          *Duration        `yaml:"cycleTime" json:"cycleTime"`
+	SetupTime          *Duration        `yaml:"setupTime" json:"setupTime"`
+	HighRateMode       *bool            `yaml:"highRateMode" json:"highRateMode"`
+	WaveformSampleRate *int             `yaml:"waveformSampleRate" json:"waveformSampleRate"`
+	Setpoints          *setpointSection `yaml:"setpoints" json:"setpoints"`
+	Torch              *torchSection    `yaml:"torch" json:"torch"`
+}
+
+type setpointSection struct {
+	Current       *float64 `yaml:"current" json:"current"`
+	Voltage       *float64 `yaml:"voltage" json:"voltage"`
+	WireFeedSpeed *float64 `yaml:"wireFeedSpeed" json:"wireFeedSpeed"`
+	GasFlow       *float64 `yaml:"gasFlow" json:"gasFlow"`
+	TravelSpeed   *float64 `yaml:"travelSpeed" json:"travelSpeed"`
+}
+
+type torchSection struct {
+	RatedCurrent    *float64 `yaml:"ratedCurrent" json:"ratedCurrent"`
+	DutyCycleRating *float64 `yaml:"dutyCycleRating" json:"dutyCycleRating"`
+	MaxTemp         *float64 `yaml:"maxTemp" json:"maxTemp"`
+}
+
+type shiftSection struct {
+	Timezone     *string `yaml:"timezone" json:"timezone"`
+	Model        *string `yaml:"model" json:"model"`
+	CalendarFile *string `yaml:"calendarFile" json:"calendarFile"`
+	StopPolicy   *string `yaml:"stopPolicy" json:"stopPolicy"`
+}
+
+type partSection struct {
+	ScrapRate   *float64 `yaml:"scrapRate" json:"scrapRate"`
+	OrderMinQty *int     `yaml:"orderMinQty" json:"orderMinQty"`
+	OrderMaxQty *int     `yaml:"orderMaxQty" json:"orderMaxQty"`
+	CatalogFile *string  `yaml:"catalogFile" json:"catalogFile"`
+}
+
+type errorSection struct {
+	Rate                   *float64  `yaml:"rate" json:"rate"`
+	SensorFaultRate        *float64  `yaml:"sensorFaultRate" json:"sensorFaultRate"`
+	SensorFaultMinDuration *Duration `yaml:"sensorFaultMinDuration" json:"sensorFaultMinDuration"`
+	SensorFaultMaxDuration *Duration `yaml:"sensorFaultMaxDuration" json:"sensorFaultMaxDuration"`
+	CatalogFile            *string   `yaml:"catalogFile" json:"catalogFile"`
+}
+
+type outputSection struct {
+	OPCUA  *opcuaSection  `yaml:"opcua" json:"opcua"`
+	Health *healthSection `yaml:"health" json:"health"`
+	ERP    *erpSection    `yaml:"erp" json:"erp"`
+}
+
+type opcuaSection struct {
+	Port        *int                  `yaml:"port" json:"port"`
+	HistorySize *int                  `yaml:"historySize" json:"historySize"`
+	Security    *opcuaSecuritySection `yaml:"security" json:"security"`
+	PKI         *opcuaPKISection      `yaml:"pki" json:"pki"`
+	NodeSet     *opcuaNodeSetSection  `yaml:"nodeset" json:"nodeset"`
+}
+
+type opcuaSecuritySection struct {
+	Policies        []string `yaml:"policies" json:"policies"`
+	Modes           []string `yaml:"modes" json:"modes"`
+	AllowAnonymous  *bool    `yaml:"allowAnonymous" json:"allowAnonymous"`
+	Users           []string `yaml:"users" json:"users"`
+	X509Users       *bool    `yaml:"x509Users" json:"x509Users"`
+	TrustAllClients *bool    `yaml:"trustAllClients" json:"trustAllClients"`
+}
+
+type opcuaPKISection struct {
+	Dir         *string   `yaml:"dir" json:"dir"`
+	CertFile    *string   `yaml:"certFile" json:"certFile"`
+	KeyFile     *string   `yaml:"keyFile" json:"keyFile"`
+	Hostnames   []string  `yaml:"hostnames" json:"hostnames"`
+	IPs         []string  `yaml:"ips" json:"ips"`
+	Lifetime    *Duration `yaml:"lifetime" json:"lifetime"`
+	RenewBefore *Duration `yaml:"renewBefore" json:"renewBefore"`
+}
+
+type opcuaNodeSetSection struct {
+	Companions []string `yaml:"companions" json:"companions"`
+	File       *string  `yaml:"file" json:"file"`
+	Bindings   []string `yaml:"bindings" json:"bindings"`
+}
+
+type healthSection struct {
+	Port *int `yaml:"port" json:"port"`
+}
+
+type erpSection struct {
+	Endpoint  *string `yaml:"endpoint" json:"endpoint"`
+	OrderPath *string `yaml:"orderPath" json:"orderPath"`
+	ShiftPath *string `yaml:"shiftPath" json:"shiftPath"`
+}
+
+// DecodeFile decodes a YAML or JSON file into v, choosing the format by
+// extension. Unknown fields are rejected so typos surface as errors.
+func DecodeFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported file format %q for %s (use .yaml, .yml or .json)", filepath.Ext(path), path)
+	}
+
+	return nil
+}
+
+// loadFile applies the values present in a configuration file to cfg
+func loadFile(path string, cfg *Config) error {
+	var fc fileConfig
+	if err := DecodeFile(path, &fc); err != nil {
+		return err
+	}
+
+	if m := fc.Machine; m != nil {
+		setString(&cfg.SimulatorName, m.Name)
+		setDuration(&cfg.PublishInterval, m.PublishInterval)
+		setDuration(&cfg.CycleTime, m.CycleTime)
+		setDuration(&cfg.SetupTime, m.SetupTime)
+		setBool(&cfg.HighRateMode, m.HighRateMode)
+		setInt(&cfg.WaveformSampleRate, m.WaveformSampleRate)
+		if sp := m.Setpoints; sp != nil {
+			setFloat(&cfg.TargetCurrent, sp.Current)
+			setFloat(&cfg.TargetVoltage, sp.Voltage)
+			setFloat(&cfg.TargetWireFeedSpeed, sp.WireFeedSpeed)
+			setFloat(&cfg.TargetGasFlow, sp.GasFlow)
+			setFloat(&cfg.TargetTravelSpeed, sp.TravelSpeed)
+		}
+		if t := m.Torch; t != nil {
+			setFloat(&cfg.TorchRatedCurrent, t.RatedCurrent)
+			setFloat(&cfg.TorchDutyCycleRating, t.DutyCycleRating)
+			setFloat(&cfg.TorchMaxTemp, t.MaxTemp)
+		}
+	}
+
+	if s := fc.Shifts; s != nil {
+		setString(&cfg.Timezone, s.Timezone)
+		setString(&cfg.ShiftModel, s.Model)
+		setString(&cfg.ShiftCalendarFile, s.CalendarFile)
+		setString(&cfg.StopPolicy, s.StopPolicy)
+	}
+
+	if p := fc.Parts; p != nil {
+		setFloat(&cfg.ScrapRate, p.ScrapRate)
+		setInt(&cfg.OrderMinQty, p.OrderMinQty)
+		setInt(&cfg.OrderMaxQty, p.OrderMaxQty)
+		setString(&cfg.PartCatalogFile, p.CatalogFile)
+	}
+
+	if e := fc.Errors; e != nil {
+		setFloat(&cfg.ErrorRate, e.Rate)
+		setFloat(&cfg.SensorFaultRate, e.SensorFaultRate)
+		setDuration(&cfg.SensorFaultMinDuration, e.SensorFaultMinDuration)
+		setDuration(&cfg.SensorFaultMaxDuration, e.SensorFaultMaxDuration)
+		setString(&cfg.ErrorCatalogFile, e.CatalogFile)
+	}
+
+	if o := fc.Outputs; o != nil {
+		if o.OPCUA != nil {
+			setInt(&cfg.OPCUAPort, o.OPCUA.Port)
+			setInt(&cfg.OPCUAHistorySize, o.OPCUA.HistorySize)
+			if sec := o.OPCUA.Security; sec != nil {
+				setStrings(&cfg.OPCUASecurityPolicies, sec.Policies)
+				setStrings(&cfg.OPCUASecurityModes, sec.Modes)
+				setBool(&cfg.OPCUAAllowAnonymous, sec.AllowAnonymous)
+				setStrings(&cfg.OPCUAUsers, sec.Users)
+				setBool(&cfg.OPCUAX509Users, sec.X509Users)
+				setBool(&cfg.OPCUATrustAllClients, sec.TrustAllClients)
+			}
+			if pki := o.OPCUA.PKI; pki != nil {
+				setString(&cfg.OPCUAPKIDir, pki.Dir)
+				setString(&cfg.OPCUACertFile, pki.CertFile)
+				setString(&cfg.OPCUAKeyFile, pki.KeyFile)
+				setStrings(&cfg.OPCUACertHostnames, pki.Hostnames)
+				setStrings(&cfg.OPCUACertIPs, pki.IPs)
+				setDuration(&cfg.OPCUACertLifetime, pki.Lifetime)
+				setDuration(&cfg.OPCUACertRenewBefore, pki.RenewBefore)
+			}
+			if ns := o.OPCUA.NodeSet; ns != nil {
+				setStrings(&cfg.OPCUACompanionNodeSets, ns.Companions)
+				setString(&cfg.OPCUANodeSetFile, ns.File)
+				setStrings(&cfg.OPCUANodeSetBindings, ns.Bindings)
+			}
+		}
+		if o.Health != nil {
+			setInt(&cfg.HealthPort, o.Health.Port)
+		}
+		if o.ERP != nil {
+			setString(&cfg.ERPEndpoint, o.ERP.Endpoint)
+			setString(&cfg.ERPOrderPath, o.ERP.OrderPath)
+			setString(&cfg.ERPShiftPath, o.ERP.ShiftPath)
+		}
+	}
+
+	return nil
+}
+
+func setString(target *string, value *string) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setInt(target *int, value *int) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setFloat(target *float64, value *float64) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setBool(target *bool, value *bool) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setStrings(target *[]string, value []string) {
+	if value != nil {
+		*target = value
+	}
+}
+
+func setDuration(target *time.Duration, value *Duration) {
+	if value != nil {
+		*target = time.Duration(*value)
+	}
+}
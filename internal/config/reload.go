@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+)
+
+// ApplyReload copies the settings that can change at runtime from next into
+// c and returns the names of changed settings that only take effect after a
+// restart
+func (c *Config) ApplyReload(next *Config) (restartRequired []string) {
+	restartIfChanged := func(name string, changed bool) {
+		if changed {
+			restartRequired = append(restartRequired, name)
+		}
+	}
+	restartIfChanged("SIMULATOR_NAME", next.SimulatorName != c.SimulatorName)
+	restartIfChanged("OPCUA_PORT", next.OPCUAPort != c.OPCUAPort)
+	restartIfChanged("OPCUA_HISTORY_SIZE", next.OPCUAHistorySize != c.OPCUAHistorySize)
+	restartIfChanged("OPCUA_SECURITY_POLICIES", !slices.Equal(next.OPCUASecurityPolicies, c.OPCUASecurityPolicies))
+	restartIfChanged("OPCUA_SECURITY_MODES", !slices.Equal(next.OPCUASecurityModes, c.OPCUASecurityModes))
+	restartIfChanged("OPCUA_ALLOW_ANONYMOUS", next.OPCUAAllowAnonymous != c.OPCUAAllowAnonymous)
+	restartIfChanged("OPCUA_USERS", !slices.Equal(next.OPCUAUsers, c.OPCUAUsers))
+	restartIfChanged("OPCUA_X509_USERS", next.OPCUAX509Users != c.OPCUAX509Users)
+	restartIfChanged("OPCUA_TRUST_ALL_CLIENTS", next.OPCUATrustAllClients != c.OPCUATrustAllClients)
+	restartIfChanged("OPCUA_PKI_DIR", next.OPCUAPKIDir != c.OPCUAPKIDir)
+	restartIfChanged("OPCUA_CERT_FILE", next.OPCUACertFile != c.OPCUACertFile)
+	restartIfChanged("OPCUA_KEY_FILE", next.OPCUAKeyFile != c.OPCUAKeyFile)
+	restartIfChanged("OPCUA_CERT_HOSTNAMES", !slices.Equal(next.OPCUACertHostnames, c.OPCUACertHostnames))
+	restartIfChanged("OPCUA_CERT_IPS", !slices.Equal(next.OPCUACertIPs, c.OPCUACertIPs))
+	restartIfChanged("OPCUA_CERT_LIFETIME", next.OPCUACertLifetime != c.OPCUACertLifetime)
+	restartIfChanged("OPCUA_CERT_RENEW_BEFORE", next.OPCUACertRenewBefore != c.OPCUACertRenewBefore)
+	restartIfChanged("OPCUA_COMPANION_NODESETS", !slices.Equal(next.OPCUACompanionNodeSets, c.OPCUACompanionNodeSets))
+	restartIfChanged("OPCUA_NODESET_FILE", next.OPCUANodeSetFile != c.OPCUANodeSetFile)
+	restartIfChanged("OPCUA_NODESET_BINDINGS", !slices.Equal(next.OPCUANodeSetBindings, c.OPCUANodeSetBindings))
+	restartIfChanged("HEALTH_PORT", next.HealthPort != c.HealthPort)
+	restartIfChanged("PUBLISH_INTERVAL", next.PublishInterval != c.PublishInterval)
+	restartIfChanged("HIGH_RATE_MODE", next.HighRateMode != c.HighRateMode)
+	restartIfChanged("WAVEFORM_SAMPLE_RATE", next.WaveformSampleRate != c.WaveformSampleRate)
+
+	// Outputs
+	c.ERPEndpoint = next.ERPEndpoint
+	c.ERPOrderPath = next.ERPOrderPath
+	c.ERPShiftPath = next.ERPShiftPath
+
+	// Timing
+	c.CycleTime = next.CycleTime
+	c.SetupTime = next.SetupTime
+
+	// Setpoints
+	c.TargetCurrent = next.TargetCurrent
+	c.TargetVoltage = next.TargetVoltage
+	c.TargetWireFeedSpeed = next.TargetWireFeedSpeed
+	c.TargetGasFlow = next.TargetGasFlow
+	c.TargetTravelSpeed = next.TargetTravelSpeed
+
+	// Rates
+	c.ScrapRate = next.ScrapRate
+	c.ErrorRate = next.ErrorRate
+	c.OrderMinQty = next.OrderMinQty
+	c.OrderMaxQty = next.OrderMaxQty
+	c.PartCatalogFile = next.PartCatalogFile
+	c.ErrorCatalogFile = next.ErrorCatalogFile
+	c.SensorFaultRate = next.SensorFaultRate
+	c.SensorFaultMinDuration = next.SensorFaultMinDuration
+	c.SensorFaultMaxDuration = next.SensorFaultMaxDuration
+
+	// Shift model
+	c.Timezone = next.Timezone
+	c.ShiftModel = next.ShiftModel
+	c.ShiftCalendarFile = next.ShiftCalendarFile
+	c.StopPolicy = next.StopPolicy
+
+	// Thermal limits
+	c.TorchRatedCurrent = next.TorchRatedCurrent
+	c.TorchDutyCycleRating = next.TorchDutyCycleRating
+	c.TorchMaxTemp = next.TorchMaxTemp
+
+	return restartRequired
+}
+
+// WatchFile polls a file and signals on the returned channel whenever its
+// modification time or size changes. The channel is closed when ctx ends.
+func WatchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer close(changes)
+
+		var lastMod time.Time
+		var lastSize int64
+		if info, err := os.Stat(path); err == nil {
+			lastMod, lastSize = info.ModTime(), info.Size()
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+					continue
+				}
+				lastMod, lastSize = info.ModTime(), info.Size()
+
+				// Coalesce bursts of writes into one pending reload
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// FileWatcher watches a file whose path can change on reload
+type FileWatcher struct {
+	ctx      context.Context
+	interval time.Duration
+	path     string
+	cancel   context.CancelFunc
+	changes  <-chan struct{}
+}
+
+// NewFileWatcher watches path as WatchFile does. An empty path watches
+// nothing until SetPath is called with a file.
+func NewFileWatcher(ctx context.Context, path string, interval time.Duration) *FileWatcher {
+	w := &FileWatcher{ctx: ctx, interval: interval}
+	w.SetPath(path)
+	return w
+}
+
+// Changes returns the channel signalling changes of the watched file, nil
+// if no file is watched. It must be called again after SetPath.
+func (w *FileWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// SetPath stops watching the current file and watches path instead. It does
+// nothing if path is unchanged.
+func (w *FileWatcher) SetPath(path string) {
+	if path == w.path {
+		return
+	}
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+	w.path = path
+	w.changes = nil
+	if path == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.cancel = cancel
+	w.changes = WatchFile(ctx, path, w.interval)
+}
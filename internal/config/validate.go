@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ShiftModels lists the supported shift models
+var ShiftModels = []string{"1-shift", "2-shift", "3-shift"}
+
+// Stop policies for a cycle in progress when a break or the shift end begins
+const (
+	StopPolicyFinishCycle = "finish-cycle" // Weld the part to completion, then stop
+	StopPolicyAbortCycle  = "abort-cycle"  // Stop immediately and scrap the part
+)
+
+// StopPolicies lists the supported stop policies
+var StopPolicies = []string{StopPolicyFinishCycle, StopPolicyAbortCycle}
+
+// OPC UA security policies the server can accept
+const (
+	SecurityPolicyNone                = "None"
+	SecurityPolicyBasic256Sha256      = "Basic256Sha256"
+	SecurityPolicyAes128Sha256RsaOaep = "Aes128_Sha256_RsaOaep"
+	SecurityPolicyAes256Sha256RsaPss  = "Aes256_Sha256_RsaPss"
+)
+
+// SecurityPolicies lists the supported OPC UA security policies
+var SecurityPolicies = []string{SecurityPolicyNone, SecurityPolicyBasic256Sha256,
+	SecurityPolicyAes128Sha256RsaOaep, SecurityPolicyAes256Sha256RsaPss}
+
+// OPC UA message security modes of the secured policies
+const (
+	SecurityModeSign           = "Sign"
+	SecurityModeSignAndEncrypt = "SignAndEncrypt"
+)
+
+// SecurityModes lists the supported OPC UA message security modes
+var SecurityModes = []string{SecurityModeSign, SecurityModeSignAndEncrypt}
+
+// Range is an engineering range of values
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Contains reports whether value lies within the range
+func (r Range) Contains(value float64) bool {
+	return value >= r.Min && value <= r.Max
+}
+
+// Engineering ranges of the setpoints, those of a 500 A MIG/MAG power source
+// with a 1.2 mm wire
+var (
+	CurrentRange       = Range{Min: 30, Max: 500} // A
+	VoltageRange       = Range{Min: 12, Max: 40}  // V
+	WireFeedSpeedRange = Range{Min: 1, Max: 20}   // m/min
+	GasFlowRange       = Range{Min: 5, Max: 30}   // l/min
+	TravelSpeedRange   = Range{Min: 1, Max: 30}   // mm/s
+)
+
+// Validate checks the configuration and reports every invalid value with
+// its file key and environment variable
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	// Core settings
+	v.check(strings.TrimSpace(c.SimulatorName) != "",
+		"machine.name (SIMULATOR_NAME) must not be empty")
+	v.port("outputs.opcua.port (OPCUA_PORT)", c.OPCUAPort)
+	v.port("outputs.health.port (HEALTH_PORT)", c.HealthPort)
+	v.check(c.OPCUAPort != c.HealthPort,
+		"outputs.opcua.port (OPCUA_PORT) and outputs.health.port (HEALTH_PORT) must differ, both are %d", c.OPCUAPort)
+	v.check(c.OPCUAHistorySize >= 0 && c.OPCUAHistorySize <= 1000000,
+		"outputs.opcua.historySize (OPCUA_HISTORY_SIZE) must be between 0 and 1000000, got %d", c.OPCUAHistorySize)
+
+	// OPC UA security settings
+	v.check(len(c.OPCUASecurityPolicies) > 0,
+		"outputs.opcua.security.policies (OPCUA_SECURITY_POLICIES) must not be empty")
+	secured := false
+	for _, policy := range c.OPCUASecurityPolicies {
+		v.check(contains(SecurityPolicies, policy),
+			"outputs.opcua.security.policies (OPCUA_SECURITY_POLICIES) entries must be one of %s, got %q",
+			strings.Join(SecurityPolicies, ", "), policy)
+		secured = secured || policy != SecurityPolicyNone
+	}
+	v.check(!secured || len(c.OPCUASecurityModes) > 0,
+		"outputs.opcua.security.modes (OPCUA_SECURITY_MODES) must not be empty when a secured policy is enabled")
+	for _, mode := range c.OPCUASecurityModes {
+		v.check(contains(SecurityModes, mode),
+			"outputs.opcua.security.modes (OPCUA_SECURITY_MODES) entries must be one of %s, got %q",
+			strings.Join(SecurityModes, ", "), mode)
+	}
+	users := map[string]bool{}
+	for i, user := range c.OPCUAUsers {
+		// Never echo the entry, it may contain a password
+		name, password, ok := strings.Cut(user, ":")
+		if !ok || name == "" || password == "" {
+			v.fail("outputs.opcua.security.users (OPCUA_USERS) entry %d must be user:password", i+1)
+			continue
+		}
+		v.check(!users[name], "outputs.opcua.security.users (OPCUA_USERS) lists user %q twice", name)
+		users[name] = true
+	}
+	v.check(c.OPCUAAllowAnonymous || len(c.OPCUAUsers) > 0 || c.OPCUAX509Users,
+		"outputs.opcua.security needs at least one of allowAnonymous (OPCUA_ALLOW_ANONYMOUS), users (OPCUA_USERS) or x509Users (OPCUA_X509_USERS)")
+
+	// OPC UA certificate settings
+	v.check(strings.TrimSpace(c.OPCUAPKIDir) != "",
+		"outputs.opcua.pki.dir (OPCUA_PKI_DIR) must not be empty")
+	v.check((c.OPCUACertFile == "") == (c.OPCUAKeyFile == ""),
+		"outputs.opcua.pki.certFile (OPCUA_CERT_FILE) and outputs.opcua.pki.keyFile (OPCUA_KEY_FILE) must be set together")
+	v.readable("outputs.opcua.pki.certFile (OPCUA_CERT_FILE)", c.OPCUACertFile)
+	v.readable("outputs.opcua.pki.keyFile (OPCUA_KEY_FILE)", c.OPCUAKeyFile)
+	for _, ip := range c.OPCUACertIPs {
+		v.check(net.ParseIP(ip) != nil,
+			"outputs.opcua.pki.ips (OPCUA_CERT_IPS) entries must be IP addresses, got %q", ip)
+	}
+	v.check(c.OPCUACertRenewBefore > 0,
+		"outputs.opcua.pki.renewBefore (OPCUA_CERT_RENEW_BEFORE) must be positive, got %s", c.OPCUACertRenewBefore)
+	v.check(c.OPCUACertLifetime > c.OPCUACertRenewBefore,
+		"outputs.opcua.pki.lifetime (OPCUA_CERT_LIFETIME) must be longer than outputs.opcua.pki.renewBefore %s, got %s",
+		c.OPCUACertRenewBefore, c.OPCUACertLifetime)
+
+	// OPC UA custom nodes
+	for _, path := range c.OPCUACompanionNodeSets {
+		v.readable("outputs.opcua.nodeset.companions (OPCUA_COMPANION_NODESETS)", path)
+	}
+	v.readable("outputs.opcua.nodeset.file (OPCUA_NODESET_FILE)", c.OPCUANodeSetFile)
+	v.check(c.OPCUANodeSetFile != "" || len(c.OPCUANodeSetBindings) == 0,
+		"outputs.opcua.nodeset.bindings (OPCUA_NODESET_BINDINGS) need outputs.opcua.nodeset.file (OPCUA_NODESET_FILE)")
+	bound := map[string]bool{}
+	for _, binding := range c.OPCUANodeSetBindings {
+		signal, nodeID, ok := strings.Cut(binding, "=")
+		if !ok || strings.TrimSpace(signal) == "" || strings.TrimSpace(nodeID) == "" {
+			v.fail("outputs.opcua.nodeset.bindings (OPCUA_NODESET_BINDINGS) entries must be signal=nodeId, got %q", binding)
+			continue
+		}
+		v.check(!bound[nodeID], "outputs.opcua.nodeset.bindings (OPCUA_NODESET_BINDINGS) binds %s twice", nodeID)
+		bound[nodeID] = true
+	}
+
+	// ERP settings
+	if u, err := url.Parse(c.ERPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail("outputs.erp.endpoint (ERP_ENDPOINT) must be an http:// or https:// URL, got %q", c.ERPEndpoint)
+	}
+	v.check(strings.HasPrefix(c.ERPOrderPath, "/"),
+		"outputs.erp.orderPath (ERP_ORDER_PATH) must start with /, got %q", c.ERPOrderPath)
+	v.check(strings.HasPrefix(c.ERPShiftPath, "/"),
+		"outputs.erp.shiftPath (ERP_SHIFT_PATH) must start with /, got %q", c.ERPShiftPath)
+
+	// Timing settings
+	v.check(c.PublishInterval >= 10*time.Millisecond,
+		"machine.publishInterval (PUBLISH_INTERVAL) must be at least 10ms, got %s", c.PublishInterval)
+	v.check(c.CycleTime >= c.PublishInterval,
+		"machine.cycleTime (CYCLE_TIME) must be at least the publish interval %s, got %s", c.PublishInterval, c.CycleTime)
+	v.check(c.SetupTime >= 0,
+		"machine.setupTime (SETUP_TIME) must not be negative, got %s", c.SetupTime)
+
+	// Default welding setpoints
+	v.within("machine.setpoints.current (SETPOINT_CURRENT)", c.TargetCurrent, CurrentRange)
+	v.within("machine.setpoints.voltage (SETPOINT_VOLTAGE)", c.TargetVoltage, VoltageRange)
+	v.within("machine.setpoints.wireFeedSpeed (SETPOINT_WIRE_FEED_SPEED)", c.TargetWireFeedSpeed, WireFeedSpeedRange)
+	v.within("machine.setpoints.gasFlow (SETPOINT_GAS_FLOW)", c.TargetGasFlow, GasFlowRange)
+	v.within("machine.setpoints.travelSpeed (SETPOINT_TRAVEL_SPEED)", c.TargetTravelSpeed, TravelSpeedRange)
+
+	// Production settings
+	v.fraction("parts.scrapRate (SCRAP_RATE)", c.ScrapRate)
+	v.fraction("errors.rate (ERROR_RATE)", c.ErrorRate)
+	v.check(c.OrderMinQty >= 1,
+		"parts.orderMinQty (ORDER_MIN_QTY) must be at least 1, got %d", c.OrderMinQty)
+	v.check(c.OrderMaxQty >= c.OrderMinQty,
+		"parts.orderMaxQty (ORDER_MAX_QTY) must be at least parts.orderMinQty (ORDER_MIN_QTY) %d, got %d", c.OrderMinQty, c.OrderMaxQty)
+	v.readable("parts.catalogFile (PART_CATALOG_FILE)", c.PartCatalogFile)
+	v.readable("errors.catalogFile (ERROR_CATALOG_FILE)", c.ErrorCatalogFile)
+
+	// Shift settings
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		v.fail("shifts.timezone (TIMEZONE) %q is not a known IANA timezone", c.Timezone)
+	}
+	v.check(contains(ShiftModels, c.ShiftModel),
+		"shifts.model (SHIFT_MODEL) must be one of %s, got %q", strings.Join(ShiftModels, ", "), c.ShiftModel)
+	v.check(contains(StopPolicies, c.StopPolicy),
+		"shifts.stopPolicy (SHIFT_STOP_POLICY) must be one of %s, got %q", strings.Join(StopPolicies, ", "), c.StopPolicy)
+	v.readable("shifts.calendarFile (SHIFT_CALENDAR_FILE)", c.ShiftCalendarFile)
+
+	// Sensor health settings
+	v.fraction("errors.sensorFaultRate (SENSOR_FAULT_RATE)", c.SensorFaultRate)
+	v.check(c.SensorFaultMinDuration > 0,
+		"errors.sensorFaultMinDuration (SENSOR_FAULT_MIN_DURATION) must be positive, got %s", c.SensorFaultMinDuration)
+	v.check(c.SensorFaultMaxDuration >= c.SensorFaultMinDuration,
+		"errors.sensorFaultMaxDuration (SENSOR_FAULT_MAX_DURATION) must be at least errors.sensorFaultMinDuration %s, got %s",
+		c.SensorFaultMinDuration, c.SensorFaultMaxDuration)
+
+	// High-rate waveform settings
+	v.check(c.WaveformSampleRate >= 100 && c.WaveformSampleRate <= 100000,
+		"machine.waveformSampleRate (WAVEFORM_SAMPLE_RATE) must be between 100 and 100000 Hz, got %d", c.WaveformSampleRate)
+	if c.HighRateMode {
+		samples := c.PublishInterval.Seconds() * float64(c.WaveformSampleRate)
+		v.check(samples <= 1000000,
+			"machine.waveformSampleRate (WAVEFORM_SAMPLE_RATE) × machine.publishInterval (PUBLISH_INTERVAL) must not exceed 1000000 samples per window, got %.0f", samples)
+	}
+
+	// Thermal settings
+	v.check(c.TorchRatedCurrent > 0,
+		"machine.torch.ratedCurrent (TORCH_RATED_CURRENT) must be positive, got %g", c.TorchRatedCurrent)
+	v.check(c.TorchDutyCycleRating > 0 && c.TorchDutyCycleRating <= 1,
+		"machine.torch.dutyCycleRating (TORCH_DUTY_CYCLE_RATING) must be in (0, 1], got %g", c.TorchDutyCycleRating)
+	v.check(c.TorchMaxTemp > 20,
+		"machine.torch.maxTemp (TORCH_MAX_TEMP) must be above the 20 °C coolant supply temperature, got %g", c.TorchMaxTemp)
+
+	return v.err()
+}
+
+// validator collects validation failures
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.fail(format, args...)
+	}
+}
+
+func (v *validator) port(name string, port int) {
+	v.check(port >= 1 && port <= 65535, "%s must be between 1 and 65535, got %d", name, port)
+}
+
+func (v *validator) within(name string, value float64, r Range) {
+	v.check(r.Contains(value), "%s must be between %g and %g, got %g", name, r.Min, r.Max, value)
+}
+
+func (v *validator) fraction(name string, value float64) {
+	v.check(value >= 0 && value <= 1, "%s must be between 0 and 1, got %g", name, value)
+}
+
+func (v *validator) readable(name, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.fail("%s %q cannot be read: %v", name, path, err)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n%w", errors.Join(v.errs...))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// commandTimeout bounds how long a request waits for the simulation loop
+const commandTimeout = 5 * time.Second
+
+// Command is a control action queued by the API. Commands are executed by
+// the simulation loop so they never race with a tick.
+type Command struct {
+	run  func(now time.Time) (interface{}, error)
+	done chan commandResult
+}
+
+type commandResult struct {
+	body []byte
+	err  error
+}
+
+// Execute runs the command and hands its result back to the waiting request.
+// The result is encoded here so it is a consistent snapshot of this tick.
+func (c Command) Execute(now time.Time) {
+	value, err := c.run(now)
+	if err != nil {
+		c.done <- commandResult{err: err}
+		return
+	}
+	body, err := json.Marshal(value)
+	c.done <- commandResult{body: body, err: err}
+}
+
+// conflictError reports a request that is valid but not possible in the
+// current simulator state
+type conflictError struct {
+	err error
+}
+
+func (e conflictError) Error() string { return e.err.Error() }
+
+// invalidError reports a request the simulation loop found invalid, e.g. an
+// error code missing from the current error catalog
+type invalidError struct {
+	err error
+}
+
+func (e invalidError) Error() string { return e.err.Error() }
+
+// Targets are the welding setpoints of the timeseries generator
+type Targets struct {
+	Current       *float64 `json:"current"`
+	Voltage       *float64 `json:"voltage"`
+	WireFeedSpeed *float64 `json:"wireFeedSpeed"`
+	GasFlow       *float64 `json:"gasFlow"`
+	TravelSpeed   *float64 `json:"travelSpeed"`
+}
+
+// Rates are the scrap and error probabilities
+type Rates struct {
+	ScrapRate *float64 `json:"scrapRate"`
+	ErrorRate *float64 `json:"errorRate"`
+}
+
+// TransitionRequest forces the machine into a state. Hold keeps Idle,
+// PlannedStop or NotScheduled for a duration like "10m", or "indefinite".
+type TransitionRequest struct {
+	State *simulator.MachineState `json:"state"`
+	Hold  string                  `json:"hold"`
+}
+
+// ErrorRequest injects an error
+type ErrorRequest struct {
+	Code simulator.ErrorCode `json:"code"`
+}
+
+// Handler serves the runtime control API under /api/v1
+type Handler struct {
+	cfg          *config.Config
+	stateMachine *simulator.StateMachine
+	generator    *simulator.TimeseriesGenerator
+	commands     chan Command
+}
+
+// NewHandler creates a new control API handler
+func NewHandler(cfg *config.Config, stateMachine *simulator.StateMachine, generator *simulator.TimeseriesGenerator) *Handler {
+	return &Handler{
+		cfg:          cfg,
+		stateMachine: stateMachine,
+		generator:    generator,
+		commands:     make(chan Command),
+	}
+}
+
+// Commands returns the queue of control commands for the simulation loop
+func (h *Handler) Commands() <-chan Command {
+	return h.commands
+}
+
+// Register adds the control endpoints to mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/state", h.HandleGetState)
+	mux.HandleFunc("POST /api/v1/pause", h.HandlePause)
+	mux.HandleFunc("POST /api/v1/resume", h.HandleResume)
+	mux.HandleFunc("POST /api/v1/transition", h.HandleTransition)
+	mux.HandleFunc("POST /api/v1/errors", h.HandleInjectError)
+	mux.HandleFunc("GET /api/v1/events", h.HandleGetEvents)
+	mux.HandleFunc("GET /api/v1/targets", h.HandleGetTargets)
+	mux.HandleFunc("PUT /api/v1/targets", h.HandleSetTargets)
+	mux.HandleFunc("GET /api/v1/rates", h.HandleGetRates)
+	mux.HandleFunc("PUT /api/v1/rates", h.HandleSetRates)
+}
+
+// HandleGetState returns the full simulator state
+func (h *Handler) HandleGetState(w http.ResponseWriter, r *http.Request) {
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		return h.stateMachine.GetState(), nil
+	})
+}
+
+// HandlePause freezes the simulation
+func (h *Handler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		if !h.stateMachine.Pause(now) {
+			return nil, conflictError{errors.New("simulation is already paused")}
+		}
+		log.Info().Msg("Simulation paused via control API")
+		return h.stateMachine.GetState(), nil
+	})
+}
+
+// HandleResume continues a paused simulation
+func (h *Handler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		if !h.stateMachine.Resume(now) {
+			return nil, conflictError{errors.New("simulation is not paused")}
+		}
+		log.Info().Msg("Simulation resumed via control API")
+		return h.stateMachine.GetState(), nil
+	})
+}
+
+// HandleTransition forces a state transition
+func (h *Handler) HandleTransition(w http.ResponseWriter, r *http.Request) {
+	var req TransitionRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.State == nil {
+		writeError(w, http.StatusBadRequest, errors.New("state is required"))
+		return
+	}
+	to := *req.State
+
+	var hold time.Duration
+	switch req.Hold {
+	case "":
+	case "indefinite":
+		hold = -1
+	default:
+		d, err := time.ParseDuration(req.Hold)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("hold must be a positive duration like 10m or \"indefinite\", got %q", req.Hold))
+			return
+		}
+		hold = d
+	}
+
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		from := h.stateMachine.State()
+		if err := h.stateMachine.ForceState(to, hold, now); err != nil {
+			return nil, conflictError{err}
+		}
+		log.Info().
+			Str("from", from.String()).
+			Str("to", to.String()).
+			Str("hold", req.Hold).
+			Msg("State forced via control API")
+		return h.stateMachine.GetState(), nil
+	})
+}
+
+// HandleInjectError raises a specific error
+func (h *Handler) HandleInjectError(w http.ResponseWriter, r *http.Request) {
+	var req ErrorRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		if err := h.stateMachine.TriggerError(req.Code, now); err != nil {
+			return nil, invalidError{err}
+		}
+		log.Info().Str("code", string(req.Code)).Msg("Error injected via control API")
+		return h.stateMachine.GetState(), nil
+	})
+}
+
+// HandleGetEvents returns the event log, optionally only the events after
+// the ID given by ?since=
+func (h *Handler) HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("since must be an event ID, got %q", s))
+			return
+		}
+		since = id
+	}
+
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		return h.stateMachine.Events(since), nil
+	})
+}
+
+// HandleGetTargets returns the current welding setpoints
+func (h *Handler) HandleGetTargets(w http.ResponseWriter, r *http.Request) {
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		return h.targets(), nil
+	})
+}
+
+// HandleSetTargets changes the welding setpoints. Omitted fields are kept.
+func (h *Handler) HandleSetTargets(w http.ResponseWriter, r *http.Request) {
+	var req Targets
+	if !decode(w, r, &req) {
+		return
+	}
+	for _, f := range []struct {
+		name  string
+		value *float64
+		valid simulator.Range
+	}{
+		{"current", req.Current, simulator.CurrentRange},
+		{"voltage", req.Voltage, simulator.VoltageRange},
+		{"wireFeedSpeed", req.WireFeedSpeed, simulator.WireFeedSpeedRange},
+		{"gasFlow", req.GasFlow, simulator.GasFlowRange},
+		{"travelSpeed", req.TravelSpeed, simulator.TravelSpeedRange},
+	} {
+		if f.value != nil && !f.valid.Contains(*f.value) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be between %g and %g, got %g",
+				f.name, f.valid.Min, f.valid.Max, *f.value))
+			return
+		}
+	}
+
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		tg := h.generator
+		tg.SetTargets(
+			valueOr(req.Current, tg.TargetCurrent),
+			valueOr(req.Voltage, tg.TargetVoltage),
+			valueOr(req.WireFeedSpeed, tg.TargetWireFeedSpeed),
+			valueOr(req.GasFlow, tg.TargetGasFlow),
+			valueOr(req.TravelSpeed, tg.TargetTravelSpeed),
+		)
+		log.Info().
+			Float64("current", tg.TargetCurrent).
+			Float64("voltage", tg.TargetVoltage).
+			Msg("Targets changed via control API")
+		return h.targets(), nil
+	})
+}
+
+// HandleGetRates returns the scrap and error rates
+func (h *Handler) HandleGetRates(w http.ResponseWriter, r *http.Request) {
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		return h.rates(), nil
+	})
+}
+
+// HandleSetRates changes the scrap and error rates. Omitted fields are kept.
+func (h *Handler) HandleSetRates(w http.ResponseWriter, r *http.Request) {
+	var req Rates
+	if !decode(w, r, &req) {
+		return
+	}
+	for name, value := range map[string]*float64{
+		"scrapRate": req.ScrapRate,
+		"errorRate": req.ErrorRate,
+	} {
+		if value != nil && (*value < 0 || *value > 1) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be between 0 and 1, got %g", name, *value))
+			return
+		}
+	}
+
+	h.execute(w, r, func(now time.Time) (interface{}, error) {
+		h.cfg.ScrapRate = valueOr(req.ScrapRate, h.cfg.ScrapRate)
+		h.cfg.ErrorRate = valueOr(req.ErrorRate, h.cfg.ErrorRate)
+		log.Info().
+			Float64("scrapRate", h.cfg.ScrapRate).
+			Float64("errorRate", h.cfg.ErrorRate).
+			Msg("Rates changed via control API")
+		return h.rates(), nil
+	})
+}
+
+func (h *Handler) targets() Targets {
+	tg := h.generator
+	return Targets{
+		Current:       &tg.TargetCurrent,
+		Voltage:       &tg.TargetVoltage,
+		WireFeedSpeed: &tg.TargetWireFeedSpeed,
+		GasFlow:       &tg.TargetGasFlow,
+		TravelSpeed:   &tg.TargetTravelSpeed,
+	}
+}
+
+func (h *Handler) rates() Rates {
+	return Rates{
+		ScrapRate: &h.cfg.ScrapRate,
+		ErrorRate: &h.cfg.ErrorRate,
+	}
+}
+
+// execute queues fn for the simulation loop and writes its result
+func (h *Handler) execute(w http.ResponseWriter, r *http.Request, fn func(now time.Time) (interface{}, error)) {
+	ctx, cancel := context.WithTimeout(r.Context(), commandTimeout)
+	defer cancel()
+
+	cmd := Command{run: fn, done: make(chan commandResult, 1)}
+	select {
+	case h.commands <- cmd:
+	case <-ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, errors.New("simulation loop is not accepting commands"))
+		return
+	}
+
+	// Once queued the command always completes within one tick
+	result := <-cmd.done
+
+	var conflict conflictError
+	var invalid invalidError
+	switch {
+	case errors.As(result.err, &conflict):
+		writeError(w, http.StatusConflict, result.err)
+	case errors.As(result.err, &invalid):
+		writeError(w, http.StatusBadRequest, result.err)
+	case result.err != nil:
+		writeError(w, http.StatusInternalServerError, result.err)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(result.body)
+	}
+}
+
+// decode reads a JSON request body, rejecting unknown fields
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func valueOr(value *float64, fallback float64) float64 {
+	if value != nil {
+		return *value
+	}
+	return fallback
+}
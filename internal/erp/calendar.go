@@ -0,0 +1,346 @@
+package erp
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+const minutesPerDay = 24 * 60
+
+// ClockTime is a time of day in minutes after midnight
+type ClockTime int
+
+// Clock returns the ClockTime for hour:minute
+func Clock(hour, minute int) ClockTime {
+	return ClockTime(hour*60 + minute)
+}
+
+// ParseClock parses a time of day like "06:00" or "21:45"
+func ParseClock(s string) (ClockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return Clock(t.Hour(), t.Minute()), nil
+}
+
+// Hour returns the hour of the day
+func (c ClockTime) Hour() int { return int(c) / 60 }
+
+// Minute returns the minute within the hour
+func (c ClockTime) Minute() int { return int(c) % 60 }
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour(), c.Minute())
+}
+
+// offsetFrom returns the minutes from start to c, wrapping past midnight
+func (c ClockTime) offsetFrom(start ClockTime) int {
+	return ((int(c)-int(start))%minutesPerDay + minutesPerDay) % minutesPerDay
+}
+
+// ShiftCalendar holds the shift schedules and the days they are worked
+type ShiftCalendar struct {
+	Schedules []ShiftSchedule
+
+	// Dates (2006-01-02) on which no shift starts
+	Holidays map[string]bool
+
+	// First day of the crew rotation cycle
+	RotationStart time.Time
+}
+
+// worksOn reports whether sched starts a shift on day and which crew works it
+func (c *ShiftCalendar) worksOn(sched ShiftSchedule, day time.Time) (crew string, ok bool) {
+	key := day.Format(dateLayout)
+	if c.Holidays[key] || sched.Exceptions[key] {
+		return "", false
+	}
+	if !sched.From.IsZero() && day.Before(sched.From) {
+		return "", false
+	}
+	if !sched.Until.IsZero() && day.After(sched.Until) {
+		return "", false
+	}
+
+	if len(sched.Days) > 0 {
+		worked := false
+		for _, d := range sched.Days {
+			if d == day.Weekday() {
+				worked = true
+				break
+			}
+		}
+		if !worked {
+			return "", false
+		}
+	}
+
+	// Every Interval days, or every Interval weeks for weekday rules
+	if sched.Interval > 1 && !sched.From.IsZero() {
+		periods := daysBetween(sched.From, day)
+		if len(sched.Days) > 0 {
+			periods = daysBetween(startOfWeek(sched.From), startOfWeek(day)) / 7
+		}
+		if periods%sched.Interval != 0 {
+			return "", false
+		}
+	}
+
+	if len(sched.Crews) > 0 {
+		idx := daysBetween(c.RotationStart, day) % len(sched.Crews)
+		if idx < 0 {
+			idx += len(sched.Crews)
+		}
+		crew = sched.Crews[idx]
+		if crew == "" {
+			return "", false
+		}
+	}
+
+	return crew, true
+}
+
+const dateLayout = "2006-01-02"
+
+// civilDate returns the calendar date of t as midnight UTC, which makes day
+// arithmetic independent of daylight saving time
+func civilDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// localTime returns the instant at which the wall clock in loc shows the
+// given minutes after midnight of day; minutes past a day roll over into the
+// next. Following RFC 5545, a wall time repeated when clocks go back resolves
+// to its first occurrence, and a wall time skipped when clocks go forward is
+// interpreted with the offset before the gap, which moves it forward by the
+// length of the gap.
+func localTime(day time.Time, minutes int, loc *time.Location) time.Time {
+	wall := time.Date(day.Year(), day.Month(), day.Day(), 0, minutes, 0, 0, time.UTC)
+
+	// Transitions are far more than a day apart, so the offsets a day before
+	// and after are the only candidates
+	_, offsetBefore := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, offsetAfter := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var first time.Time
+	for _, offset := range []int{offsetBefore, offsetAfter} {
+		t := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if sameWallClock(t, wall) && (first.IsZero() || t.Before(first)) {
+			first = t
+		}
+	}
+	if first.IsZero() {
+		first = wall.Add(-time.Duration(offsetBefore) * time.Second).In(loc)
+	}
+	return first
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := wall.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2 && t.Hour() == wall.Hour() && t.Minute() == wall.Minute()
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(civilDate(to).Sub(civilDate(from)).Hours() / 24)
+}
+
+func startOfWeek(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7 // Weeks start on Monday
+	return civilDate(day).AddDate(0, 0, -offset)
+}
+
+// calendarFile is the YAML/JSON layout of a shift calendar file
+type calendarFile struct {
+	RotationStart string      `yaml:"rotationStart" json:"rotationStart"`
+	Holidays      []string    `yaml:"holidays" json:"holidays"`
+	Shifts        []shiftFile `yaml:"shifts" json:"shifts"`
+}
+
+type shiftFile struct {
+	Name   string      `yaml:"name" json:"name"`
+	Code   string      `yaml:"code" json:"code"`
+	Start  string      `yaml:"start" json:"start"`
+	End    string      `yaml:"end" json:"end"`
+	Days   []string    `yaml:"days" json:"days"`
+	Crews  []string    `yaml:"crews" json:"crews"`
+	Breaks []breakFile `yaml:"breaks" json:"breaks"`
+}
+
+type breakFile struct {
+	Start string `yaml:"start" json:"start"`
+	End   string `yaml:"end" json:"end"`
+	Type  string `yaml:"type" json:"type"`
+}
+
+// LoadShiftCalendar reads a shift calendar from a YAML, JSON or iCalendar
+// (.ics) file. A calendar without shifts only adds holidays to the built-in
+// shift model.
+func LoadShiftCalendar(path string, loc *time.Location) (*ShiftCalendar, error) {
+	var cal *ShiftCalendar
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		cal, err = loadICalendar(path, loc)
+	} else {
+		cal, err = loadCalendarFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cal.validate(); err != nil {
+		return nil, fmt.Errorf("invalid shift calendar %s:\n%w", path, err)
+	}
+	return cal, nil
+}
+
+func loadCalendarFile(path string) (*ShiftCalendar, error) {
+	var file calendarFile
+	if err := config.DecodeFile(path, &file); err != nil {
+		return nil, err
+	}
+
+	cal := &ShiftCalendar{Holidays: make(map[string]bool)}
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if file.RotationStart != "" {
+		start, err := time.Parse(dateLayout, file.RotationStart)
+		if err != nil {
+			fail("rotationStart must be a date like 2026-01-05, got %q", file.RotationStart)
+		}
+		cal.RotationStart = start
+	}
+
+	for i, h := range file.Holidays {
+		if _, err := time.Parse(dateLayout, h); err != nil {
+			fail("holidays[%d] must be a date like 2026-12-25, got %q", i, h)
+			continue
+		}
+		cal.Holidays[h] = true
+	}
+
+	for i, sf := range file.Shifts {
+		sched := ShiftSchedule{Name: sf.Name, Code: sf.Code}
+		var err error
+		if sched.Start, err = ParseClock(sf.Start); err != nil {
+			fail("shifts[%d].start: %v", i, err)
+		}
+		if sched.End, err = ParseClock(sf.End); err != nil {
+			fail("shifts[%d].end: %v", i, err)
+		}
+		for _, d := range sf.Days {
+			weekday, err := parseWeekday(d)
+			if err != nil {
+				fail("shifts[%d].days: %v", i, err)
+				continue
+			}
+			sched.Days = append(sched.Days, weekday)
+		}
+		for _, crew := range sf.Crews {
+			if crew == "-" {
+				crew = ""
+			}
+			sched.Crews = append(sched.Crews, crew)
+		}
+		for j, bf := range sf.Breaks {
+			b := BreakDefinition{Type: bf.Type}
+			if b.Start, err = ParseClock(bf.Start); err != nil {
+				fail("shifts[%d].breaks[%d].start: %v", i, j, err)
+			}
+			if b.End, err = ParseClock(bf.End); err != nil {
+				fail("shifts[%d].breaks[%d].end: %v", i, j, err)
+			}
+			if b.Type == "" {
+				b.Type = "break"
+			}
+			sched.Breaks = append(sched.Breaks, b)
+		}
+		cal.Schedules = append(cal.Schedules, sched)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid shift calendar %s:\n%w", path, errors.Join(errs...))
+	}
+	return cal, nil
+}
+
+// validate checks the schedules and fills in default shift codes
+func (c *ShiftCalendar) validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	rotating := false
+	codes := make(map[string]string)
+	for i := range c.Schedules {
+		sched := &c.Schedules[i]
+		if strings.TrimSpace(sched.Name) == "" {
+			fail("shifts[%d].name must not be empty", i)
+			continue
+		}
+		if sched.Code == "" {
+			sched.Code = strings.ToUpper(sched.Name[:1])
+		}
+		if other, ok := codes[sched.Code]; ok && other != sched.Name {
+			fail("shifts[%d] %q has the same code %q as %q, set a unique code", i, sched.Name, sched.Code, other)
+		}
+		codes[sched.Code] = sched.Name
+
+		length := sched.length()
+		for j, b := range sched.Breaks {
+			offset := b.Start.offsetFrom(sched.Start)
+			duration := b.End.offsetFrom(b.Start)
+			if duration == 0 || offset+duration > length {
+				fail("shifts[%d] %q break %d (%s-%s) must lie within the shift (%s-%s)",
+					i, sched.Name, j, b.Start, b.End, sched.Start, sched.End)
+			}
+		}
+
+		if len(sched.Crews) > 1 {
+			rotating = true
+		}
+	}
+
+	if rotating && c.RotationStart.IsZero() {
+		fail("rotationStart is required when shifts rotate between crews")
+	}
+
+	return errors.Join(errs...)
+}
+
+// length returns the shift length in minutes. An end at or before the start
+// means the shift runs past midnight.
+func (s ShiftSchedule) length() int {
+	if length := s.End.offsetFrom(s.Start); length > 0 {
+		return length
+	}
+	return minutesPerDay
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday, "su": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday, "mo": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday, "tu": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday, "we": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday, "th": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday, "fr": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday, "sa": time.Saturday,
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	if d, ok := weekdayNames[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return d, nil
+	}
+	return 0, fmt.Errorf("unknown weekday %q, expected Mon, Tue, ... Sun", s)
+}
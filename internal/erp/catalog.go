@@ -0,0 +1,228 @@
+package erp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// Customer is a customer orders are generated for
+type Customer struct {
+	Name   string
+	Weight float64 // Relative share of generated orders
+}
+
+// PartCatalog holds the parts, weld recipes and customers used to generate
+// production orders
+type PartCatalog struct {
+	Parts     []simulator.PartDefinition
+	Recipes   map[string]simulator.WeldRecipe
+	Customers []Customer
+}
+
+// DefaultPartCatalog returns the built-in parts, recipes and customers
+func DefaultPartCatalog() *PartCatalog {
+	return &PartCatalog{
+		Parts: []simulator.PartDefinition{
+			{PartNumber: "WLD-FRAME-A01", Description: "Front Frame Assembly", CycleTime: 55 * time.Second, Recipe: "SPRAY-THICK-PLATE"},
+			{PartNumber: "WLD-FRAME-B02", Description: "Rear Frame Assembly", CycleTime: 70 * time.Second, Recipe: "SPRAY-THICK-PLATE"},
+			{PartNumber: "WLD-BRACKET-C01", Description: "Support Bracket", CycleTime: 35 * time.Second, Recipe: "SC-THIN-SHEET"},
+			{PartNumber: "WLD-PANEL-D01", Description: "Side Panel", CycleTime: 45 * time.Second, Recipe: "PULSE-ALLROUND"},
+			{PartNumber: "WLD-MOUNT-E01", Description: "Motor Mount", CycleTime: 40 * time.Second, Recipe: "GLOB-MEDIUM"},
+			{PartNumber: "WLD-CROSS-F01", Description: "Cross Member", CycleTime: 60 * time.Second, Recipe: "PULSE-ALLROUND"},
+		},
+		Recipes: map[string]simulator.WeldRecipe{
+			"SC-THIN-SHEET": {
+				Name:          "SC-THIN-SHEET",
+				TransferMode:  simulator.TransferShortCircuit,
+				Current:       140.0,
+				Voltage:       18.0,
+				WireFeedSpeed: 5.0,
+				GasFlow:       12.0,
+				TravelSpeed:   8.0,
+			},
+			"GLOB-MEDIUM": {
+				Name:          "GLOB-MEDIUM",
+				TransferMode:  simulator.TransferGlobular,
+				Current:       220.0,
+				Voltage:       26.0,
+				WireFeedSpeed: 8.5,
+				GasFlow:       15.0,
+				TravelSpeed:   9.0,
+			},
+			"SPRAY-THICK-PLATE": {
+				Name:          "SPRAY-THICK-PLATE",
+				TransferMode:  simulator.TransferSpray,
+				Current:       280.0,
+				Voltage:       29.0,
+				WireFeedSpeed: 11.5,
+				GasFlow:       18.0,
+				TravelSpeed:   12.0,
+			},
+			"PULSE-ALLROUND": {
+				Name:          "PULSE-ALLROUND",
+				TransferMode:  simulator.TransferPulsed,
+				Current:       180.0,
+				Voltage:       24.0,
+				WireFeedSpeed: 8.0,
+				GasFlow:       16.0,
+				TravelSpeed:   10.0,
+			},
+		},
+		Customers: []Customer{
+			{Name: "AutoCorp Inc.", Weight: 1},
+			{Name: "MechParts GmbH", Weight: 1},
+			{Name: "TechFab Solutions", Weight: 1},
+			{Name: "Industrial Motors Ltd.", Weight: 1},
+			{Name: "Assembly Systems AG", Weight: 1},
+		},
+	}
+}
+
+// Part returns the definition of a part number
+func (c *PartCatalog) Part(partNumber string) (simulator.PartDefinition, bool) {
+	for _, part := range c.Parts {
+		if part.PartNumber == partNumber {
+			return part, true
+		}
+	}
+	return simulator.PartDefinition{}, false
+}
+
+// partCatalogFile is the YAML/JSON layout of a part catalog file
+type partCatalogFile struct {
+	Recipes   []recipeFile   `yaml:"recipes" json:"recipes"`
+	Parts     []partFile     `yaml:"parts" json:"parts"`
+	Customers []customerFile `yaml:"customers" json:"customers"`
+}
+
+type recipeFile struct {
+	Name          string                 `yaml:"name" json:"name"`
+	TransferMode  simulator.TransferMode `yaml:"transferMode" json:"transferMode"`
+	Current       float64                `yaml:"current" json:"current"`
+	Voltage       float64                `yaml:"voltage" json:"voltage"`
+	WireFeedSpeed float64                `yaml:"wireFeedSpeed" json:"wireFeedSpeed"`
+	GasFlow       float64                `yaml:"gasFlow" json:"gasFlow"`
+	TravelSpeed   float64                `yaml:"travelSpeed" json:"travelSpeed"`
+}
+
+type partFile struct {
+	PartNumber  string          `yaml:"partNumber" json:"partNumber"`
+	Description string          `yaml:"description" json:"description"`
+	CycleTime   config.Duration `yaml:"cycleTime" json:"cycleTime"`
+	Recipe      string          `yaml:"recipe" json:"recipe"`
+}
+
+type customerFile struct {
+	Name   string   `yaml:"name" json:"name"`
+	Weight *float64 `yaml:"weight" json:"weight"`
+}
+
+// LoadPartCatalog reads parts, recipes and customers from a YAML or JSON
+// file. Recipes are added to the built-in recipes; parts and customers
+// replace the built-in lists when present. Without a path the built-in
+// catalog is returned.
+func LoadPartCatalog(path string) (*PartCatalog, error) {
+	catalog := DefaultPartCatalog()
+	if path == "" {
+		return catalog, nil
+	}
+
+	var file partCatalogFile
+	if err := config.DecodeFile(path, &file); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for i, rf := range file.Recipes {
+		if strings.TrimSpace(rf.Name) == "" {
+			fail("recipes[%d].name must not be empty", i)
+			continue
+		}
+		for _, field := range []struct {
+			name  string
+			value float64
+		}{
+			{"current", rf.Current},
+			{"voltage", rf.Voltage},
+			{"wireFeedSpeed", rf.WireFeedSpeed},
+			{"gasFlow", rf.GasFlow},
+			{"travelSpeed", rf.TravelSpeed},
+		} {
+			if field.value <= 0 {
+				fail("recipes[%d] %s: %s must be positive, got %g", i, rf.Name, field.name, field.value)
+			}
+		}
+		catalog.Recipes[rf.Name] = simulator.WeldRecipe{
+			Name:          rf.Name,
+			TransferMode:  rf.TransferMode,
+			Current:       rf.Current,
+			Voltage:       rf.Voltage,
+			WireFeedSpeed: rf.WireFeedSpeed,
+			GasFlow:       rf.GasFlow,
+			TravelSpeed:   rf.TravelSpeed,
+		}
+	}
+
+	if len(file.Parts) > 0 {
+		catalog.Parts = nil
+		seen := make(map[string]bool)
+		for i, pf := range file.Parts {
+			if strings.TrimSpace(pf.PartNumber) == "" {
+				fail("parts[%d].partNumber must not be empty", i)
+				continue
+			}
+			if seen[pf.PartNumber] {
+				fail("parts[%d].partNumber %q is defined twice", i, pf.PartNumber)
+			}
+			seen[pf.PartNumber] = true
+			if pf.CycleTime <= 0 {
+				fail("parts[%d] %s: cycleTime must be positive, got %s", i, pf.PartNumber, time.Duration(pf.CycleTime))
+			}
+			if _, ok := catalog.Recipes[pf.Recipe]; !ok {
+				fail("parts[%d] %s: unknown recipe %q", i, pf.PartNumber, pf.Recipe)
+			}
+			catalog.Parts = append(catalog.Parts, simulator.PartDefinition{
+				PartNumber:  pf.PartNumber,
+				Description: pf.Description,
+				CycleTime:   time.Duration(pf.CycleTime),
+				Recipe:      pf.Recipe,
+			})
+		}
+	}
+
+	if len(file.Customers) > 0 {
+		catalog.Customers = nil
+		var total float64
+		for i, cf := range file.Customers {
+			customer := Customer{Name: cf.Name, Weight: 1}
+			if cf.Weight != nil {
+				customer.Weight = *cf.Weight
+			}
+			if strings.TrimSpace(customer.Name) == "" {
+				fail("customers[%d].name must not be empty", i)
+			}
+			if customer.Weight < 0 {
+				fail("customers[%d] %s: weight must not be negative, got %g", i, customer.Name, customer.Weight)
+			}
+			total += customer.Weight
+			catalog.Customers = append(catalog.Customers, customer)
+		}
+		if total <= 0 {
+			fail("customers must have a positive total weight")
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid part catalog %s:\n%w", path, errors.Join(errs...))
+	}
+	return catalog, nil
+}
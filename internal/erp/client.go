@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -16,23 +17,49 @@ import (
 
 // Client handles communication with the ERP system
 type Client struct {
-	cfg        *config.Config
 	httpClient *http.Client
+
+	mu        sync.RWMutex
+	endpoint  string
+	orderPath string
+	shiftPath string
 }
 
 // NewClient creates a new ERP client
 func NewClient(cfg *config.Config) *Client {
-	return &Client{
-		cfg: cfg,
+	c := &Client{
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
+	c.Configure(cfg)
+	return c
+}
+
+// Configure updates the ERP endpoint and paths, e.g. after a config reload
+func (c *Client) Configure(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpoint = cfg.ERPEndpoint
+	c.orderPath = cfg.ERPOrderPath
+	c.shiftPath = cfg.ERPShiftPath
+}
+
+func (c *Client) orderURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.endpoint + c.orderPath
+}
+
+func (c *Client) shiftURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.endpoint + c.shiftPath
 }
 
 // SendOrderUpdate sends a production order update to the ERP endpoint
 func (c *Client) SendOrderUpdate(ctx context.Context, order *simulator.ProductionOrder) error {
-	url := c.cfg.ERPEndpoint + c.cfg.ERPOrderPath
+	url := c.orderURL()
 
 	payload, err := json.Marshal(order)
 	if err != nil {
@@ -70,7 +97,7 @@ func (c *Client) SendOrderUpdate(ctx context.Context, order *simulator.Productio
 
 // SendShiftUpdate sends a shift update to the ERP endpoint
 func (c *Client) SendShiftUpdate(ctx context.Context, shift *simulator.Shift) error {
-	url := c.cfg.ERPEndpoint + c.cfg.ERPShiftPath
+	url := c.shiftURL()
 
 	payload, err := json.Marshal(shift)
 	if err != nil {
@@ -0,0 +1,418 @@
+package erp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icalProperty is a content line of an iCalendar file
+type icalProperty struct {
+	params map[string]string
+	value  string
+}
+
+// icalEvent is a VEVENT with its properties by name
+type icalEvent map[string][]icalProperty
+
+func (e icalEvent) get(name string) (icalProperty, bool) {
+	props := e[name]
+	if len(props) == 0 {
+		return icalProperty{}, false
+	}
+	return props[0], true
+}
+
+func (e icalEvent) text(name string) string {
+	prop, _ := e.get(name)
+	return unescapeText(prop.value)
+}
+
+// categories returns the upper-cased CATEGORIES of the event
+func (e icalEvent) categories() map[string]bool {
+	cats := make(map[string]bool)
+	for _, prop := range e["CATEGORIES"] {
+		for _, c := range strings.Split(prop.value, ",") {
+			cats[strings.ToUpper(strings.TrimSpace(c))] = true
+		}
+	}
+	return cats
+}
+
+// loadICalendar imports shifts, breaks and holidays from an iCalendar file.
+//
+// Events are interpreted as follows:
+//   - All-day events and events with CATEGORIES:HOLIDAY are holidays.
+//   - Events with CATEGORIES:BREAK or LUNCH are breaks of every shift that
+//     contains them.
+//   - Every other event is a shift named by its SUMMARY. DAILY and WEEKLY
+//     RRULEs with BYDAY, INTERVAL and UNTIL as well as EXDATE are supported.
+//     An optional X-CREW property names the crew working the shift.
+func loadICalendar(path string, loc *time.Location) (*ShiftCalendar, error) {
+	events, err := parseICalendar(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := &ShiftCalendar{Holidays: make(map[string]bool)}
+	var breaks []BreakDefinition
+	var errs []error
+
+	for i, event := range events {
+		summary := event.text("SUMMARY")
+		name := summary
+		if name == "" {
+			name = fmt.Sprintf("event %d", i+1)
+		}
+
+		start, allDay, err := parseICalTime(event, "DTSTART", loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		end, err := eventEnd(event, start, allDay, loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		cats := event.categories()
+		switch {
+		case allDay || cats["HOLIDAY"]:
+			if _, ok := event.get("RRULE"); ok {
+				errs = append(errs, fmt.Errorf("%s: recurring holidays are not supported, list each date", name))
+				continue
+			}
+			last := civilDate(end.Add(-time.Nanosecond)) // DTEND is exclusive
+			for day := civilDate(start); !day.After(last); day = day.AddDate(0, 0, 1) {
+				cal.Holidays[day.Format(dateLayout)] = true
+			}
+
+		case cats["BREAK"] || cats["LUNCH"]:
+			breakType := "break"
+			if cats["LUNCH"] {
+				breakType = "lunch"
+			}
+			breaks = append(breaks, BreakDefinition{
+				Start: Clock(start.Hour(), start.Minute()),
+				End:   Clock(end.Hour(), end.Minute()),
+				Type:  breakType,
+			})
+
+		default:
+			if summary == "" {
+				errs = append(errs, fmt.Errorf("%s: shift events need a SUMMARY naming the shift", name))
+				continue
+			}
+			if end.Sub(start) > 24*time.Hour {
+				errs = append(errs, fmt.Errorf("%s: shifts longer than 24h are not supported", name))
+				continue
+			}
+			sched := ShiftSchedule{
+				Name:  summary,
+				Start: Clock(start.Hour(), start.Minute()),
+				End:   Clock(end.Hour(), end.Minute()),
+				From:  civilDate(start),
+				Until: civilDate(start),
+			}
+			if crew := event.text("X-CREW"); crew != "" {
+				sched.Crews = []string{crew}
+			}
+			if rule, ok := event.get("RRULE"); ok {
+				if err := applyRRule(&sched, rule.value, start, loc); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+					continue
+				}
+			}
+			for _, prop := range event["EXDATE"] {
+				for _, value := range strings.Split(prop.value, ",") {
+					t, _, err := parseICalValue(icalProperty{params: prop.params, value: value}, loc)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("%s: EXDATE: %w", name, err))
+						continue
+					}
+					if sched.Exceptions == nil {
+						sched.Exceptions = make(map[string]bool)
+					}
+					sched.Exceptions[civilDate(t).Format(dateLayout)] = true
+				}
+			}
+			cal.Schedules = append(cal.Schedules, sched)
+		}
+	}
+
+	// Attach each break to every shift that contains it
+	for _, b := range breaks {
+		attached := false
+		for i := range cal.Schedules {
+			sched := &cal.Schedules[i]
+			offset := b.Start.offsetFrom(sched.Start)
+			duration := b.End.offsetFrom(b.Start)
+			if duration > 0 && offset+duration <= sched.length() {
+				sched.Breaks = append(sched.Breaks, b)
+				attached = true
+			}
+		}
+		if !attached {
+			errs = append(errs, fmt.Errorf("%s break %s-%s is not within any shift", b.Type, b.Start, b.End))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid iCalendar file %s:\n%w", path, errors.Join(errs...))
+	}
+	return cal, nil
+}
+
+// applyRRule maps a DAILY or WEEKLY recurrence rule onto sched
+func applyRRule(sched *ShiftSchedule, rule string, start time.Time, loc *time.Location) error {
+	sched.Until = time.Time{}
+	weekly := false
+
+	for _, part := range strings.Split(rule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch strings.ToUpper(value) {
+			case "DAILY":
+			case "WEEKLY":
+				weekly = true
+			default:
+				return fmt.Errorf("RRULE FREQ=%s is not supported, use DAILY or WEEKLY", value)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return fmt.Errorf("invalid RRULE INTERVAL %q", value)
+			}
+			sched.Interval = interval
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				weekday, err := parseWeekday(d)
+				if err != nil {
+					return fmt.Errorf("RRULE BYDAY: %w", err)
+				}
+				sched.Days = append(sched.Days, weekday)
+			}
+		case "UNTIL":
+			until, _, err := parseICalValue(icalProperty{value: value}, loc)
+			if err != nil {
+				return fmt.Errorf("RRULE UNTIL: %w", err)
+			}
+			sched.Until = civilDate(until)
+		case "WKST":
+			// Weeks always start on Monday
+		default:
+			return fmt.Errorf("RRULE %s is not supported", key)
+		}
+	}
+
+	// A weekly rule without BYDAY repeats on the weekday of DTSTART
+	if weekly && len(sched.Days) == 0 {
+		sched.Days = []time.Weekday{start.Weekday()}
+	}
+	// A daily rule with an interval counts days, not weeks
+	if !weekly && len(sched.Days) > 0 && sched.Interval > 1 {
+		return errors.New("RRULE FREQ=DAILY with BYDAY and INTERVAL is not supported")
+	}
+
+	return nil
+}
+
+// eventEnd returns the end of an event from DTEND or DURATION
+func eventEnd(event icalEvent, start time.Time, allDay bool, loc *time.Location) (time.Time, error) {
+	if _, ok := event.get("DTEND"); ok {
+		end, _, err := parseICalTime(event, "DTEND", loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !end.After(start) {
+			return time.Time{}, errors.New("DTEND must be after DTSTART")
+		}
+		return end, nil
+	}
+	if prop, ok := event.get("DURATION"); ok {
+		d, err := parseICalDuration(prop.value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return start.Add(d), nil
+	}
+	if allDay {
+		return start.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, errors.New("event needs DTEND or DURATION")
+}
+
+func parseICalTime(event icalEvent, name string, loc *time.Location) (time.Time, bool, error) {
+	prop, ok := event.get(name)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("missing %s", name)
+	}
+	t, allDay, err := parseICalValue(prop, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, allDay, nil
+}
+
+// parseICalValue parses a DATE or DATE-TIME value and converts it to loc.
+// Floating times are taken as local times in loc.
+func parseICalValue(prop icalProperty, loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err = time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid UTC date-time %q", value)
+		}
+		return t.In(loc), false, nil
+	}
+
+	zone := loc
+	if tzid := prop.params["TZID"]; tzid != "" {
+		zone, err = time.LoadLocation(strings.Trim(tzid, "\""))
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown TZID %q, use an IANA timezone name", tzid)
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, zone)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t.In(loc), false, nil
+}
+
+// parseICalDuration parses durations like PT8H, PT7H30M or P1D
+func parseICalDuration(s string) (time.Duration, error) {
+	value := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "+")
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid DURATION %q", s)
+	}
+	value = value[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T':
+			inTime = true
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("invalid DURATION %q", s)
+			}
+			num = ""
+			switch {
+			case r == 'W' && !inTime:
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case r == 'D' && !inTime:
+				d += time.Duration(n) * 24 * time.Hour
+			case r == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case r == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case r == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("invalid DURATION %q", s)
+			}
+		}
+	}
+	if num != "" || d <= 0 {
+		return 0, fmt.Errorf("invalid DURATION %q", s)
+	}
+	return d, nil
+}
+
+// parseICalendar reads the VEVENTs of an iCalendar file
+func parseICalendar(path string) ([]icalEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	// Unfold continuation lines, which start with a space or tab
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var events []icalEvent
+	var current icalEvent
+	depth := 0 // Nesting inside the event, e.g. VALARM
+	for n, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		nameParams, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s line %d: malformed content line %q", path, n+1, line)
+		}
+		parts := strings.Split(nameParams, ";")
+		name := strings.ToUpper(parts[0])
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current = make(icalEvent)
+			depth = 0
+			continue
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if current != nil {
+				events = append(events, current)
+			}
+			current = nil
+			continue
+		case current == nil:
+			continue
+		case name == "BEGIN":
+			depth++
+			continue
+		case name == "END":
+			depth--
+			continue
+		case depth > 0:
+			continue
+		}
+
+		params := make(map[string]string)
+		for _, p := range parts[1:] {
+			key, val, _ := strings.Cut(p, "=")
+			params[strings.ToUpper(key)] = val
+		}
+		current[name] = append(current[name], icalProperty{params: params, value: value})
+	}
+
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%s contains no VEVENT", path)
+	}
+	return events, nil
+}
+
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -9,48 +9,38 @@ import (
 	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
 )
 
-// PartCatalog defines available parts for production
-var PartCatalog = []simulator.PartDefinition{
-	{PartNumber: "WLD-FRAME-A01", Description: "Front Frame Assembly", CycleTime: 55 * time.Second},
-	{PartNumber: "WLD-FRAME-B02", Description: "Rear Frame Assembly", CycleTime: 70 * time.Second},
-	{PartNumber: "WLD-BRACKET-C01", Description: "Support Bracket", CycleTime: 35 * time.Second},
-	{PartNumber: "WLD-PANEL-D01", Description: "Side Panel", CycleTime: 45 * time.Second},
-	{PartNumber: "WLD-MOUNT-E01", Description: "Motor Mount", CycleTime: 40 * time.Second},
-	{PartNumber: "WLD-CROSS-F01", Description: "Cross Member", CycleTime: 60 * time.Second},
-}
-
-// CustomerList defines customers for order generation
-var CustomerList = []string{
-	"AutoCorp Inc.",
-	"MechParts GmbH",
-	"TechFab Solutions",
-	"Industrial Motors Ltd.",
-	"Assembly Systems AG",
-}
-
 // OrderGenerator generates production orders automatically
 type OrderGenerator struct {
 	cfg         *config.Config
 	rng         *rand.Rand
+	catalog     *PartCatalog
 	orderNumber int
 }
 
-// NewOrderGenerator creates a new order generator
+// NewOrderGenerator creates a new order generator using the built-in part
+// catalog
 func NewOrderGenerator(cfg *config.Config) *OrderGenerator {
 	return &OrderGenerator{
 		cfg:         cfg,
 		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		catalog:     DefaultPartCatalog(),
 		orderNumber: 1000,
 	}
 }
 
+// SetCatalog replaces the parts, recipes and customers of new orders.
+// Queued orders keep their part numbers.
+func (og *OrderGenerator) SetCatalog(catalog *PartCatalog) {
+	og.catalog = catalog
+}
+
 // GenerateOrder creates a new random production order
 func (og *OrderGenerator) GenerateOrder() *simulator.ProductionOrder {
 	// Select random part
-	part := PartCatalog[og.rng.Intn(len(PartCatalog))]
+	part := og.catalog.Parts[og.rng.Intn(len(og.catalog.Parts))]
 
-	// Select random customer
-	customer := CustomerList[og.rng.Intn(len(CustomerList))]
+	// Select customer weighted by share of orders
+	customer := og.pickCustomer()
 
 	// Generate random quantity within configured range
 	quantity := og.cfg.OrderMinQty + og.rng.Intn(og.cfg.OrderMaxQty-og.cfg.OrderMinQty+1)
@@ -88,12 +78,47 @@ func (og *OrderGenerator) GenerateInitialQueue(count int) []*simulator.Productio
 	return orders
 }
 
-// GetPartCycleTime returns the cycle time for a specific part number
-func GetPartCycleTime(partNumber string, defaultCycleTime time.Duration) time.Duration {
-	for _, part := range PartCatalog {
-		if part.PartNumber == partNumber {
-			return part.CycleTime
+func (og *OrderGenerator) pickCustomer() string {
+	var total float64
+	for _, c := range og.catalog.Customers {
+		total += c.Weight
+	}
+
+	r := og.rng.Float64() * total
+	for _, c := range og.catalog.Customers {
+		if c.Weight > 0 && r < c.Weight {
+			return c.Name
+		}
+		r -= c.Weight
+	}
+	// Rounding left r at the upper bound; take the last weighted customer
+	for i := len(og.catalog.Customers) - 1; i >= 0; i-- {
+		if og.catalog.Customers[i].Weight > 0 {
+			return og.catalog.Customers[i].Name
 		}
 	}
-	return defaultCycleTime
+	return ""
+}
+
+// GetPartCycleTime returns the cycle time for a specific part number
+func (og *OrderGenerator) GetPartCycleTime(partNumber string) (time.Duration, bool) {
+	if part, ok := og.catalog.Part(partNumber); ok {
+		return part.CycleTime, true
+	}
+	return 0, false
+}
+
+// GetPartRecipe returns the weld recipe for a specific part number
+func (og *OrderGenerator) GetPartRecipe(partNumber string) (simulator.WeldRecipe, bool) {
+	if part, ok := og.catalog.Part(partNumber); ok {
+		recipe, found := og.catalog.Recipes[part.Recipe]
+		return recipe, found
+	}
+	return simulator.WeldRecipe{}, false
+}
+
+// GetRecipe returns a weld recipe by name
+func (og *OrderGenerator) GetRecipe(name string) (simulator.WeldRecipe, bool) {
+	recipe, ok := og.catalog.Recipes[name]
+	return recipe, ok
 }
@@ -8,190 +8,229 @@ import (
 	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
 )
 
-// ShiftSchedule defines the shift schedule
+// ShiftSchedule defines a recurring shift
 type ShiftSchedule struct {
 	Name   string
-	Start  int // Hour (0-23)
-	End    int // Hour (0-23)
+	Code   string    // Letter used in shift IDs, defaults to the first letter of Name
+	Start  ClockTime // Time of day the shift starts
+	End    ClockTime // At or before Start, the shift ends the next day
 	Breaks []BreakDefinition
+
+	// Weekdays the shift starts on, every day if empty
+	Days []time.Weekday
+
+	// Crew working each day of the rotation cycle, "" when the shift is not
+	// worked that day. Empty for shifts without crews.
+	Crews []string
+
+	// Dates bounding the schedule (zero for open-ended), a repeat interval in
+	// days or, with Days set, in weeks counted from From, and dates skipped
+	From       time.Time
+	Until      time.Time
+	Interval   int
+	Exceptions map[string]bool
 }
 
 // BreakDefinition defines a break within a shift
 type BreakDefinition struct {
-	StartHour   int
-	StartMinute int
-	EndHour     int
-	EndMinute   int
-	Type        string
+	Start ClockTime
+	End   ClockTime
+	Type  string
 }
 
 // ShiftManager manages shift schedules and transitions
 type ShiftManager struct {
 	cfg          *config.Config
 	location     *time.Location
-	schedules    []ShiftSchedule
+	calendar     *ShiftCalendar
+	shiftNumbers map[string]int
 	currentShift *simulator.Shift
+	initialized  bool
 	workCenterID string
 }
 
 // NewShiftManager creates a new shift manager
 func NewShiftManager(cfg *config.Config) (*ShiftManager, error) {
-	loc, err := time.LoadLocation(cfg.Timezone)
-	if err != nil {
-		loc = time.Local
-	}
-
 	sm := &ShiftManager{
 		cfg:          cfg,
-		location:     loc,
 		workCenterID: "WC-WELD-01",
 	}
 
-	// Initialize schedules based on shift model
-	sm.initializeSchedules()
+	if err := sm.Reload(); err != nil {
+		return nil, err
+	}
 
 	return sm, nil
 }
 
-func (sm *ShiftManager) initializeSchedules() {
-	switch sm.cfg.ShiftModel {
+// Reload re-reads the timezone, shift model and shift calendar from the
+// configuration. On error the previous schedule stays in place.
+func (sm *ShiftManager) Reload() error {
+	loc, err := time.LoadLocation(sm.cfg.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+
+	calendar := &ShiftCalendar{
+		Schedules: builtinSchedules(sm.cfg.ShiftModel),
+		Holidays:  make(map[string]bool),
+	}
+	if sm.cfg.ShiftCalendarFile != "" {
+		custom, err := LoadShiftCalendar(sm.cfg.ShiftCalendarFile, loc)
+		if err != nil {
+			return err
+		}
+		// A calendar without shifts only adds holidays to the shift model
+		if len(custom.Schedules) == 0 {
+			custom.Schedules = calendar.Schedules
+		}
+		calendar = custom
+	}
+
+	// Shifts sharing a name (e.g. one per crew) share a shift number
+	numbers := make(map[string]int)
+	for _, sched := range calendar.Schedules {
+		if _, ok := numbers[sched.Name]; !ok {
+			numbers[sched.Name] = len(numbers) + 1
+		}
+	}
+
+	sm.location = loc
+	sm.calendar = calendar
+	sm.shiftNumbers = numbers
+	return nil
+}
+
+// builtinSchedules returns the schedules of a built-in shift model
+func builtinSchedules(model string) []ShiftSchedule {
+	switch model {
 	case "3-shift":
-		sm.schedules = []ShiftSchedule{
+		return []ShiftSchedule{
 			{
 				Name:  "Morning",
-				Start: 6,
-				End:   14,
+				Code:  "M",
+				Start: Clock(6, 0),
+				End:   Clock(14, 0),
 				Breaks: []BreakDefinition{
-					{StartHour: 9, StartMinute: 0, EndHour: 9, EndMinute: 15, Type: "break"},
-					{StartHour: 12, StartMinute: 0, EndHour: 12, EndMinute: 30, Type: "lunch"},
+					{Start: Clock(9, 0), End: Clock(9, 15), Type: "break"},
+					{Start: Clock(12, 0), End: Clock(12, 30), Type: "lunch"},
 				},
 			},
 			{
 				Name:  "Afternoon",
-				Start: 14,
-				End:   22,
+				Code:  "A",
+				Start: Clock(14, 0),
+				End:   Clock(22, 0),
 				Breaks: []BreakDefinition{
-					{StartHour: 17, StartMinute: 0, EndHour: 17, EndMinute: 15, Type: "break"},
-					{StartHour: 19, StartMinute: 0, EndHour: 19, EndMinute: 30, Type: "lunch"},
+					{Start: Clock(17, 0), End: Clock(17, 15), Type: "break"},
+					{Start: Clock(19, 0), End: Clock(19, 30), Type: "lunch"},
 				},
 			},
 			{
 				Name:  "Night",
-				Start: 22,
-				End:   6, // Next day
+				Code:  "N",
+				Start: Clock(22, 0),
+				End:   Clock(6, 0), // Next day
 				Breaks: []BreakDefinition{
-					{StartHour: 1, StartMinute: 0, EndHour: 1, EndMinute: 15, Type: "break"},
-					{StartHour: 3, StartMinute: 0, EndHour: 3, EndMinute: 30, Type: "lunch"},
+					{Start: Clock(1, 0), End: Clock(1, 15), Type: "break"},
+					{Start: Clock(3, 0), End: Clock(3, 30), Type: "lunch"},
 				},
 			},
 		}
 
 	case "2-shift":
-		sm.schedules = []ShiftSchedule{
+		return []ShiftSchedule{
 			{
 				Name:  "Day",
-				Start: 6,
-				End:   14,
+				Code:  "D",
+				Start: Clock(6, 0),
+				End:   Clock(14, 0),
 				Breaks: []BreakDefinition{
-					{StartHour: 9, StartMinute: 0, EndHour: 9, EndMinute: 15, Type: "break"},
-					{StartHour: 12, StartMinute: 0, EndHour: 12, EndMinute: 30, Type: "lunch"},
+					{Start: Clock(9, 0), End: Clock(9, 15), Type: "break"},
+					{Start: Clock(12, 0), End: Clock(12, 30), Type: "lunch"},
 				},
 			},
 			{
 				Name:  "Late",
-				Start: 14,
-				End:   22,
+				Code:  "L",
+				Start: Clock(14, 0),
+				End:   Clock(22, 0),
 				Breaks: []BreakDefinition{
-					{StartHour: 17, StartMinute: 0, EndHour: 17, EndMinute: 15, Type: "break"},
-					{StartHour: 19, StartMinute: 0, EndHour: 19, EndMinute: 30, Type: "lunch"},
+					{Start: Clock(17, 0), End: Clock(17, 15), Type: "break"},
+					{Start: Clock(19, 0), End: Clock(19, 30), Type: "lunch"},
 				},
 			},
 		}
 
 	default: // 1-shift
-		sm.schedules = []ShiftSchedule{
+		return []ShiftSchedule{
 			{
 				Name:  "Day",
-				Start: 8,
-				End:   17,
+				Code:  "D",
+				Start: Clock(8, 0),
+				End:   Clock(17, 0),
 				Breaks: []BreakDefinition{
-					{StartHour: 10, StartMinute: 0, EndHour: 10, EndMinute: 15, Type: "break"},
-					{StartHour: 12, StartMinute: 30, EndHour: 13, EndMinute: 0, Type: "lunch"},
-					{StartHour: 15, StartMinute: 0, EndHour: 15, EndMinute: 15, Type: "break"},
+					{Start: Clock(10, 0), End: Clock(10, 15), Type: "break"},
+					{Start: Clock(12, 30), End: Clock(13, 0), Type: "lunch"},
+					{Start: Clock(15, 0), End: Clock(15, 15), Type: "break"},
 				},
 			},
 		}
 	}
 }
 
-// GetCurrentShift returns the current shift based on the current time
+// GetCurrentShift returns the current shift based on the current time, or
+// nil outside of shifts
 func (sm *ShiftManager) GetCurrentShift(now time.Time) *simulator.Shift {
 	localNow := now.In(sm.location)
-	hour := localNow.Hour()
-
-	for i, sched := range sm.schedules {
-		var inShift bool
-
-		if sched.End > sched.Start {
-			// Normal shift (doesn't cross midnight)
-			inShift = hour >= sched.Start && hour < sched.End
-		} else {
-			// Night shift (crosses midnight)
-			inShift = hour >= sched.Start || hour < sched.End
-		}
-
-		if inShift {
-			return sm.createShift(localNow, sched, i+1)
+	today := civilDate(localNow)
+
+	// A shift running now started today or, past midnight, yesterday
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		for _, sched := range sm.calendar.Schedules {
+			crew, ok := sm.calendar.worksOn(sched, day)
+			if !ok {
+				continue
+			}
+			shift := sm.createShift(day, sched, crew)
+			if !localNow.Before(shift.StartTime) && localNow.Before(shift.EndTime) {
+				return shift
+			}
 		}
 	}
 
-	// Default to first shift if no match (shouldn't happen with 24/7 schedule)
-	return sm.createShift(localNow, sm.schedules[0], 1)
+	return nil
 }
 
-func (sm *ShiftManager) createShift(now time.Time, sched ShiftSchedule, shiftNumber int) *simulator.Shift {
-	// Calculate shift start and end times for today
-	startTime := time.Date(now.Year(), now.Month(), now.Day(), sched.Start, 0, 0, 0, sm.location)
-	endTime := time.Date(now.Year(), now.Month(), now.Day(), sched.End, 0, 0, 0, sm.location)
-
-	// Adjust for night shift
-	if sched.End < sched.Start {
-		if now.Hour() < sched.End {
-			// We're in the morning part of night shift
-			startTime = startTime.AddDate(0, 0, -1)
-		} else {
-			// We're in the evening part of night shift
-			endTime = endTime.AddDate(0, 0, 1)
-		}
+// createShift builds the shift of sched starting on day
+func (sm *ShiftManager) createShift(day time.Time, sched ShiftSchedule, crew string) *simulator.Shift {
+	// Shift and break times are wall-clock times, so a night shift spanning a
+	// daylight saving change is an hour shorter or longer
+	at := func(offsetMinutes int) time.Time {
+		return localTime(day, int(sched.Start)+offsetMinutes, sm.location)
 	}
 
+	startTime := at(0)
+	endTime := at(sched.length())
+
 	// Create planned breaks
 	breaks := make([]simulator.PlannedBreak, 0, len(sched.Breaks))
 	for _, b := range sched.Breaks {
-		breakStart := time.Date(now.Year(), now.Month(), now.Day(), b.StartHour, b.StartMinute, 0, 0, sm.location)
-		breakEnd := time.Date(now.Year(), now.Month(), now.Day(), b.EndHour, b.EndMinute, 0, 0, sm.location)
-
-		// Adjust for night shift breaks that are in the next day
-		if sched.End < sched.Start && b.StartHour < sched.End {
-			if now.Hour() >= sched.Start {
-				breakStart = breakStart.AddDate(0, 0, 1)
-				breakEnd = breakEnd.AddDate(0, 0, 1)
-			}
-		}
-
+		offset := b.Start.offsetFrom(sched.Start)
 		breaks = append(breaks, simulator.PlannedBreak{
-			Start: breakStart,
-			End:   breakEnd,
+			Start: at(offset),
+			End:   at(offset + b.End.offsetFrom(b.Start)),
 			Type:  b.Type,
 		})
 	}
 
-	shiftID := fmt.Sprintf("SHIFT-%s-%s", startTime.Format("2006-01-02"), sched.Name[:1])
+	shiftID := fmt.Sprintf("SHIFT-%s-%s", startTime.Format("2006-01-02"), sched.Code)
 
 	return &simulator.Shift{
 		ShiftID:       shiftID,
 		ShiftName:     sched.Name,
-		ShiftNumber:   shiftNumber,
+		ShiftNumber:   sm.shiftNumbers[sched.Name],
+		Crew:          crew,
 		StartTime:     startTime,
 		EndTime:       endTime,
 		WorkCenterID:  sm.workCenterID,
@@ -200,16 +239,15 @@ func (sm *ShiftManager) createShift(now time.Time, sched ShiftSchedule, shiftNum
 	}
 }
 
-// IsBreakTime checks if the current time is during a break
+// IsBreakTime checks if the current time is during a break. Breaks include
+// their start instant and end just before their end instant.
 func (sm *ShiftManager) IsBreakTime(now time.Time, shift *simulator.Shift) bool {
 	if shift == nil {
 		return false
 	}
 
-	localNow := now.In(sm.location)
-
 	for _, b := range shift.PlannedBreaks {
-		if localNow.After(b.Start) && localNow.Before(b.End) {
+		if !now.Before(b.Start) && now.Before(b.End) {
 			return true
 		}
 	}
@@ -217,23 +255,28 @@ func (sm *ShiftManager) IsBreakTime(now time.Time, shift *simulator.Shift) bool
 	return false
 }
 
-// HasShiftChanged checks if the shift has changed and returns the new shift if so
+// HasShiftChanged checks if the shift has changed and returns the new shift
+// if so. The new shift is nil when unscheduled time begins.
 func (sm *ShiftManager) HasShiftChanged(now time.Time) (*simulator.Shift, bool) {
 	newShift := sm.GetCurrentShift(now)
 
-	if sm.currentShift == nil {
-		sm.currentShift = newShift
-		return newShift, true
+	if sm.initialized && shiftID(sm.currentShift) == shiftID(newShift) {
+		return nil, false
 	}
 
-	if sm.currentShift.ShiftID != newShift.ShiftID {
-		oldShift := sm.currentShift
-		oldShift.Status = simulator.ShiftStatusEnded
-		sm.currentShift = newShift
-		return newShift, true
+	if sm.currentShift != nil {
+		sm.currentShift.Status = simulator.ShiftStatusEnded
 	}
+	sm.currentShift = newShift
+	sm.initialized = true
+	return newShift, true
+}
 
-	return nil, false
+func shiftID(shift *simulator.Shift) string {
+	if shift == nil {
+		return ""
+	}
+	return shift.ShiftID
 }
 
 // GetCurrentShiftRef returns a reference to the current shift
@@ -17,6 +17,9 @@ type Status struct {
 type Handler struct {
 	opcuaReady bool
 	startTime  time.Time
+
+	// Returns when the OPC UA server certificate expires, zero if unknown
+	certificateExpiry func() time.Time
 }
 
 // NewHandler creates a new health handler
@@ -31,6 +34,12 @@ func (h *Handler) SetOPCUAReady(ready bool) {
 	h.opcuaReady = ready
 }
 
+// SetCertificateExpiry sets the lookup of the OPC UA server certificate
+// expiry reported by the readiness probe
+func (h *Handler) SetCertificateExpiry(expiry func() time.Time) {
+	h.certificateExpiry = expiry
+}
+
 // HandleLive handles the liveness probe
 // Returns 200 if the application is running
 func (h *Handler) HandleLive(w http.ResponseWriter, r *http.Request) {
@@ -58,6 +67,20 @@ func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
 		allHealthy = false
 	}
 
+	// Check the OPC UA server certificate; an expired one fails secured
+	// connections
+	if h.certificateExpiry != nil {
+		if expiry := h.certificateExpiry(); !expiry.IsZero() {
+			checks["opcua_certificate_expiry"] = expiry.UTC().Format(time.RFC3339)
+			if time.Now().Before(expiry) {
+				checks["opcua_certificate"] = "valid"
+			} else {
+				checks["opcua_certificate"] = "expired"
+				allHealthy = false
+			}
+		}
+	}
+
 	// Check uptime (give 5 seconds for startup)
 	uptime := time.Since(h.startTime)
 	if uptime > 5*time.Second {
@@ -0,0 +1,419 @@
+package opcua
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// alarm is the AlarmCondition of an error code. Alarms are only changed in
+// the simulation loop.
+type alarm struct {
+	id           ua.NodeID
+	code         simulator.ErrorCode
+	message      string
+	severity     uint16
+	lastSeverity uint16
+	active       bool
+	acked        bool
+	confirmed    bool
+	comment      ua.LocalizedText
+	clientUserID string
+
+	// Last event of the condition, sent again on ConditionRefresh
+	event *event
+}
+
+// retain reports whether the condition is of interest to clients: while it
+// is active or awaits acknowledgement or confirmation
+func (a *alarm) retain() bool {
+	return a.active || !a.acked || !a.confirmed
+}
+
+// event is an event notification. Its fields are looked up by browse path,
+// whichever event type the select clause of a client names.
+type event struct {
+	conditionID ua.NodeID
+	fields      map[string]ua.Variant
+}
+
+// GetAttribute returns the field selected by clause
+func (e *event) GetAttribute(clause ua.SimpleAttributeOperand) ua.Variant {
+	if len(clause.BrowsePath) == 0 {
+		// The ConditionId is selected as the NodeId of the condition
+		if clause.AttributeID == ua.AttributeIDNodeID && e.conditionID != nil {
+			return e.conditionID
+		}
+		return nil
+	}
+	if clause.AttributeID != ua.AttributeIDValue {
+		return nil
+	}
+	names := make([]string, len(clause.BrowsePath))
+	for i, name := range clause.BrowsePath {
+		names[i] = name.Name
+	}
+	return e.fields[strings.Join(names, "/")]
+}
+
+// twoState returns the fields of a TwoStateVariable
+func twoState(name string, value bool, trueState, falseState string) map[string]ua.Variant {
+	text := ua.LocalizedText{Text: falseState, Locale: "en"}
+	if value {
+		text = ua.LocalizedText{Text: trueState, Locale: "en"}
+	}
+	return map[string]ua.Variant{
+		name:                           text,
+		name + "/Id":                   value,
+		name + "/EffectiveDisplayName": text,
+	}
+}
+
+// newEventID returns a unique EventId
+func newEventID() ua.ByteString {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return ua.ByteString(b)
+}
+
+// createAlarms lets clients acknowledge, confirm and comment the alarms of
+// robot and refresh their conditions
+func (s *Server) createAlarms(robot, controller objectRef) {
+	s.alarmSource = robot
+	s.alarmParent = controller
+
+	nm := s.srv.NamespaceManager()
+	for _, m := range []struct {
+		id     ua.NodeID
+		handle func(*server.Session, ua.CallMethodRequest) ua.CallMethodResult
+	}{
+		{ua.MethodIDAcknowledgeableConditionTypeAcknowledge, s.acknowledgeAlarm},
+		{ua.MethodIDAcknowledgeableConditionTypeConfirm, s.confirmAlarm},
+		{ua.MethodIDConditionTypeAddComment, s.commentAlarm},
+		{ua.MethodIDConditionTypeConditionRefresh, s.conditionRefresh},
+		{ua.MethodIDConditionTypeConditionRefresh2, s.conditionRefresh2},
+	} {
+		if node, ok := nm.FindMethod(m.id); ok {
+			node.SetCallMethodHandler(m.handle)
+		}
+	}
+
+	// Alarms raised before a restart of the server keep their state
+	for _, a := range s.alarms {
+		s.addAlarmNode(a)
+	}
+}
+
+// RaiseAlarm activates the alarm of an error. A new occurrence replaces an
+// earlier one that has not been acknowledged or confirmed yet.
+func (s *Server) RaiseAlarm(err *simulator.ErrorInfo) {
+	if s.srv == nil {
+		return
+	}
+	a := s.alarm(err.Code, err.Message)
+	a.lastSeverity = a.severity
+	a.message = err.Message
+	a.severity = uint16(err.Severity)
+	a.active = true
+	a.acked = false
+	a.confirmed = false
+	a.comment = ua.LocalizedText{}
+	a.clientUserID = ""
+	s.emitAlarm(a, err.OccurredAt)
+}
+
+// ClearAlarm deactivates the alarm of an error that has been cleared. It is
+// retained until it is acknowledged and confirmed.
+func (s *Server) ClearAlarm(err *simulator.ErrorInfo) {
+	if s.srv == nil {
+		return
+	}
+	for _, a := range s.alarms {
+		if a.code == err.Code && a.active {
+			a.active = false
+			a.lastSeverity = a.severity
+			s.emitAlarm(a, time.Now())
+		}
+	}
+}
+
+// alarm returns the alarm of code, creating its condition object below the
+// controller on first use
+func (s *Server) alarm(code simulator.ErrorCode, message string) *alarm {
+	for _, a := range s.alarms {
+		if a.code == code {
+			return a
+		}
+	}
+
+	a := &alarm{code: code, message: message, acked: true, confirmed: true}
+	s.addAlarmNode(a)
+	s.alarms = append(s.alarms, a)
+	return a
+}
+
+// addAlarmNode adds the condition object of a to the address space
+func (s *Server) addAlarmNode(a *alarm) {
+	node := server.NewObjectNode(
+		s.srv,
+		s.nodeID("Robot.Alarm."+string(a.code)),
+		s.browseName(string(a.code)),
+		ua.LocalizedText{Text: string(a.code)},
+		ua.LocalizedText{Text: a.message},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.alarmParent.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasCondition,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.alarmSource.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: ua.ObjectTypeIDAlarmConditionType},
+			},
+		},
+		0,
+	)
+	s.srv.NamespaceManager().AddNode(node)
+	a.id = node.NodeID()
+}
+
+// findAlarm returns the alarm whose condition object is id
+func (s *Server) findAlarm(id ua.NodeID) (*alarm, bool) {
+	for _, a := range s.alarms {
+		if a.id == id {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// emitAlarm sends the state of a as a new event from the robot
+func (s *Server) emitAlarm(a *alarm, now time.Time) {
+	a.event = &event{
+		conditionID: a.id,
+		fields: map[string]ua.Variant{
+			"EventId":            newEventID(),
+			"EventType":          ua.ObjectTypeIDAlarmConditionType,
+			"SourceNode":         s.alarmSource.id,
+			"SourceName":         s.cfg.SimulatorName,
+			"Time":               now.UTC(),
+			"ReceiveTime":        time.Now().UTC(),
+			"Message":            ua.LocalizedText{Text: a.message, Locale: "en"},
+			"Severity":           a.severity,
+			"ConditionClassId":   ua.ObjectTypeIDProcessConditionClassType,
+			"ConditionClassName": ua.LocalizedText{Text: "Process", Locale: "en"},
+			"ConditionName":      string(a.code),
+			"BranchId":           ua.NodeID(nil),
+			"Retain":             a.retain(),
+			"Quality":            ua.Good,
+			"LastSeverity":       a.lastSeverity,
+			"Comment":            a.comment,
+			"ClientUserId":       a.clientUserID,
+		},
+	}
+	for _, state := range []map[string]ua.Variant{
+		twoState("EnabledState", true, "Enabled", "Disabled"),
+		twoState("ActiveState", a.active, "Active", "Inactive"),
+		twoState("AckedState", a.acked, "Acknowledged", "Unacknowledged"),
+		twoState("ConfirmedState", a.confirmed, "Confirmed", "Unconfirmed"),
+	} {
+		for name, value := range state {
+			a.event.fields[name] = value
+		}
+	}
+
+	if robot, ok := s.srv.NamespaceManager().FindObject(s.alarmSource.id); ok {
+		s.srv.NamespaceManager().OnEvent(robot, a.event)
+	}
+	log.Debug().
+		Str("code", string(a.code)).
+		Bool("active", a.active).
+		Bool("acked", a.acked).
+		Bool("confirmed", a.confirmed).
+		Msg("OPC UA alarm event sent")
+}
+
+// Arguments of the condition methods
+var (
+	eventIDArgument = argument("EventId", ua.DataTypeIDByteString, "EventId of the event the call refers to")
+	commentArgument = argument("Comment", ua.DataTypeIDLocalizedText, "Comment to add to the condition")
+)
+
+func (s *Server) acknowledgeAlarm(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+	return s.changeAlarm(session, req, func(a *alarm) ua.StatusCode {
+		if a.acked {
+			return ua.BadConditionBranchAlreadyAcked
+		}
+		a.acked = true
+		return ua.Good
+	})
+}
+
+func (s *Server) confirmAlarm(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+	return s.changeAlarm(session, req, func(a *alarm) ua.StatusCode {
+		if a.confirmed {
+			return ua.BadConditionBranchAlreadyConfirmed
+		}
+		a.confirmed = true
+		return ua.Good
+	})
+}
+
+func (s *Server) commentAlarm(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+	return s.changeAlarm(session, req, func(a *alarm) ua.StatusCode {
+		return ua.Good
+	})
+}
+
+// changeAlarm applies change to the alarm the call is made on, records the
+// comment and sends the new state. The EventId must be that of the latest
+// event of the alarm.
+func (s *Server) changeAlarm(session *server.Session, req ua.CallMethodRequest, change func(a *alarm) ua.StatusCode) ua.CallMethodResult {
+	if result, ok := checkArguments([]ua.Argument{eventIDArgument, commentArgument}, req.InputArguments); !ok {
+		return result
+	}
+	eventID := req.InputArguments[0].(ua.ByteString)
+	comment := req.InputArguments[1].(ua.LocalizedText)
+
+	var status ua.StatusCode
+	if !s.execute(func(now time.Time) {
+		a, ok := s.findAlarm(req.ObjectID)
+		if !ok {
+			status = ua.BadNodeIDUnknown
+			return
+		}
+		if a.event == nil || a.event.fields["EventId"] != eventID {
+			status = ua.BadEventIDUnknown
+			return
+		}
+		if status = change(a); status != ua.Good {
+			return
+		}
+		if comment.Text != "" {
+			a.comment = comment
+		}
+		a.clientUserID = userName(session)
+		s.emitAlarm(a, now)
+	}) {
+		return ua.CallMethodResult{StatusCode: ua.BadTimeout}
+	}
+	return ua.CallMethodResult{StatusCode: status}
+}
+
+// userName returns the name of the user of a session
+func userName(session *server.Session) string {
+	switch identity := session.UserIdentity().(type) {
+	case ua.UserNameIdentity:
+		return identity.UserName
+	case ua.X509Identity:
+		return "X509"
+	default:
+		return "Anonymous"
+	}
+}
+
+// conditionRefresh sends the retained conditions to the event items of a
+// subscription of the session
+func (s *Server) conditionRefresh(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+	inputs := []ua.Argument{argument("SubscriptionId", ua.DataTypeIDUInt32, "Subscription to refresh")}
+	if result, ok := checkArguments(inputs, req.InputArguments); !ok {
+		return result
+	}
+	sub, ok := s.subscription(session, req.InputArguments[0].(uint32))
+	if !ok {
+		return ua.CallMethodResult{StatusCode: ua.BadSubscriptionIDInvalid}
+	}
+
+	var items []*server.EventMonitoredItem
+	for _, item := range sub.Items() {
+		if item, ok := item.(*server.EventMonitoredItem); ok {
+			items = append(items, item)
+		}
+	}
+	return s.refresh(items)
+}
+
+// conditionRefresh2 sends the retained conditions to one event item of a
+// subscription of the session
+func (s *Server) conditionRefresh2(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+	inputs := []ua.Argument{
+		argument("SubscriptionId", ua.DataTypeIDUInt32, "Subscription to refresh"),
+		argument("MonitoredItemId", ua.DataTypeIDUInt32, "Event item to refresh"),
+	}
+	if result, ok := checkArguments(inputs, req.InputArguments); !ok {
+		return result
+	}
+	sub, ok := s.subscription(session, req.InputArguments[0].(uint32))
+	if !ok {
+		return ua.CallMethodResult{StatusCode: ua.BadSubscriptionIDInvalid}
+	}
+	item, ok := sub.FindItem(req.InputArguments[1].(uint32))
+	eventItem, isEvent := item.(*server.EventMonitoredItem)
+	if !ok || !isEvent {
+		return ua.CallMethodResult{StatusCode: ua.BadMonitoredItemIDInvalid}
+	}
+	return s.refresh([]*server.EventMonitoredItem{eventItem})
+}
+
+// subscription returns the subscription id of session
+func (s *Server) subscription(session *server.Session, id uint32) (*server.Subscription, bool) {
+	sub, ok := s.srv.SubscriptionManager().Get(id)
+	if !ok {
+		return nil, false
+	}
+	for _, own := range s.srv.SubscriptionManager().GetBySession(session) {
+		if own == sub {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+// refresh sends the retained conditions to items, framed by a RefreshStart
+// and a RefreshEnd event
+func (s *Server) refresh(items []*server.EventMonitoredItem) ua.CallMethodResult {
+	if !s.execute(func(now time.Time) {
+		events := []ua.Event{s.refreshEvent(ua.ObjectTypeIDRefreshStartEventType, now)}
+		for _, a := range s.alarms {
+			if a.retain() && a.event != nil {
+				events = append(events, a.event)
+			}
+		}
+		events = append(events, s.refreshEvent(ua.ObjectTypeIDRefreshEndEventType, now))
+
+		for _, item := range items {
+			for _, e := range events {
+				item.OnEvent(e)
+			}
+		}
+	}) {
+		return ua.CallMethodResult{StatusCode: ua.BadTimeout}
+	}
+	return ua.CallMethodResult{}
+}
+
+// refreshEvent returns a RefreshStart or RefreshEnd event of the Server
+func (s *Server) refreshEvent(eventType ua.NodeID, now time.Time) *event {
+	return &event{fields: map[string]ua.Variant{
+		"EventId":     newEventID(),
+		"EventType":   eventType,
+		"SourceNode":  ua.ObjectIDServer,
+		"SourceName":  "Server",
+		"Time":        now.UTC(),
+		"ReceiveTime": now.UTC(),
+		"Message":     ua.LocalizedText{},
+		"Severity":    uint16(1),
+	}}
+}
@@ -0,0 +1,571 @@
+package opcua
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// XML namespaces of NodeSet2 files and of the values within
+const (
+	nodeSetNamespace = "http://opcfoundation.org/UA/2011/03/UANodeSet.xsd"
+	typesNamespace   = "http://opcfoundation.org/UA/2008/02/Types.xsd"
+)
+
+// References followed from the standard nodes to find the simulator's nodes
+var hierarchicalReferences = []ua.NodeID{
+	ua.ReferenceTypeIDOrganizes,
+	ua.ReferenceTypeIDHasComponent,
+	ua.ReferenceTypeIDHasOrderedComponent,
+	ua.ReferenceTypeIDHasProperty,
+	ua.ReferenceTypeIDHasAddIn,
+	ua.ReferenceTypeIDHasSubtype,
+	ua.ReferenceTypeIDHasNotifier,
+	ua.ReferenceTypeIDHasEventSource,
+}
+
+// ExportNodeSet writes the address space of cfg as NodeSet2 XML to w: the
+// types, DataTypes and instances of the simulator namespace and the nodes of
+// the custom NodeSet. Companion NodeSets are required models of the file,
+// not part of it. Values are those of a freshly started simulator. The
+// server is built but does not listen, and its certificate is created in a
+// temporary directory so the configured PKI is left alone.
+func ExportNodeSet(cfg *config.Config, w io.Writer) error {
+	pkiDir, err := os.MkdirTemp("", "shopfloor-simulator-pki")
+	if err != nil {
+		return fmt.Errorf("failed to create PKI directory: %w", err)
+	}
+	defer os.RemoveAll(pkiDir)
+
+	exportCfg := *cfg
+	exportCfg.OPCUAPKIDir = pkiDir
+	exportCfg.OPCUACertFile = ""
+	exportCfg.OPCUAKeyFile = ""
+
+	s, err := NewServer(&exportCfg, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureCertificate(time.Now()); err != nil {
+		return err
+	}
+	if err := s.ensureTrustList(); err != nil {
+		return err
+	}
+	if s.srv, err = s.newServer(); err != nil {
+		return err
+	}
+	if err := s.createNodes(); err != nil {
+		return err
+	}
+
+	set := newNodeSetWriter(s.srv.NamespaceManager(), s.companions)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set.build(time.Now().UTC())); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// nodeSetWriter converts nodes to their NodeSet2 form. Namespace indexes of
+// the server are renumbered to the NamespaceUris of the file.
+type nodeSetWriter struct {
+	nm         *server.NamespaceManager
+	companions []uint16 // Namespaces of the companion NodeSets
+	serverURIs []string
+	namespaces map[uint16]uint16 // server index to file index
+	uris       []string          // NamespaceUris of the file
+	aliases    map[string]string
+}
+
+func newNodeSetWriter(nm *server.NamespaceManager, companions []uint16) *nodeSetWriter {
+	return &nodeSetWriter{
+		nm:         nm,
+		companions: companions,
+		serverURIs: nm.NamespaceUris(),
+		namespaces: make(map[uint16]uint16),
+		aliases:    make(map[string]string),
+	}
+}
+
+type xmlNodeSet struct {
+	XMLName       xml.Name   `xml:"UANodeSet"`
+	Xmlns         string     `xml:"xmlns,attr"`
+	LastModified  string     `xml:"LastModified,attr"`
+	NamespaceUris []string   `xml:"NamespaceUris>Uri"`
+	Models        []xmlModel `xml:"Models>Model"`
+	Aliases       []xmlAlias `xml:"Aliases>Alias"`
+	Nodes         []xmlNode
+}
+
+type xmlModel struct {
+	ModelURI        string             `xml:"ModelUri,attr"`
+	PublicationDate string             `xml:"PublicationDate,attr"`
+	RequiredModels  []xmlRequiredModel `xml:"RequiredModel"`
+}
+
+type xmlRequiredModel struct {
+	ModelURI string `xml:"ModelUri,attr"`
+}
+
+type xmlAlias struct {
+	Alias  string `xml:"Alias,attr"`
+	NodeID string `xml:",chardata"`
+}
+
+type xmlNode struct {
+	XMLName         xml.Name
+	NodeID          string         `xml:"NodeId,attr"`
+	BrowseName      string         `xml:"BrowseName,attr"`
+	ParentNodeID    string         `xml:"ParentNodeId,attr,omitempty"`
+	DataType        string         `xml:"DataType,attr,omitempty"`
+	ValueRank       string         `xml:"ValueRank,attr,omitempty"`
+	ArrayDimensions string         `xml:"ArrayDimensions,attr,omitempty"`
+	AccessLevel     string         `xml:"AccessLevel,attr,omitempty"`
+	Historizing     bool           `xml:"Historizing,attr,omitempty"`
+	EventNotifier   byte           `xml:"EventNotifier,attr,omitempty"`
+	IsAbstract      bool           `xml:"IsAbstract,attr,omitempty"`
+	DisplayName     xmlText        `xml:"DisplayName"`
+	Description     *xmlText       `xml:"Description,omitempty"`
+	References      []xmlReference `xml:"References>Reference"`
+	Definition      *xmlDefinition `xml:"Definition,omitempty"`
+	Value           *xmlValue      `xml:"Value,omitempty"`
+}
+
+type xmlText struct {
+	Locale string `xml:"Locale,attr,omitempty"`
+	Text   string `xml:",chardata"`
+}
+
+type xmlReference struct {
+	ReferenceType string `xml:"ReferenceType,attr"`
+	IsForward     string `xml:"IsForward,attr,omitempty"`
+	Target        string `xml:",chardata"`
+}
+
+type xmlDefinition struct {
+	Name   string     `xml:"Name,attr"`
+	Fields []xmlField `xml:"Field"`
+}
+
+type xmlField struct {
+	Name        string   `xml:"Name,attr"`
+	DataType    string   `xml:"DataType,attr"`
+	ValueRank   string   `xml:"ValueRank,attr,omitempty"`
+	Description *xmlText `xml:"Description,omitempty"`
+}
+
+type xmlValue struct {
+	XML string `xml:",innerxml"`
+}
+
+// build collects the nodes to export and converts them
+func (w *nodeSetWriter) build(now time.Time) xmlNodeSet {
+	nodes := w.collect()
+
+	// Namespaces of the exported nodes come first, in server order
+	var own []uint16
+	for _, node := range nodes {
+		if ns := namespaceIndex(node.NodeID()); !slices.Contains(own, ns) {
+			own = append(own, ns)
+		}
+	}
+	slices.Sort(own)
+	for _, ns := range own {
+		w.namespace(ns)
+	}
+
+	set := xmlNodeSet{
+		Xmlns:        nodeSetNamespace,
+		LastModified: now.Format(time.RFC3339),
+	}
+	for _, node := range nodes {
+		set.Nodes = append(set.Nodes, w.node(node))
+	}
+	set.NamespaceUris = w.uris
+
+	for _, ns := range own {
+		model := xmlModel{ModelURI: w.serverURIs[ns], PublicationDate: now.Format(time.RFC3339)}
+		for _, required := range append([]uint16{0}, w.companions...) {
+			model.RequiredModels = append(model.RequiredModels, xmlRequiredModel{ModelURI: w.serverURIs[required]})
+		}
+		set.Models = append(set.Models, model)
+	}
+	for alias, id := range w.aliases {
+		set.Aliases = append(set.Aliases, xmlAlias{Alias: alias, NodeID: id})
+	}
+	slices.SortFunc(set.Aliases, func(a, b xmlAlias) int { return strings.Compare(a.Alias, b.Alias) })
+	return set
+}
+
+// collect returns the nodes outside namespace 0 and the companion namespaces
+// that can be reached from the RootFolder, types first. Standard nodes are
+// only passed through along forward hierarchical references.
+func (w *nodeSetWriter) collect() []server.Node {
+	var nodes []server.Node
+	visited := map[ua.NodeID]bool{ua.ObjectIDRootFolder: true}
+	queue := []ua.NodeID{ua.ObjectIDRootFolder}
+	visit := func(id ua.NodeID) {
+		if id != nil && !visited[id] {
+			visited[id] = true
+			queue = append(queue, id)
+		}
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		node, ok := w.nm.FindNode(id)
+		if !ok {
+			continue
+		}
+		ns := namespaceIndex(id)
+		own := ns != 0 && !slices.Contains(w.companions, ns)
+		if own {
+			nodes = append(nodes, node)
+			if v, ok := node.(*server.VariableNode); ok {
+				visit(v.DataType())
+			}
+		}
+		for _, r := range node.References() {
+			if !own && (r.IsInverse || !slices.Contains(hierarchicalReferences, r.ReferenceTypeID)) {
+				continue
+			}
+			visit(ua.ToNodeID(r.TargetID, w.serverURIs))
+		}
+	}
+
+	rank := func(node server.Node) int {
+		return slices.Index([]ua.NodeClass{
+			ua.NodeClassReferenceType, ua.NodeClassDataType, ua.NodeClassObjectType, ua.NodeClassVariableType,
+			ua.NodeClassObject, ua.NodeClassVariable, ua.NodeClassMethod, ua.NodeClassView,
+		}, node.NodeClass())
+	}
+	slices.SortFunc(nodes, func(a, b server.Node) int {
+		if c := rank(a) - rank(b); c != 0 {
+			return c
+		}
+		return strings.Compare(fmt.Sprint(a.NodeID()), fmt.Sprint(b.NodeID()))
+	})
+	return nodes
+}
+
+// namespace returns the file index of the server namespace ns
+func (w *nodeSetWriter) namespace(ns uint16) uint16 {
+	if ns == 0 {
+		return 0
+	}
+	if index, ok := w.namespaces[ns]; ok {
+		return index
+	}
+	w.uris = append(w.uris, w.serverURIs[ns])
+	index := uint16(len(w.uris))
+	w.namespaces[ns] = index
+	return index
+}
+
+// nodeID formats id with the namespace index of the file
+func (w *nodeSetWriter) nodeID(id ua.NodeID) string {
+	return fmt.Sprint(withNamespace(id, w.namespace(namespaceIndex(id))))
+}
+
+// alias formats a standard type by its browse name and records the alias
+func (w *nodeSetWriter) alias(id ua.NodeID) string {
+	if namespaceIndex(id) != 0 {
+		return w.nodeID(id)
+	}
+	node, ok := w.nm.FindNode(id)
+	if !ok {
+		return fmt.Sprint(id)
+	}
+	name := node.BrowseName().Name
+	w.aliases[name] = fmt.Sprint(id)
+	return name
+}
+
+func (w *nodeSetWriter) browseName(name ua.QualifiedName) string {
+	if name.NamespaceIndex == 0 {
+		return name.Name
+	}
+	return fmt.Sprintf("%d:%s", w.namespace(name.NamespaceIndex), name.Name)
+}
+
+func text(t ua.LocalizedText) *xmlText {
+	if t.Text == "" {
+		return nil
+	}
+	return &xmlText{Locale: t.Locale, Text: t.Text}
+}
+
+// node converts node with its attributes and references
+func (w *nodeSetWriter) node(node server.Node) xmlNode {
+	x := xmlNode{
+		NodeID:      w.nodeID(node.NodeID()),
+		BrowseName:  w.browseName(node.BrowseName()),
+		DisplayName: xmlText{Locale: node.DisplayName().Locale, Text: node.DisplayName().Text},
+		Description: text(node.Description()),
+	}
+	for _, r := range node.References() {
+		ref := xmlReference{
+			ReferenceType: w.alias(r.ReferenceTypeID),
+			Target:        w.nodeID(ua.ToNodeID(r.TargetID, w.serverURIs)),
+		}
+		if r.IsInverse {
+			ref.IsForward = "false"
+			// Instances name the node they are a component or property of
+			if x.ParentNodeID == "" && (r.ReferenceTypeID == ua.ReferenceTypeIDHasComponent || r.ReferenceTypeID == ua.ReferenceTypeIDHasProperty) {
+				x.ParentNodeID = ref.Target
+			}
+		}
+		x.References = append(x.References, ref)
+	}
+
+	switch n := node.(type) {
+	case *server.ObjectNode:
+		x.XMLName.Local = "UAObject"
+		x.EventNotifier = n.EventNotifier()
+	case *server.VariableNode:
+		x.XMLName.Local = "UAVariable"
+		x.DataType = w.alias(n.DataType())
+		if n.ValueRank() != ua.ValueRankScalar {
+			x.ValueRank = strconv.Itoa(int(n.ValueRank()))
+		}
+		x.ArrayDimensions = dimensions(n.ArrayDimensions())
+		if n.AccessLevel() != ua.AccessLevelsCurrentRead {
+			x.AccessLevel = strconv.Itoa(int(n.AccessLevel()))
+		}
+		x.Historizing = n.Historizing()
+		if value, ok := w.value(n.Value().Value); ok {
+			x.Value = &xmlValue{XML: value}
+		}
+	case *server.MethodNode:
+		x.XMLName.Local = "UAMethod"
+	case *server.ObjectTypeNode:
+		x.XMLName.Local = "UAObjectType"
+		x.IsAbstract = n.IsAbstract()
+	case *server.VariableTypeNode:
+		x.XMLName.Local = "UAVariableType"
+		x.IsAbstract = n.IsAbstract()
+		x.DataType = w.alias(n.DataType())
+		if n.ValueRank() != ua.ValueRankScalar {
+			x.ValueRank = strconv.Itoa(int(n.ValueRank()))
+		}
+		x.ArrayDimensions = dimensions(n.ArrayDimensions())
+	case *server.DataTypeNode:
+		x.XMLName.Local = "UADataType"
+		x.IsAbstract = n.IsAbstract()
+		if def, ok := n.DataTypeDefinition().(ua.StructureDefinition); ok {
+			x.Definition = &xmlDefinition{Name: x.BrowseName}
+			for _, f := range def.Fields {
+				field := xmlField{Name: f.Name, DataType: w.alias(f.DataType), Description: text(f.Description)}
+				if f.ValueRank != ua.ValueRankScalar {
+					field.ValueRank = strconv.Itoa(int(f.ValueRank))
+				}
+				x.Definition.Fields = append(x.Definition.Fields, field)
+			}
+		}
+	case *server.ReferenceTypeNode:
+		x.XMLName.Local = "UAReferenceType"
+		x.IsAbstract = n.IsAbstract()
+	case *server.ViewNode:
+		x.XMLName.Local = "UAView"
+		x.EventNotifier = n.EventNotifier()
+	}
+	return x
+}
+
+func dimensions(dims []uint32) string {
+	s := make([]string, len(dims))
+	for i, d := range dims {
+		s[i] = strconv.FormatUint(uint64(d), 10)
+	}
+	return strings.Join(s, ",")
+}
+
+// value encodes v as an element of the Types namespace. Structures other
+// than Argument, Range and EUInformation are left out; the simulator writes
+// them at runtime.
+func (w *nodeSetWriter) value(v interface{}) (string, bool) {
+	var buf bytes.Buffer
+	e := &valueEncoder{enc: xml.NewEncoder(&buf), space: typesNamespace}
+	if !w.encodeValue(e, v) {
+		return "", false
+	}
+	if err := e.enc.Flush(); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+func (w *nodeSetWriter) encodeValue(e *valueEncoder, v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		e.element("Boolean", strconv.FormatBool(v))
+	case int8:
+		e.element("SByte", strconv.Itoa(int(v)))
+	case uint8:
+		e.element("Byte", strconv.Itoa(int(v)))
+	case int16:
+		e.element("Int16", strconv.Itoa(int(v)))
+	case uint16:
+		e.element("UInt16", strconv.Itoa(int(v)))
+	case int32:
+		e.element("Int32", strconv.Itoa(int(v)))
+	case uint32:
+		e.element("UInt32", strconv.FormatUint(uint64(v), 10))
+	case int64:
+		e.element("Int64", strconv.FormatInt(v, 10))
+	case uint64:
+		e.element("UInt64", strconv.FormatUint(v, 10))
+	case float32:
+		e.element("Float", strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		e.element("Double", strconv.FormatFloat(v, 'g', -1, 64))
+	case string:
+		e.element("String", v)
+	case time.Time:
+		e.element("DateTime", v.UTC().Format(time.RFC3339Nano))
+	case ua.ByteString:
+		e.element("ByteString", base64.StdEncoding.EncodeToString([]byte(v)))
+	case ua.LocalizedText:
+		e.localizedText("LocalizedText", v)
+	case ua.QualifiedName:
+		e.start("QualifiedName")
+		e.element("NamespaceIndex", strconv.Itoa(int(w.namespace(v.NamespaceIndex))))
+		e.element("Name", v.Name)
+		e.end()
+	case ua.NodeIDNumeric, ua.NodeIDString, ua.NodeIDGUID, ua.NodeIDOpaque:
+		e.start("NodeId")
+		e.element("Identifier", w.nodeID(v.(ua.NodeID)))
+		e.end()
+	case []float64:
+		e.start("ListOfDouble")
+		for _, f := range v {
+			e.element("Double", strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		e.end()
+	case []string:
+		e.start("ListOfString")
+		for _, s := range v {
+			e.element("String", s)
+		}
+		e.end()
+	case []ua.ExtensionObject:
+		for _, o := range v {
+			if !w.encodable(o) {
+				return false
+			}
+		}
+		e.start("ListOfExtensionObject")
+		for _, o := range v {
+			w.encodeValue(e, o)
+		}
+		e.end()
+	case ua.Argument:
+		e.extensionObject(ua.ObjectIDArgumentEncodingDefaultXML, "Argument")
+		e.element("Name", v.Name)
+		e.start("DataType")
+		e.element("Identifier", w.nodeID(v.DataType))
+		e.end()
+		e.element("ValueRank", strconv.Itoa(int(v.ValueRank)))
+		e.start("ArrayDimensions")
+		for _, d := range v.ArrayDimensions {
+			e.element("UInt32", strconv.FormatUint(uint64(d), 10))
+		}
+		e.end()
+		e.localizedText("Description", v.Description)
+		e.endExtensionObject()
+	case ua.Range:
+		e.extensionObject(ua.ObjectIDRangeEncodingDefaultXML, "Range")
+		e.element("Low", strconv.FormatFloat(v.Low, 'g', -1, 64))
+		e.element("High", strconv.FormatFloat(v.High, 'g', -1, 64))
+		e.endExtensionObject()
+	case ua.EUInformation:
+		e.extensionObject(ua.ObjectIDEUInformationEncodingDefaultXML, "EUInformation")
+		e.element("NamespaceUri", v.NamespaceURI)
+		e.element("UnitId", strconv.Itoa(int(v.UnitID)))
+		e.localizedText("DisplayName", v.DisplayName)
+		e.localizedText("Description", v.Description)
+		e.endExtensionObject()
+	default:
+		return false
+	}
+	return true
+}
+
+// encodable reports whether the structure o has an XML encoding
+func (w *nodeSetWriter) encodable(o ua.ExtensionObject) bool {
+	switch o.(type) {
+	case ua.Argument, ua.Range, ua.EUInformation:
+		return true
+	}
+	return false
+}
+
+// valueEncoder writes the elements of a value. The outermost element
+// declares the Types namespace for all others.
+type valueEncoder struct {
+	enc   *xml.Encoder
+	space string
+	open  []xml.Name
+}
+
+func (e *valueEncoder) start(name string) {
+	n := xml.Name{Space: e.space, Local: name}
+	e.space = ""
+	e.enc.EncodeToken(xml.StartElement{Name: n})
+	e.open = append(e.open, n)
+}
+
+func (e *valueEncoder) end() {
+	n := e.open[len(e.open)-1]
+	e.open = e.open[:len(e.open)-1]
+	e.enc.EncodeToken(xml.EndElement{Name: n})
+}
+
+func (e *valueEncoder) element(name, value string) {
+	e.start(name)
+	e.enc.EncodeToken(xml.CharData(value))
+	e.end()
+}
+
+func (e *valueEncoder) localizedText(name string, t ua.LocalizedText) {
+	e.start(name)
+	if t.Locale != "" {
+		e.element("Locale", t.Locale)
+	}
+	e.element("Text", t.Text)
+	e.end()
+}
+
+func (e *valueEncoder) extensionObject(encoding ua.NodeID, body string) {
+	e.start("ExtensionObject")
+	e.start("TypeId")
+	e.element("Identifier", fmt.Sprint(encoding))
+	e.end()
+	e.start("Body")
+	e.start(body)
+}
+
+func (e *valueEncoder) endExtensionObject() {
+	e.end()
+	e.end()
+	e.end()
+}
@@ -0,0 +1,547 @@
+package opcua
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+)
+
+// processedPageSize is the number of processing intervals returned per
+// ReadProcessed call before a continuation point is handed out
+const processedPageSize = 1000
+
+// minTime is the earliest DateTime of OPC UA; clients send it for a time
+// they leave unspecified
+var minTime = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// aggregateCalculated marks a value calculated by an aggregate
+var aggregateCalculated = ua.StatusCode(ua.InfoTypeDataValue | ua.HistorianBitsCalculated)
+
+// historian keeps the latest values of each historized variable in a ring
+// buffer and answers HistoryRead requests from them. Values are written by
+// the variable nodes and read by the service workers.
+type historian struct {
+	size   int
+	mu     sync.RWMutex
+	series map[ua.NodeID]*series
+}
+
+// series is the ring buffer of one variable, oldest value at next once full
+type series struct {
+	values []ua.DataValue
+	next   int
+}
+
+func newHistorian(size int) *historian {
+	return &historian{
+		size:   size,
+		series: make(map[ua.NodeID]*series),
+	}
+}
+
+// track starts the history of a variable
+func (h *historian) track(nodeID ua.NodeID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.series[nodeID]; !ok {
+		h.series[nodeID] = &series{values: make([]ua.DataValue, 0, h.size)}
+	}
+}
+
+// WriteValue records a value of a tracked variable
+func (h *historian) WriteValue(ctx context.Context, nodeID ua.NodeID, value ua.DataValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[nodeID]
+	if !ok {
+		return ua.BadNodeIDUnknown
+	}
+	if len(s.values) < h.size {
+		s.values = append(s.values, value)
+		return nil
+	}
+	s.values[s.next] = value
+	s.next = (s.next + 1) % h.size
+	return nil
+}
+
+// WriteEvent is not supported, events are not historized
+func (h *historian) WriteEvent(ctx context.Context, nodeID ua.NodeID, eventFields []ua.Variant) error {
+	return ua.BadHistoryOperationUnsupported
+}
+
+// values returns the recorded values of a variable, oldest first
+func (h *historian) values(nodeID ua.NodeID) ([]ua.DataValue, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, ok := h.series[nodeID]
+	if !ok {
+		return nil, false
+	}
+	values := make([]ua.DataValue, 0, len(s.values))
+	values = append(values, s.values[s.next:]...)
+	return append(values, s.values[:s.next]...), true
+}
+
+// ReadEvent is not supported, events are not historized
+func (h *historian) ReadEvent(ctx context.Context, nodesToRead []ua.HistoryReadValueID, details ua.ReadEventDetails,
+	timestampsToReturn ua.TimestampsToReturn, releaseContinuationPoints bool) ([]ua.HistoryReadResult, ua.StatusCode) {
+	return nil, ua.BadHistoryOperationUnsupported
+}
+
+// ReadAtTime is not supported
+func (h *historian) ReadAtTime(ctx context.Context, nodesToRead []ua.HistoryReadValueID, details ua.ReadAtTimeDetails,
+	timestampsToReturn ua.TimestampsToReturn, releaseContinuationPoints bool) ([]ua.HistoryReadResult, ua.StatusCode) {
+	return nil, ua.BadHistoryOperationUnsupported
+}
+
+// ReadRawModified returns the recorded values between StartTime and
+// EndTime, in reverse order if EndTime is before StartTime. With only one of
+// them, NumValuesPerNode values are read forward from StartTime or backward
+// from EndTime. Values are never modified, so reading modified values
+// returns none.
+func (h *historian) ReadRawModified(ctx context.Context, nodesToRead []ua.HistoryReadValueID, details ua.ReadRawModifiedDetails,
+	timestampsToReturn ua.TimestampsToReturn, releaseContinuationPoints bool) ([]ua.HistoryReadResult, ua.StatusCode) {
+	hasStart, hasEnd := specified(details.StartTime), specified(details.EndTime)
+	if !hasStart && !hasEnd || hasStart != hasEnd && details.NumValuesPerNode == 0 {
+		return nil, ua.BadInvalidTimestampArgument
+	}
+
+	// from is where reading starts, to where it stops if hasTo
+	from, to, hasTo := details.StartTime, details.EndTime, hasEnd
+	reverse := hasStart && hasEnd && to.Before(from)
+	if !hasStart {
+		from, hasTo, reverse = details.EndTime, false, true
+	}
+
+	results := make([]ua.HistoryReadResult, len(nodesToRead))
+	for i, node := range nodesToRead {
+		if releaseContinuationPoints {
+			continue
+		}
+		values, ok := h.values(node.NodeID)
+		if !ok {
+			results[i].StatusCode = ua.BadNodeIDUnknown
+			continue
+		}
+		if node.IndexRange != "" {
+			results[i].StatusCode = ua.BadIndexRangeNoData
+			continue
+		}
+		if details.IsReadModified {
+			results[i] = ua.HistoryReadResult{StatusCode: ua.GoodNoData, HistoryData: ua.HistoryData{DataValues: []ua.DataValue{}}}
+			continue
+		}
+
+		start, continued, ok := resume(node.ContinuationPoint, from)
+		if !ok {
+			results[i].StatusCode = ua.BadContinuationPointInvalid
+			continue
+		}
+		if reverse {
+			values = reversed(values)
+		}
+
+		var selected []ua.DataValue
+		first, last := -1, len(values)
+		for j, v := range values {
+			t := v.SourceTimestamp
+			if before(t, start, reverse) {
+				first = j
+				continue
+			}
+			if hasTo && !before(t, to, reverse) {
+				last = j
+				break
+			}
+			selected = append(selected, v)
+		}
+
+		var cp ua.ByteString
+		if n := int(details.NumValuesPerNode); n > 0 && len(selected) > n {
+			cp = continuationPoint(selected[n].SourceTimestamp)
+			selected = selected[:n]
+		}
+		if details.ReturnBounds {
+			if !continued {
+				selected = append([]ua.DataValue{bound(values, first, start)}, selected...)
+			}
+			if hasTo && cp == "" {
+				selected = append(selected, bound(values, last, to))
+			}
+		}
+
+		status := ua.Good
+		if len(selected) == 0 {
+			status = ua.GoodNoData
+		}
+		results[i] = ua.HistoryReadResult{
+			StatusCode:        status,
+			ContinuationPoint: cp,
+			HistoryData:       ua.HistoryData{DataValues: withTimestamps(selected, timestampsToReturn)},
+		}
+	}
+	return results, ua.Good
+}
+
+// ReadProcessed returns an aggregate of the recorded values per processing
+// interval from StartTime to EndTime, going backwards if EndTime is before
+// StartTime. A ProcessingInterval of 0 is a single interval.
+func (h *historian) ReadProcessed(ctx context.Context, nodesToRead []ua.HistoryReadValueID, details ua.ReadProcessedDetails,
+	timestampsToReturn ua.TimestampsToReturn, releaseContinuationPoints bool) ([]ua.HistoryReadResult, ua.StatusCode) {
+	if !specified(details.StartTime) || !specified(details.EndTime) || details.StartTime.Equal(details.EndTime) {
+		return nil, ua.BadInvalidTimestampArgument
+	}
+	if len(details.AggregateType) != len(nodesToRead) {
+		return nil, ua.BadAggregateListMismatch
+	}
+	if details.ProcessingInterval < 0 {
+		return nil, ua.BadInvalidArgument
+	}
+
+	reverse := details.EndTime.Before(details.StartTime)
+	span := details.EndTime.Sub(details.StartTime).Abs()
+	interval := time.Duration(details.ProcessingInterval * float64(time.Millisecond))
+	if interval <= 0 || interval > span {
+		interval = span
+	}
+
+	results := make([]ua.HistoryReadResult, len(nodesToRead))
+	for i, node := range nodesToRead {
+		if releaseContinuationPoints {
+			continue
+		}
+		values, ok := h.values(node.NodeID)
+		if !ok {
+			results[i].StatusCode = ua.BadNodeIDUnknown
+			continue
+		}
+		aggregate, ok := findAggregate(details.AggregateType[i])
+		if !ok {
+			results[i].StatusCode = ua.BadAggregateNotSupported
+			continue
+		}
+		start, _, ok := resume(node.ContinuationPoint, details.StartTime)
+		if !ok {
+			results[i].StatusCode = ua.BadContinuationPointInvalid
+			continue
+		}
+
+		var processed []ua.DataValue
+		var cp ua.ByteString
+		for t := start; before(t, details.EndTime, reverse); {
+			if len(processed) == processedPageSize {
+				cp = continuationPoint(t)
+				break
+			}
+			next := t.Add(interval)
+			lo, hi := t, next
+			if reverse {
+				next = t.Add(-interval)
+				lo, hi = next, t
+			}
+			// The last interval ends with the requested range
+			if reverse && lo.Before(details.EndTime) {
+				lo = details.EndTime
+			} else if !reverse && hi.After(details.EndTime) {
+				hi = details.EndTime
+			}
+
+			value := aggregate(values, lo, hi)
+			if value.SourceTimestamp.IsZero() {
+				value.SourceTimestamp = t
+			}
+			value.ServerTimestamp = time.Now().UTC()
+			processed = append(processed, value)
+			t = next
+		}
+
+		results[i] = ua.HistoryReadResult{
+			StatusCode:        ua.Good,
+			ContinuationPoint: cp,
+			HistoryData:       ua.HistoryData{DataValues: withTimestamps(processed, timestampsToReturn)},
+		}
+	}
+	return results, ua.Good
+}
+
+// aggregate calculates the processed value of the interval [lo, hi) from
+// the recorded values of a variable
+type aggregate func(values []ua.DataValue, lo, hi time.Time) ua.DataValue
+
+// aggregates are the supported AggregateFunctions
+var aggregates = []struct {
+	id        ua.NodeID
+	calculate aggregate
+}{
+	{ua.ObjectIDAggregateFunctionAverage, average},
+	{ua.ObjectIDAggregateFunctionMinimum, extreme(func(a, b float64) bool { return a < b })},
+	{ua.ObjectIDAggregateFunctionMaximum, extreme(func(a, b float64) bool { return a > b })},
+	{ua.ObjectIDAggregateFunctionCount, count},
+	{ua.ObjectIDAggregateFunctionTimeAverage, timeAverage},
+}
+
+// findAggregate returns the supported AggregateFunction id
+func findAggregate(id ua.NodeID) (aggregate, bool) {
+	for _, a := range aggregates {
+		if a.id == id {
+			return a.calculate, true
+		}
+	}
+	return nil, false
+}
+
+// sample is a good numeric value
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// samples returns the good values in [lo, hi). It reports false if a value
+// is not numeric.
+func samples(values []ua.DataValue, lo, hi time.Time) ([]sample, bool) {
+	var result []sample
+	for _, v := range values {
+		if v.SourceTimestamp.Before(lo) || !v.SourceTimestamp.Before(hi) || !v.StatusCode.IsGood() {
+			continue
+		}
+		f, ok := toFloat(v.Value)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, sample{v.SourceTimestamp, f})
+	}
+	return result, true
+}
+
+func average(values []ua.DataValue, lo, hi time.Time) ua.DataValue {
+	good, ok := samples(values, lo, hi)
+	if !ok {
+		return ua.DataValue{StatusCode: ua.BadAggregateInvalidInputs}
+	}
+	if len(good) == 0 {
+		return ua.DataValue{StatusCode: ua.BadNoData}
+	}
+	var sum float64
+	for _, s := range good {
+		sum += s.v
+	}
+	return ua.DataValue{Value: sum / float64(len(good)), StatusCode: aggregateCalculated}
+}
+
+// extreme returns the aggregate of the value that beats all others, with
+// its own timestamp
+func extreme(beats func(a, b float64) bool) aggregate {
+	return func(values []ua.DataValue, lo, hi time.Time) ua.DataValue {
+		good, ok := samples(values, lo, hi)
+		if !ok {
+			return ua.DataValue{StatusCode: ua.BadAggregateInvalidInputs}
+		}
+		if len(good) == 0 {
+			return ua.DataValue{StatusCode: ua.BadNoData}
+		}
+		best := good[0]
+		for _, s := range good[1:] {
+			if beats(s.v, best.v) {
+				best = s
+			}
+		}
+		return ua.DataValue{Value: best.v, StatusCode: aggregateCalculated, SourceTimestamp: best.t}
+	}
+}
+
+// count counts the good values of any type
+func count(values []ua.DataValue, lo, hi time.Time) ua.DataValue {
+	var n int32
+	for _, v := range values {
+		if !v.SourceTimestamp.Before(lo) && v.SourceTimestamp.Before(hi) && v.StatusCode.IsGood() {
+			n++
+		}
+	}
+	return ua.DataValue{Value: n, StatusCode: aggregateCalculated}
+}
+
+// timeAverage is the time-weighted average with linear interpolation
+// between the good values. The interval is cut to the recorded range rather
+// than extrapolated.
+func timeAverage(values []ua.DataValue, lo, hi time.Time) ua.DataValue {
+	all, ok := samples(values, minTime, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if !ok {
+		return ua.DataValue{StatusCode: ua.BadAggregateInvalidInputs}
+	}
+	if len(all) == 0 {
+		return ua.DataValue{StatusCode: ua.BadNoData}
+	}
+	if first := all[0].t; lo.Before(first) {
+		lo = first
+	}
+	if last := all[len(all)-1].t; hi.After(last) {
+		hi = last
+	}
+	if hi.Before(lo) {
+		return ua.DataValue{StatusCode: ua.BadNoData}
+	}
+	if hi.Equal(lo) {
+		return ua.DataValue{Value: interpolate(all, lo), StatusCode: aggregateCalculated}
+	}
+
+	// Integrate the polyline through the bounds and the values between them
+	points := []sample{{lo, interpolate(all, lo)}}
+	for _, s := range all {
+		if s.t.After(lo) && s.t.Before(hi) {
+			points = append(points, s)
+		}
+	}
+	points = append(points, sample{hi, interpolate(all, hi)})
+
+	var area float64
+	for j := 1; j < len(points); j++ {
+		dt := points[j].t.Sub(points[j-1].t).Seconds()
+		area += (points[j].v + points[j-1].v) / 2 * dt
+	}
+	return ua.DataValue{Value: area / hi.Sub(lo).Seconds(), StatusCode: aggregateCalculated}
+}
+
+// interpolate returns the value at t, which lies within the samples
+func interpolate(samples []sample, t time.Time) float64 {
+	for j := 1; j < len(samples); j++ {
+		a, b := samples[j-1], samples[j]
+		if b.t.Before(t) {
+			continue
+		}
+		if !b.t.After(a.t) {
+			return b.v
+		}
+		ratio := t.Sub(a.t).Seconds() / b.t.Sub(a.t).Seconds()
+		return a.v + (b.v-a.v)*ratio
+	}
+	return samples[len(samples)-1].v
+}
+
+// toFloat converts a numeric value
+func toFloat(value ua.Variant) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return math.NaN(), false
+	}
+}
+
+// specified reports whether a client set a time
+func specified(t time.Time) bool {
+	return t.After(minTime)
+}
+
+// before reports whether t comes before limit in reading direction
+func before(t, limit time.Time, reverse bool) bool {
+	if reverse {
+		return t.After(limit)
+	}
+	return t.Before(limit)
+}
+
+// reversed returns values newest first
+func reversed(values []ua.DataValue) []ua.DataValue {
+	result := make([]ua.DataValue, len(values))
+	for i, v := range values {
+		result[len(values)-1-i] = v
+	}
+	return result
+}
+
+// bound returns the bounding value at index, or BadBoundNotFound at t if
+// there is none
+func bound(values []ua.DataValue, index int, t time.Time) ua.DataValue {
+	if index < 0 || index >= len(values) {
+		return ua.DataValue{StatusCode: ua.BadBoundNotFound, SourceTimestamp: t, ServerTimestamp: t}
+	}
+	return values[index]
+}
+
+// continuationPoint encodes the time a read continues at. Reads are
+// stateless, so continuation points need not be released.
+func continuationPoint(t time.Time) ua.ByteString {
+	return ua.ByteString(strconv.FormatInt(t.UnixNano(), 10))
+}
+
+// resume returns the time a read starts at: the time of the continuation
+// point if there is one, otherwise from. It reports false for an invalid
+// continuation point.
+func resume(cp ua.ByteString, from time.Time) (start time.Time, continued, ok bool) {
+	if cp == "" {
+		return from, false, true
+	}
+	nanos, err := strconv.ParseInt(string(cp), 10, 64)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return time.Unix(0, nanos).UTC(), true, true
+}
+
+// withTimestamps strips the timestamps the client did not ask for
+func withTimestamps(values []ua.DataValue, timestampsToReturn ua.TimestampsToReturn) []ua.DataValue {
+	result := make([]ua.DataValue, len(values))
+	for i, v := range values {
+		switch timestampsToReturn {
+		case ua.TimestampsToReturnSource:
+			v.ServerTimestamp = time.Time{}
+		case ua.TimestampsToReturnServer:
+			v.SourceTimestamp = time.Time{}
+		}
+		result[i] = v
+	}
+	return result
+}
+
+// historized returns the access level, Historizing attribute and historian
+// of a variable whose values are recorded
+func (s *Server) historized() (accessLevel byte, historizing bool, historian server.HistoryReadWriter) {
+	if s.historian == nil {
+		return 0, false, nil
+	}
+	return ua.AccessLevelsHistoryRead, true, s.historian
+}
+
+// advertiseHistory publishes the history capabilities of the server
+func (s *Server) advertiseHistory() {
+	nm := s.srv.NamespaceManager()
+	now := time.Now().UTC()
+	for id, value := range map[ua.NodeID]ua.Variant{
+		ua.VariableIDHistoryServerCapabilitiesAccessHistoryDataCapability: true,
+		ua.VariableIDHistoryServerCapabilitiesMaxReturnDataValues:         uint32(0),
+	} {
+		if node, ok := nm.FindVariable(id); ok {
+			node.SetValue(ua.NewDataValue(value, ua.Good, now, 0, now, 0))
+		}
+	}
+
+	if folder, ok := nm.FindObject(ua.ObjectIDHistoryServerCapabilitiesAggregateFunctions); ok {
+		refs := folder.References()
+		for _, a := range aggregates {
+			refs = append(refs, ua.Reference{
+				ReferenceTypeID: ua.ReferenceTypeIDOrganizes,
+				TargetID:        ua.ExpandedNodeID{NodeID: a.id},
+			})
+		}
+		folder.SetReferences(refs)
+	}
+}
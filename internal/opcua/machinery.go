@@ -0,0 +1,249 @@
+package opcua
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+)
+
+// Namespace URIs of the companion specifications the robot is modelled with
+const (
+	diNamespaceURI        = "http://opcfoundation.org/UA/DI/"
+	machineryNamespaceURI = "http://opcfoundation.org/UA/Machinery/"
+)
+
+// machineryNodes are the nodes the robot is placed and described with: the
+// Machines folder, the type and browse names of its nameplate and the type,
+// browse name and states of its MachineryItemState
+type machineryNodes struct {
+	machines           objectRef
+	identification     ua.QualifiedName
+	identificationType ua.NodeID
+	nameplate          func(name string) ua.QualifiedName
+	itemState          ua.QualifiedName
+	itemStateType      ua.NodeID
+	states             map[string]ua.NodeID
+}
+
+// loadCompanionNodeSets adds the configured NodeSet2 files of companion
+// specifications to the address space, in the configured order, and records
+// their namespaces. They are loaded after the simulator namespace, so it
+// keeps index 2.
+func (s *Server) loadCompanionNodeSets() error {
+	s.companions = nil
+	nm := s.srv.NamespaceManager()
+	for _, path := range s.cfg.OPCUACompanionNodeSets {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read companion NodeSet: %w", err)
+		}
+		var set struct {
+			NamespaceUris []string `xml:"NamespaceUris>Uri"`
+		}
+		if err := xml.Unmarshal(data, &set); err != nil {
+			return fmt.Errorf("failed to parse companion NodeSet %s: %w", path, err)
+		}
+		if err := nm.LoadNodeSetFromBuffer(data); err != nil {
+			return fmt.Errorf("failed to load companion NodeSet %s: %w", path, err)
+		}
+		for _, uri := range set.NamespaceUris {
+			ns := uint16(slices.Index(nm.NamespaceUris(), uri))
+			if ns != s.namespace && !slices.Contains(s.companions, ns) {
+				s.companions = append(s.companions, ns)
+			}
+		}
+		log.Info().
+			Str("file", path).
+			Strs("namespaces", set.NamespaceUris).
+			Msg("OPC UA companion NodeSet loaded")
+	}
+	return nil
+}
+
+// machinery returns the standard nodes of OPC UA for Machinery if its
+// NodeSet is loaded, else creates look-alikes in the simulator namespace
+func (s *Server) machinery() (machineryNodes, error) {
+	uris := s.srv.NamespaceManager().NamespaceUris()
+	machinery := slices.Index(uris, machineryNamespaceURI)
+	di := slices.Index(uris, diNamespaceURI)
+	if machinery < 0 || di < 0 {
+		return s.simulatorMachinery(), nil
+	}
+	return s.standardMachinery(uint16(di), uint16(machinery))
+}
+
+// standardMachinery finds the Machines folder, MachineIdentificationType and
+// MachineryItemState_StateMachineType of OPC UA for Machinery by their browse
+// names, so no NodeIds of the companion specification are assumed
+func (s *Server) standardMachinery(di, machinery uint16) (machineryNodes, error) {
+	nm := s.srv.NamespaceManager()
+	m := machineryNodes{
+		identification: ua.QualifiedName{NamespaceIndex: di, Name: "Identification"},
+		itemState:      ua.QualifiedName{NamespaceIndex: machinery, Name: "MachineryItemState"},
+		states:         make(map[string]ua.NodeID),
+	}
+
+	objects, _ := nm.FindNode(ua.ObjectIDObjectsFolder)
+	folder, ok := s.findReference(objects, ua.ReferenceTypeIDOrganizes, ua.QualifiedName{NamespaceIndex: machinery, Name: "Machines"})
+	if !ok {
+		return m, fmt.Errorf("the Machinery NodeSet has no Machines folder")
+	}
+	m.machines = objectRef{id: folder.NodeID(), typeID: ua.ObjectTypeIDFolderType}
+
+	identificationType, ok := s.findSubtype(ua.ObjectTypeIDBaseObjectType, ua.QualifiedName{NamespaceIndex: machinery, Name: "MachineIdentificationType"})
+	if !ok {
+		return m, fmt.Errorf("the Machinery NodeSet has no MachineIdentificationType")
+	}
+	m.identificationType = identificationType.NodeID()
+
+	// Nameplate properties take the browse names the type declares them
+	// with; properties it does not declare are the simulator's
+	m.nameplate = func(name string) ua.QualifiedName {
+		if declaration, ok := s.findDeclaration(m.identificationType, name); ok {
+			return declaration.BrowseName()
+		}
+		return s.browseName(name)
+	}
+
+	itemStateType, ok := s.findSubtype(ua.ObjectTypeIDFiniteStateMachineType, ua.QualifiedName{NamespaceIndex: machinery, Name: "MachineryItemState_StateMachineType"})
+	if !ok {
+		return m, fmt.Errorf("the Machinery NodeSet has no MachineryItemState_StateMachineType")
+	}
+	m.itemStateType = itemStateType.NodeID()
+	for _, state := range machineryItemStates {
+		declaration, ok := s.findDeclaration(m.itemStateType, state.name)
+		if !ok {
+			return m, fmt.Errorf("MachineryItemState_StateMachineType has no state %s", state.name)
+		}
+		m.states[state.name] = declaration.NodeID()
+	}
+	return m, nil
+}
+
+// simulatorMachinery creates the Machines folder and the nameplate and state
+// machine types in the simulator namespace, for a server without the
+// Machinery NodeSet
+func (s *Server) simulatorMachinery() machineryNodes {
+	nm := s.srv.NamespaceManager()
+	m := machineryNodes{
+		identification:     s.browseName("Identification"),
+		identificationType: s.nodeID(typeIdentification),
+		nameplate:          s.browseName,
+		itemState:          s.browseName("MachineryItemState"),
+		itemStateType:      s.nodeID(typeItemState),
+		states:             make(map[string]ua.NodeID),
+	}
+
+	for _, t := range []struct {
+		name, description string
+		base              ua.NodeID
+	}{
+		{typeIdentification, "Nameplate of the machine", ua.ObjectTypeIDBaseObjectType},
+		{typeItemState, "Operating state of the machine", ua.ObjectTypeIDFiniteStateMachineType},
+	} {
+		s.addObjectType(nm, t.name, t.description, t.base)
+	}
+
+	for _, state := range machineryItemStates {
+		id := typeItemState + "." + state.name
+		nm.AddNode(server.NewObjectNode(
+			s.srv,
+			s.nodeID(id),
+			s.browseName(state.name),
+			ua.LocalizedText{Text: state.name},
+			ua.LocalizedText{},
+			nil,
+			[]ua.Reference{
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+					IsInverse:       true,
+					TargetID:        ua.ExpandedNodeID{NodeID: s.nodeID(typeItemState)},
+				},
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+					TargetID:        ua.ExpandedNodeID{NodeID: ua.ObjectTypeIDStateType},
+				},
+			},
+			0,
+		))
+		nm.AddNode(createProperty(s, id, ua.QualifiedName{Name: "StateNumber"}, "Number of the state", ua.DataTypeIDUInt32, state.number))
+		m.states[state.name] = s.nodeID(id)
+	}
+
+	objects := objectRef{id: ua.ObjectIDObjectsFolder, typeID: ua.ObjectTypeIDFolderType}
+	m.machines = s.addObject(objects, ua.ReferenceTypeIDOrganizes, "Machines", "Machines", "Machines", "Machines of the simulator", ua.ObjectTypeIDFolderType)
+	return m
+}
+
+// findReference returns the target of a forward reference of refType from
+// node with the given browse name
+func (s *Server) findReference(node server.Node, refType ua.NodeID, browseName ua.QualifiedName) (server.Node, bool) {
+	nm := s.srv.NamespaceManager()
+	for _, r := range node.References() {
+		if r.IsInverse || r.ReferenceTypeID != refType {
+			continue
+		}
+		if target, ok := nm.FindNode(ua.ToNodeID(r.TargetID, nm.NamespaceUris())); ok && target.BrowseName() == browseName {
+			return target, true
+		}
+	}
+	return nil, false
+}
+
+// findSubtype returns the subtype of base with the given browse name
+func (s *Server) findSubtype(base ua.NodeID, browseName ua.QualifiedName) (server.Node, bool) {
+	nm := s.srv.NamespaceManager()
+	visited := map[ua.NodeID]bool{}
+	queue := []ua.NodeID{base}
+	for len(queue) > 0 {
+		node, ok := nm.FindNode(queue[0])
+		queue = queue[1:]
+		if !ok || visited[node.NodeID()] {
+			continue
+		}
+		visited[node.NodeID()] = true
+		if node.BrowseName() == browseName {
+			return node, true
+		}
+		for _, r := range node.References() {
+			if !r.IsInverse && r.ReferenceTypeID == ua.ReferenceTypeIDHasSubtype {
+				queue = append(queue, ua.ToNodeID(r.TargetID, nm.NamespaceUris()))
+			}
+		}
+	}
+	return nil, false
+}
+
+// findDeclaration returns the property or component called name that the
+// type typeID, its supertypes or their interfaces declare
+func (s *Server) findDeclaration(typeID ua.NodeID, name string) (server.Node, bool) {
+	nm := s.srv.NamespaceManager()
+	visited := map[ua.NodeID]bool{}
+	queue := []ua.NodeID{typeID}
+	for len(queue) > 0 {
+		node, ok := nm.FindNode(queue[0])
+		queue = queue[1:]
+		if !ok || visited[node.NodeID()] {
+			continue
+		}
+		visited[node.NodeID()] = true
+		for _, r := range node.References() {
+			target := ua.ToNodeID(r.TargetID, nm.NamespaceUris())
+			switch {
+			case r.IsInverse && r.ReferenceTypeID == ua.ReferenceTypeIDHasSubtype,
+				!r.IsInverse && r.ReferenceTypeID == ua.ReferenceTypeIDHasInterface:
+				queue = append(queue, target)
+			case !r.IsInverse && (r.ReferenceTypeID == ua.ReferenceTypeIDHasProperty || r.ReferenceTypeID == ua.ReferenceTypeIDHasComponent):
+				if child, ok := nm.FindNode(target); ok && child.BrowseName().Name == name {
+					return child, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
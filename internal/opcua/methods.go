@@ -0,0 +1,365 @@
+package opcua
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// commandTimeout bounds how long a method call or write waits for the
+// simulation loop
+const commandTimeout = 5 * time.Second
+
+// Command is an OPC UA method call or write queued for the simulation loop.
+// Commands are executed by the loop so they never race with a tick.
+type Command struct {
+	run  func(now time.Time)
+	done chan struct{}
+}
+
+// Execute runs the command and releases the waiting call
+func (c Command) Execute(now time.Time) {
+	c.run(now)
+	close(c.done)
+}
+
+// Commands returns the queue of method calls and writes for the simulation loop
+func (s *Server) Commands() <-chan Command {
+	return s.commands
+}
+
+// method is a callable method of the robot
+type method struct {
+	name        string
+	description string
+	inputs      []ua.Argument
+	outputs     []ua.Argument
+	call        func(now time.Time, args []ua.Variant) ua.CallMethodResult
+}
+
+func argument(name string, dataType ua.NodeID, description string) ua.Argument {
+	return ua.Argument{
+		Name:            name,
+		DataType:        dataType,
+		ValueRank:       ua.ValueRankScalar,
+		ArrayDimensions: []uint32{},
+		Description:     ua.LocalizedText{Text: description},
+	}
+}
+
+// createMethods adds the methods for commanding the robot
+func (s *Server) createMethods(robot objectRef) {
+	for _, m := range []method{
+		{
+			name:        "StartOrder",
+			description: "Set up a queued production order ahead of the queue",
+			inputs:      []ua.Argument{argument("OrderId", ua.DataTypeIDString, "ID of the queued order")},
+			outputs: []ua.Argument{
+				argument("PartNumber", ua.DataTypeIDString, "Part number of the order"),
+				argument("Quantity", ua.DataTypeIDInt32, "Ordered quantity"),
+			},
+			call: s.startOrder,
+		},
+		{
+			name:        "AbortOrder",
+			description: "Cancel the current production order; a part being welded is scrapped",
+			outputs: []ua.Argument{
+				argument("OrderId", ua.DataTypeIDString, "ID of the cancelled order"),
+				argument("QuantityCompleted", ua.DataTypeIDInt32, "Good parts of the order"),
+				argument("QuantityScrap", ua.DataTypeIDInt32, "Scrap parts of the order"),
+			},
+			call: s.abortOrder,
+		},
+		{
+			name:        "AcknowledgeError",
+			description: "Confirm the current error has been dealt with, ending the repair or recovery step",
+			outputs:     []ua.Argument{argument("ErrorCode", ua.DataTypeIDString, "Code of the acknowledged error")},
+			call:        s.acknowledgeError,
+		},
+		{
+			name:        "ResetCounters",
+			description: "Reset the good and scrap part counters and the arc time",
+			outputs: []ua.Argument{
+				argument("GoodParts", ua.DataTypeIDInt32, "Good parts before the reset"),
+				argument("ScrapParts", ua.DataTypeIDInt32, "Scrap parts before the reset"),
+			},
+			call: s.resetCounters,
+		},
+		{
+			name:        "EnterMaintenance",
+			description: "Stop the robot for maintenance, shown as PlannedStop",
+			inputs:      []ua.Argument{argument("Duration", ua.DataTypeIDDuration, "Maintenance duration in milliseconds")},
+			outputs:     []ua.Argument{argument("EndTime", ua.DataTypeIDUtcTime, "End of the maintenance")},
+			call:        s.enterMaintenance,
+		},
+		{
+			name:        "SetRecipe",
+			description: "Apply a weld recipe to the setpoints until the next setup",
+			inputs:      []ua.Argument{argument("RecipeName", ua.DataTypeIDString, "Name of the weld recipe")},
+			outputs:     []ua.Argument{argument("TransferMode", ua.DataTypeIDString, "Transfer mode of the recipe")},
+			call:        s.setRecipe,
+		},
+	} {
+		s.addMethod(robot, m)
+	}
+}
+
+func (s *Server) startOrder(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	orderID := args[0].(string)
+
+	state := s.stateMachine.GetState()
+	known := state.CurrentOrder != nil && state.CurrentOrder.OrderID == orderID
+	for _, order := range state.OrderQueue {
+		known = known || order.OrderID == orderID
+	}
+	if !known {
+		return invalidArgument(0, fmt.Errorf("order %q is not queued", orderID))
+	}
+
+	if err := s.stateMachine.StartOrder(orderID, now); err != nil {
+		return invalidState(err)
+	}
+	order := s.stateMachine.GetCurrentOrder()
+	log.Info().Str("orderId", order.OrderID).Msg("Order started via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{order.PartNumber, int32(order.Quantity)}}
+}
+
+func (s *Server) abortOrder(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	order, err := s.stateMachine.AbortOrder(now)
+	if err != nil {
+		return invalidState(err)
+	}
+	log.Info().Str("orderId", order.OrderID).Msg("Order aborted via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{
+		order.OrderID, int32(order.QuantityCompleted), int32(order.QuantityScrap),
+	}}
+}
+
+func (s *Server) acknowledgeError(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	info, err := s.stateMachine.AcknowledgeError(now)
+	if err != nil {
+		return invalidState(err)
+	}
+	log.Info().Str("code", string(info.Code)).Msg("Error acknowledged via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{string(info.Code)}}
+}
+
+func (s *Server) resetCounters(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	goodParts, scrapParts, _ := s.stateMachine.GetCounters()
+	s.stateMachine.ResetCounters()
+	log.Info().Msg("Counters reset via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{int32(goodParts), int32(scrapParts)}}
+}
+
+func (s *Server) enterMaintenance(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	duration := time.Duration(args[0].(float64) * float64(time.Millisecond))
+	if duration <= 0 {
+		return ua.CallMethodResult{StatusCode: ua.BadOutOfRange, InputArgumentResults: []ua.StatusCode{ua.BadOutOfRange}}
+	}
+	if s.stateMachine.State() == simulator.StateUnplannedStop {
+		return invalidState(fmt.Errorf("acknowledge the current error before maintenance"))
+	}
+
+	if err := s.stateMachine.ForceState(simulator.StatePlannedStop, duration, now); err != nil {
+		return invalidState(err)
+	}
+	log.Info().Dur("duration", duration).Msg("Maintenance started via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{now.Add(duration).UTC()}}
+}
+
+func (s *Server) setRecipe(now time.Time, args []ua.Variant) ua.CallMethodResult {
+	name := args[0].(string)
+	recipe, ok := s.recipes(name)
+	if !ok {
+		return invalidArgument(0, fmt.Errorf("unknown recipe %q", name))
+	}
+
+	s.generator.ApplyRecipe(recipe)
+	log.Info().Str("recipe", recipe.Name).Msg("Weld recipe applied via OPC UA")
+	return ua.CallMethodResult{OutputArguments: []ua.Variant{recipe.TransferMode.String()}}
+}
+
+// invalidArgument rejects the input argument at index
+func invalidArgument(index int, err error) ua.CallMethodResult {
+	log.Debug().Err(err).Msg("OPC UA method call rejected")
+	results := make([]ua.StatusCode, index+1)
+	results[index] = ua.BadInvalidArgument
+	return ua.CallMethodResult{StatusCode: ua.BadInvalidArgument, InputArgumentResults: results}
+}
+
+// invalidState rejects a call that is not possible in the current state
+func invalidState(err error) ua.CallMethodResult {
+	log.Debug().Err(err).Msg("OPC UA method call rejected")
+	return ua.CallMethodResult{StatusCode: ua.BadInvalidState}
+}
+
+// addMethod creates a method below parent whose calls run in the simulation
+// loop, and declares it on the parent's ObjectType
+func (s *Server) addMethod(parent objectRef, m method) {
+	node := s.createMethod(parent.id, "Robot."+m.name, m, nil)
+	node.SetCallMethodHandler(func(session *server.Session, req ua.CallMethodRequest) ua.CallMethodResult {
+		if result, ok := checkArguments(m.inputs, req.InputArguments); !ok {
+			return result
+		}
+		var result ua.CallMethodResult
+		if !s.execute(func(now time.Time) {
+			result = m.call(now, req.InputArguments)
+		}) {
+			return ua.CallMethodResult{StatusCode: ua.BadTimeout}
+		}
+		return result
+	})
+
+	if typeID, ok := parent.typeID.(ua.NodeIDString); ok && typeID.NamespaceIndex == s.namespace {
+		s.createMethod(typeID, typeID.ID+"."+m.name, m, ua.ObjectIDModellingRuleMandatory)
+	}
+}
+
+// createMethod adds a method node and its argument properties. Method
+// declarations of an ObjectType get a modelling rule.
+func (s *Server) createMethod(parentID ua.NodeID, id string, m method, modellingRule ua.NodeID) *server.MethodNode {
+	nm := s.srv.NamespaceManager()
+	refs := func(parent ua.NodeID, refType ua.NodeID) []ua.Reference {
+		r := []ua.Reference{
+			{
+				ReferenceTypeID: refType,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: parent},
+			},
+		}
+		if modellingRule != nil {
+			r = append(r, ua.Reference{
+				ReferenceTypeID: ua.ReferenceTypeIDHasModellingRule,
+				TargetID:        ua.ExpandedNodeID{NodeID: modellingRule},
+			})
+		}
+		return r
+	}
+
+	node := server.NewMethodNode(
+		s.srv,
+		s.nodeID(id),
+		s.browseName(m.name),
+		ua.LocalizedText{Text: m.name},
+		ua.LocalizedText{Text: m.description},
+		nil,
+		refs(parentID, ua.ReferenceTypeIDHasComponent),
+		true,
+	)
+	nm.AddNode(node)
+
+	for _, args := range []struct {
+		name  string
+		value []ua.Argument
+	}{
+		{"InputArguments", m.inputs},
+		{"OutputArguments", m.outputs},
+	} {
+		if len(args.value) == 0 {
+			continue
+		}
+		value := make([]ua.ExtensionObject, len(args.value))
+		for i, arg := range args.value {
+			value[i] = arg
+		}
+		nm.AddNode(server.NewVariableNode(
+			s.srv,
+			s.nodeID(id+"."+args.name),
+			ua.QualifiedName{Name: args.name},
+			ua.LocalizedText{Text: args.name},
+			ua.LocalizedText{},
+			nil,
+			append(refs(node.NodeID(), ua.ReferenceTypeIDHasProperty), ua.Reference{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: ua.VariableTypeIDPropertyType},
+			}),
+			ua.NewDataValue(value, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+			ua.DataTypeIDArgument,
+			ua.ValueRankOneDimension,
+			[]uint32{uint32(len(value))},
+			ua.AccessLevelsCurrentRead,
+			0,
+			false,
+			nil,
+		))
+	}
+	return node
+}
+
+// clientPermissions returns the default role permissions with permission
+// added for anonymous and authenticated clients, which by default may only
+// browse and read
+func clientPermissions(permission ua.PermissionType) []ua.RolePermissionType {
+	permissions := make([]ua.RolePermissionType, len(server.DefaultRolePermissions))
+	copy(permissions, server.DefaultRolePermissions)
+	for i, rp := range permissions {
+		switch rp.RoleID {
+		case ua.ObjectIDWellKnownRoleAnonymous, ua.ObjectIDWellKnownRoleAuthenticatedUser:
+			permissions[i].Permissions |= permission
+		}
+	}
+	return permissions
+}
+
+// execute queues run for the simulation loop and waits until it has run. It
+// reports false if the loop did not accept run in time.
+func (s *Server) execute(run func(now time.Time)) bool {
+	cmd := Command{run: run, done: make(chan struct{})}
+	select {
+	case s.commands <- cmd:
+	case <-time.After(commandTimeout):
+		return false
+	}
+
+	// Once queued the command always completes within one tick
+	<-cmd.done
+	return true
+}
+
+// checkArguments validates the number and types of the input arguments
+func checkArguments(inputs []ua.Argument, values []ua.Variant) (ua.CallMethodResult, bool) {
+	if len(values) < len(inputs) {
+		return ua.CallMethodResult{StatusCode: ua.BadArgumentsMissing}, false
+	}
+	if len(values) > len(inputs) {
+		return ua.CallMethodResult{StatusCode: ua.BadTooManyArguments}, false
+	}
+
+	results := make([]ua.StatusCode, len(inputs))
+	valid := true
+	for i, input := range inputs {
+		if !matchesType(input.DataType, values[i]) {
+			results[i] = ua.BadTypeMismatch
+			valid = false
+		}
+	}
+	if !valid {
+		return ua.CallMethodResult{StatusCode: ua.BadInvalidArgument, InputArgumentResults: results}, false
+	}
+	return ua.CallMethodResult{}, true
+}
+
+// matchesType reports whether value has the Go type of dataType
+func matchesType(dataType ua.NodeID, value ua.Variant) bool {
+	switch value.(type) {
+	case string:
+		return dataType == ua.DataTypeIDString
+	case float64:
+		return dataType == ua.DataTypeIDDouble || dataType == ua.DataTypeIDDuration
+	case int32:
+		return dataType == ua.DataTypeIDInt32
+	case uint32:
+		return dataType == ua.DataTypeIDUInt32
+	case ua.ByteString:
+		return dataType == ua.DataTypeIDByteString
+	case ua.LocalizedText:
+		return dataType == ua.DataTypeIDLocalizedText
+	default:
+		return false
+	}
+}
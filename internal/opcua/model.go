@@ -0,0 +1,235 @@
+package opcua
+
+import (
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// NamespaceURI identifies the simulator's information model. Clients should
+// look up its index in the server's namespace array instead of assuming 2.
+const NamespaceURI = "http://shopfloor-simulator/UA/WeldingRobot/"
+
+// ObjectTypes of the information model in the simulator namespace. The
+// nameplate and state machine types are only defined if the OPC UA for
+// Machinery NodeSet is not loaded; otherwise the robot uses the standard
+// MachineIdentificationType and MachineryItemState_StateMachineType.
+const (
+	typeWeldingRobot      = "WeldingRobotType"
+	typeWeldingController = "WeldingControllerType"
+	typeWeldingProcess    = "WeldingProcessType"
+	typeJobList           = "WeldingJobListType"
+	typeJob               = "WeldingJobType"
+	typeProductionOrder   = "ProductionOrderType"
+	typeOrderQueue        = "OrderQueueType"
+	typeShift             = "ShiftType"
+	typeIdentification    = "WeldingRobotIdentificationType"
+	typeItemState         = "WeldingRobotStateMachineType"
+)
+
+// Identification of the simulated machine
+const (
+	manufacturer = "Shopfloor Simulator"
+	model        = "Welding Robot Simulator"
+	deviceClass  = "Welding robot"
+)
+
+// machineryItemStates are the states of the MachineryItemState state
+// machine of OPC UA for Machinery
+var machineryItemStates = []struct {
+	name   string
+	number uint32
+}{
+	{"NotAvailable", 0},
+	{"OutOfService", 1},
+	{"NotExecuting", 2},
+	{"Executing", 3},
+}
+
+// machineryItemState maps a machine state to a MachineryItemState: the robot
+// is executing while welding, out of service after an error and not executing
+// otherwise
+func machineryItemState(state simulator.MachineState) string {
+	switch state {
+	case simulator.StateRunning:
+		return "Executing"
+	case simulator.StateUnplannedStop:
+		return "OutOfService"
+	default:
+		return "NotExecuting"
+	}
+}
+
+// objectRef is an object of the address space and the ObjectType it
+// instantiates
+type objectRef struct {
+	id     ua.NodeID
+	typeID ua.NodeID
+}
+
+// nodeID returns the NodeId of id in the simulator namespace
+func (s *Server) nodeID(id string) ua.NodeIDString {
+	return ua.NodeIDString{NamespaceIndex: s.namespace, ID: id}
+}
+
+// browseName returns name qualified with the simulator namespace
+func (s *Server) browseName(name string) ua.QualifiedName {
+	return ua.QualifiedName{NamespaceIndex: s.namespace, Name: name}
+}
+
+// createTypes registers the ObjectTypes of the information model
+func (s *Server) createTypes(nm *server.NamespaceManager) {
+	for _, t := range []struct {
+		name, description string
+		base              ua.NodeID
+	}{
+		{typeWeldingRobot, "Welding robot with its controller, welding process and production jobs", ua.ObjectTypeIDBaseObjectType},
+		{typeWeldingController, "Robot controller with the machine state and active errors", ua.ObjectTypeIDBaseObjectType},
+		{typeWeldingProcess, "Arc welding process: welding parameters and torch thermals", ua.ObjectTypeIDBaseObjectType},
+		{typeJobList, "Production jobs of the machine", ua.ObjectTypeIDBaseObjectType},
+		{typeJob, "Production order being processed", ua.ObjectTypeIDBaseObjectType},
+		{typeProductionOrder, "Production order with its quantities, due date and customer", ua.ObjectTypeIDBaseObjectType},
+		{typeOrderQueue, "Production orders waiting to be processed", ua.ObjectTypeIDBaseObjectType},
+		{typeShift, "Work shift with its planned breaks", ua.ObjectTypeIDBaseObjectType},
+	} {
+		s.addObjectType(nm, t.name, t.description, t.base)
+	}
+}
+
+// addObjectType registers the ObjectType name as a subtype of base
+func (s *Server) addObjectType(nm *server.NamespaceManager, name, description string, base ua.NodeID) {
+	nm.AddNode(server.NewObjectTypeNode(
+		s.srv,
+		s.nodeID(name),
+		s.browseName(name),
+		ua.LocalizedText{Text: name},
+		ua.LocalizedText{Text: description},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasSubtype,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: base},
+			},
+		},
+		false,
+	))
+}
+
+// addObject creates an object of typeID below parent
+func (s *Server) addObject(parent objectRef, refType ua.NodeID, id, browseName, displayName, description string, typeID ua.NodeID) objectRef {
+	return s.addNotifier(parent, refType, id, browseName, displayName, description, typeID, 0)
+}
+
+// addNotifier creates an object of typeID below parent with the given
+// EventNotifier. Objects that emit events are notifiers of the Server object,
+// so subscriptions to the Server receive their events.
+func (s *Server) addNotifier(parent objectRef, refType ua.NodeID, id, browseName, displayName, description string, typeID ua.NodeID, eventNotifier byte) objectRef {
+	return s.addQualified(parent, refType, id, s.browseName(browseName), displayName, description, typeID, eventNotifier)
+}
+
+// addQualified creates an object of typeID below parent whose browse name
+// may belong to another namespace, such as that of a companion specification
+func (s *Server) addQualified(parent objectRef, refType ua.NodeID, id string, browseName ua.QualifiedName, displayName, description string, typeID ua.NodeID, eventNotifier byte) objectRef {
+	refs := []ua.Reference{
+		{
+			ReferenceTypeID: refType,
+			IsInverse:       true,
+			TargetID:        ua.ExpandedNodeID{NodeID: parent.id},
+		},
+		{
+			ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+			TargetID:        ua.ExpandedNodeID{NodeID: typeID},
+		},
+	}
+	if eventNotifier&ua.EventNotifierSubscribeToEvents != 0 {
+		refs = append(refs, ua.Reference{
+			ReferenceTypeID: ua.ReferenceTypeIDHasNotifier,
+			IsInverse:       true,
+			TargetID:        ua.ExpandedNodeID{NodeID: ua.ObjectIDServer},
+		})
+	}
+
+	node := server.NewObjectNode(
+		s.srv,
+		s.nodeID(id),
+		browseName,
+		ua.LocalizedText{Text: displayName},
+		ua.LocalizedText{Text: description},
+		nil,
+		refs,
+		eventNotifier,
+	)
+	s.srv.NamespaceManager().AddNode(node)
+	s.declare(parent, node, ua.ObjectIDModellingRuleMandatory)
+	return objectRef{id: node.NodeID(), typeID: typeID}
+}
+
+// declare adds the instance declaration of a child of parent to the
+// parent's ObjectType, so the type describes the structure of its instances
+func (s *Server) declare(parent objectRef, child server.Node, modellingRule ua.NodeID) {
+	typeID, ok := parent.typeID.(ua.NodeIDString)
+	if !ok || typeID.NamespaceIndex != s.namespace {
+		return
+	}
+	nm := s.srv.NamespaceManager()
+	id := s.nodeID(typeID.ID + "." + child.BrowseName().Name)
+	if _, ok := nm.FindNode(id); ok {
+		return
+	}
+
+	// Copy the reference to the parent and the type definition of the child
+	refs := []ua.Reference{
+		{
+			ReferenceTypeID: ua.ReferenceTypeIDHasModellingRule,
+			TargetID:        ua.ExpandedNodeID{NodeID: modellingRule},
+		},
+	}
+	for _, ref := range child.References() {
+		switch {
+		case ref.IsInverse && ref.TargetID.NodeID == parent.id:
+			refs = append(refs, ua.Reference{
+				ReferenceTypeID: ref.ReferenceTypeID,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: typeID},
+			})
+		case ref.ReferenceTypeID == ua.ReferenceTypeIDHasTypeDefinition:
+			refs = append(refs, ref)
+		}
+	}
+
+	switch n := child.(type) {
+	case *server.VariableNode:
+		nm.AddNode(server.NewVariableNode(
+			s.srv,
+			id,
+			n.BrowseName(),
+			n.DisplayName(),
+			n.Description(),
+			nil,
+			refs,
+			ua.NewDataValue(n.Value().Value, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+			n.DataType(),
+			n.ValueRank(),
+			n.ArrayDimensions(),
+			ua.AccessLevelsCurrentRead,
+			0,
+			false,
+			nil,
+		))
+	case *server.ObjectNode:
+		nm.AddNode(server.NewObjectNode(
+			s.srv,
+			id,
+			n.BrowseName(),
+			n.DisplayName(),
+			n.Description(),
+			nil,
+			refs,
+			0,
+		))
+	}
+}
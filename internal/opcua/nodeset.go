@@ -0,0 +1,243 @@
+package opcua
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+)
+
+// binding is a variable of the custom NodeSet that mirrors a simulator
+// variable
+type binding struct {
+	signal string
+	node   *server.VariableNode
+}
+
+// loadNodeSet adds the nodes of the configured NodeSet2 file to the address
+// space and binds its variables to the simulator variables. The nodes may
+// reference the simulator's nodes, e.g. to organize them below Robot.
+func (s *Server) loadNodeSet() error {
+	s.bindings = nil
+	path := s.cfg.OPCUANodeSetFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read NodeSet: %w", err)
+	}
+	var set struct {
+		NamespaceUris []string `xml:"NamespaceUris>Uri"`
+	}
+	if err := xml.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse NodeSet %s: %w", path, err)
+	}
+	nm := s.srv.NamespaceManager()
+	if err := nm.LoadNodeSetFromBuffer(data); err != nil {
+		return fmt.Errorf("failed to load NodeSet %s: %w", path, err)
+	}
+
+	for _, b := range s.cfg.OPCUANodeSetBindings {
+		signal, id, _ := strings.Cut(b, "=")
+		signal, id = strings.TrimSpace(signal), strings.TrimSpace(id)
+		source, ok := s.varNodes[signal]
+		if !ok {
+			return fmt.Errorf("binding %s: %q is not a simulator variable", b, signal)
+		}
+		nodeID, err := s.nodeSetNodeID(id, set.NamespaceUris)
+		if err != nil {
+			return fmt.Errorf("binding %s: %w", b, err)
+		}
+		node, ok := nm.FindVariable(nodeID)
+		if !ok {
+			return fmt.Errorf("binding %s: %s is not a variable of the NodeSet", b, id)
+		}
+		if _, ok := convertValue(source.Value().Value, node.DataType()); !ok {
+			return fmt.Errorf("binding %s: %s cannot be converted to the data type %s of %s",
+				b, signal, node.DataType(), id)
+		}
+		s.bindings = append(s.bindings, binding{signal: signal, node: node})
+	}
+
+	log.Info().
+		Str("file", path).
+		Strs("namespaces", set.NamespaceUris).
+		Int("bindings", len(s.bindings)).
+		Msg("OPC UA NodeSet loaded")
+	return nil
+}
+
+// nodeSetNodeID parses a NodeId as written in the NodeSet file. Its
+// namespace index refers to the NamespaceUris of the file, which the server
+// numbers on its own.
+func (s *Server) nodeSetNodeID(id string, namespaceURIs []string) (ua.NodeID, error) {
+	nodeID := ua.ParseNodeID(id)
+	if nodeID == nil {
+		return nil, fmt.Errorf("invalid NodeId %q", id)
+	}
+	ns := namespaceIndex(nodeID)
+	if ns == 0 {
+		return nodeID, nil
+	}
+	if int(ns) > len(namespaceURIs) {
+		return nil, fmt.Errorf("NodeId %s: the NodeSet has no namespace %d", id, ns)
+	}
+	index := slices.Index(s.srv.NamespaceManager().NamespaceUris(), namespaceURIs[ns-1])
+	return withNamespace(nodeID, uint16(index)), nil
+}
+
+// namespaceIndex returns the namespace index of id
+func namespaceIndex(id ua.NodeID) uint16 {
+	switch n := id.(type) {
+	case ua.NodeIDNumeric:
+		return n.NamespaceIndex
+	case ua.NodeIDString:
+		return n.NamespaceIndex
+	case ua.NodeIDGUID:
+		return n.NamespaceIndex
+	case ua.NodeIDOpaque:
+		return n.NamespaceIndex
+	}
+	return 0
+}
+
+// withNamespace returns id in the namespace ns
+func withNamespace(id ua.NodeID, ns uint16) ua.NodeID {
+	switch n := id.(type) {
+	case ua.NodeIDNumeric:
+		n.NamespaceIndex = ns
+		return n
+	case ua.NodeIDString:
+		n.NamespaceIndex = ns
+		return n
+	case ua.NodeIDGUID:
+		n.NamespaceIndex = ns
+		return n
+	case ua.NodeIDOpaque:
+		n.NamespaceIndex = ns
+		return n
+	}
+	return id
+}
+
+// updateBindings copies value, status and timestamps of the bound simulator
+// variables to the NodeSet variables
+func (s *Server) updateBindings() {
+	for _, b := range s.bindings {
+		source, ok := s.varNodes[b.signal]
+		if !ok {
+			continue
+		}
+		dv := source.Value()
+		value, ok := convertValue(dv.Value, b.node.DataType())
+		if !ok {
+			continue
+		}
+		b.node.SetValue(ua.NewDataValue(value, dv.StatusCode, dv.SourceTimestamp, 0, dv.ServerTimestamp, 0))
+	}
+}
+
+// convertValue converts a simulator value to dataType. Numbers convert to
+// any numeric type, everything converts to String.
+func convertValue(value interface{}, dataType ua.NodeID) (interface{}, bool) {
+	switch dataType {
+	case ua.DataTypeIDBaseDataType:
+		return value, true
+	case ua.DataTypeIDString:
+		switch v := value.(type) {
+		case ua.LocalizedText:
+			return v.Text, true
+		case time.Time:
+			return v.UTC().Format(time.RFC3339Nano), true
+		default:
+			return fmt.Sprint(v), true
+		}
+	case ua.DataTypeIDLocalizedText:
+		switch v := value.(type) {
+		case ua.LocalizedText:
+			return v, true
+		case string:
+			return ua.LocalizedText{Text: v}, true
+		}
+		return nil, false
+	case ua.DataTypeIDDateTime:
+		v, ok := value.(time.Time)
+		return v, ok
+	case ua.DataTypeIDNodeID:
+		v, ok := value.(ua.NodeID)
+		return v, ok
+	}
+
+	// Waveforms convert element by element
+	if values, ok := value.([]float64); ok {
+		switch dataType {
+		case ua.DataTypeIDDouble:
+			return values, true
+		case ua.DataTypeIDFloat:
+			converted := make([]float32, len(values))
+			for i, v := range values {
+				converted[i] = float32(v)
+			}
+			return converted, true
+		}
+		return nil, false
+	}
+
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int32:
+		f = float64(v)
+	case uint32:
+		f = float64(v)
+	case bool:
+		if v {
+			f = 1
+		}
+	default:
+		return nil, false
+	}
+	switch dataType {
+	case ua.DataTypeIDBoolean:
+		return f != 0, true
+	case ua.DataTypeIDDouble:
+		return f, true
+	case ua.DataTypeIDFloat:
+		return float32(f), true
+	}
+	// Integers are rounded and clamped to their range
+	f = math.Round(f)
+	switch dataType {
+	case ua.DataTypeIDSByte:
+		return int8(clamp(f, math.MinInt8, math.MaxInt8)), true
+	case ua.DataTypeIDByte:
+		return uint8(clamp(f, 0, math.MaxUint8)), true
+	case ua.DataTypeIDInt16:
+		return int16(clamp(f, math.MinInt16, math.MaxInt16)), true
+	case ua.DataTypeIDUInt16:
+		return uint16(clamp(f, 0, math.MaxUint16)), true
+	case ua.DataTypeIDInt32:
+		return int32(clamp(f, math.MinInt32, math.MaxInt32)), true
+	case ua.DataTypeIDUInt32:
+		return uint32(clamp(f, 0, math.MaxUint32)), true
+	case ua.DataTypeIDInt64:
+		return int64(clamp(f, math.MinInt64, math.MaxInt64)), true
+	case ua.DataTypeIDUInt64:
+		return uint64(clamp(f, 0, math.MaxUint64)), true
+	}
+	return nil, false
+}
+
+func clamp(f, low, high float64) float64 {
+	return math.Max(low, math.Min(high, f))
+}
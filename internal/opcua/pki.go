@@ -0,0 +1,325 @@
+package opcua
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Longest wait between two checks of the server certificate
+const certificateCheckInterval = time.Hour
+
+// applicationURI identifies the server; it is tied to the simulator name and
+// must match the URI in the server certificate
+func (s *Server) applicationURI() string {
+	return "urn:shopfloor-simulator:" + url.PathEscape(s.cfg.SimulatorName)
+}
+
+// certificatePaths returns the server certificate and key files and whether
+// the simulator manages them. Provided certificates are never overwritten.
+func (s *Server) certificatePaths() (certPath, keyPath string, managed bool) {
+	if s.cfg.OPCUACertFile != "" {
+		return s.cfg.OPCUACertFile, s.cfg.OPCUAKeyFile, false
+	}
+	return filepath.Join(s.cfg.OPCUAPKIDir, "server.crt"), filepath.Join(s.cfg.OPCUAPKIDir, "server.key"), true
+}
+
+// CertificateExpiry returns when the server certificate expires, zero if the
+// server has no certificate
+func (s *Server) CertificateExpiry() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return time.Time{}
+	}
+	return s.cert.NotAfter
+}
+
+func (s *Server) setCertificate(cert *x509.Certificate) {
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+}
+
+// ensureCertificate loads the server certificate. A managed certificate is
+// created when it is missing, no longer matches the configured names or is
+// due for renewal.
+func (s *Server) ensureCertificate(now time.Time) error {
+	certPath, keyPath, managed := s.certificatePaths()
+	cert, err := loadCertificate(certPath, keyPath)
+
+	if !managed {
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(cert.URIs, func(u *url.URL) bool { return u.String() == s.applicationURI() }) {
+			log.Warn().
+				Str("certFile", certPath).
+				Str("applicationUri", s.applicationURI()).
+				Msg("Server certificate does not contain the application URI, clients may reject it")
+		}
+		if now.After(cert.NotAfter.Add(-s.cfg.OPCUACertRenewBefore)) {
+			log.Warn().
+				Str("certFile", certPath).
+				Time("expiry", cert.NotAfter).
+				Msg("Provided server certificate expires soon, replace it")
+		}
+		log.Info().Str("certFile", certPath).Time("expiry", cert.NotAfter).Msg("Using provided server certificate")
+		s.setCertificate(cert)
+		return nil
+	}
+
+	var reason string
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		reason = "missing"
+	case err != nil:
+		reason = err.Error()
+	case !s.certificateMatches(cert):
+		reason = "names changed"
+	case now.After(cert.NotAfter.Add(-s.cfg.OPCUACertRenewBefore)):
+		reason = "expires soon"
+	}
+	if reason == "" {
+		log.Info().Str("certFile", certPath).Time("expiry", cert.NotAfter).Msg("Using existing PKI certificates")
+		s.setCertificate(cert)
+		return nil
+	}
+
+	log.Info().Str("reason", reason).Msg("Generating self-signed certificate for OPC UA server")
+	if err := os.MkdirAll(s.cfg.OPCUAPKIDir, 0755); err != nil {
+		return fmt.Errorf("failed to create PKI directory: %w", err)
+	}
+	cert, err = s.createSelfSignedCert(certPath, keyPath, now)
+	if err != nil {
+		return err
+	}
+	s.setCertificate(cert)
+	return nil
+}
+
+// loadCertificate reads a PEM certificate and its RSA private key
+func loadCertificate(certPath, keyPath string) (*x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pair.PrivateKey.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("%s: OPC UA needs an RSA private key", keyPath)
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
+
+// hostnames returns the DNS names of a managed certificate: the configured
+// names and the hostname of the machine
+func (s *Server) hostnames() []string {
+	names := slices.Clone(s.cfg.OPCUACertHostnames)
+	if hostname, err := os.Hostname(); err == nil && !slices.Contains(names, hostname) {
+		names = append(names, hostname)
+	}
+	return names
+}
+
+// certificateMatches reports whether cert carries the application URI,
+// hostnames and IP addresses of the configuration
+func (s *Server) certificateMatches(cert *x509.Certificate) bool {
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != s.applicationURI() {
+		return false
+	}
+	if !sameElements(cert.DNSNames, s.hostnames()) {
+		return false
+	}
+	ips := make([]string, len(cert.IPAddresses))
+	for i, ip := range cert.IPAddresses {
+		ips[i] = ip.String()
+	}
+	wanted := make([]string, len(s.cfg.OPCUACertIPs))
+	for i, ip := range s.cfg.OPCUACertIPs {
+		wanted[i] = net.ParseIP(ip).String()
+	}
+	return sameElements(ips, wanted)
+}
+
+func sameElements(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// createSelfSignedCert generates a self-signed certificate for OPC UA server
+func (s *Server) createSelfSignedCert(certPath, keyPath string, now time.Time) (*x509.Certificate, error) {
+	// Generate RSA key pair
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	// Create certificate template
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	applicationURI, err := url.Parse(s.applicationURI())
+	if err != nil {
+		return nil, fmt.Errorf("invalid application URI: %w", err)
+	}
+	ips := make([]net.IP, len(s.cfg.OPCUACertIPs))
+	for i, ip := range s.cfg.OPCUACertIPs {
+		ips[i] = net.ParseIP(ip)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   s.cfg.SimulatorName,
+			Organization: []string{"Shopfloor Simulator"},
+		},
+		// Tolerate clients whose clock is slightly behind
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(s.cfg.OPCUACertLifetime),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageKeyEncipherment |
+			x509.KeyUsageDataEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              s.hostnames(),
+		IPAddresses:           ips,
+		// OPC UA application URI as SAN
+		URIs: []*url.URL{applicationURI},
+	}
+
+	// Create self-signed certificate
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	// A crash between the writes leaves a mismatched pair, which is
+	// regenerated at the next start
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := writeFileAtomic(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write cert file: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().
+		Str("certPath", certPath).
+		Str("keyPath", keyPath).
+		Str("applicationUri", s.applicationURI()).
+		Strs("hostnames", cert.DNSNames).
+		Time("expiry", cert.NotAfter).
+		Msg("Self-signed certificates generated successfully")
+	return cert, nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// watchCertificate renews a managed certificate before it expires and picks
+// up a provided certificate that was replaced on disk. Either way the server
+// restarts, as the OPC UA stack reads its certificate only at startup.
+func (s *Server) watchCertificate(ctx context.Context) {
+	_, _, managed := s.certificatePaths()
+	for {
+		wait := certificateCheckInterval
+		if managed {
+			// Wake up when the renewal is due, retrying failures every minute
+			due := time.Until(s.CertificateExpiry().Add(-s.cfg.OPCUACertRenewBefore))
+			wait = min(wait, max(due, time.Minute))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		changed, err := s.checkCertificate(time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check OPC UA server certificate")
+			continue
+		}
+		if changed {
+			s.restart()
+		}
+	}
+}
+
+// checkCertificate renews a managed certificate that is due and reloads a
+// provided one. It reports whether the server certificate changed.
+func (s *Server) checkCertificate(now time.Time) (bool, error) {
+	certPath, keyPath, managed := s.certificatePaths()
+	if managed {
+		expiry := s.CertificateExpiry()
+		if now.Before(expiry.Add(-s.cfg.OPCUACertRenewBefore)) {
+			return false, nil
+		}
+		log.Info().Time("expiry", expiry).Msg("Renewing OPC UA server certificate")
+		cert, err := s.createSelfSignedCert(certPath, keyPath, now)
+		if err != nil {
+			return false, err
+		}
+		s.setCertificate(cert)
+		return true, nil
+	}
+
+	cert, err := loadCertificate(certPath, keyPath)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := s.cert != nil && s.cert.Equal(cert)
+	s.mu.RUnlock()
+	if unchanged {
+		if now.After(cert.NotAfter.Add(-s.cfg.OPCUACertRenewBefore)) {
+			log.Warn().
+				Str("certFile", certPath).
+				Time("expiry", cert.NotAfter).
+				Msg("Provided server certificate expires soon, replace it")
+		}
+		return false, nil
+	}
+	log.Info().Str("certFile", certPath).Time("expiry", cert.NotAfter).Msg("Provided server certificate was replaced")
+	s.setCertificate(cert)
+	return true, nil
+}
@@ -0,0 +1,365 @@
+package opcua
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// Structured DataTypes of the production context
+const (
+	dataTypeProductionOrder = "ProductionOrderDataType"
+	dataTypePlannedBreak    = "PlannedBreakDataType"
+)
+
+// productionOrder is the value of a ProductionOrderDataType. Its fields are
+// encoded in the order of orderFields.
+type productionOrder struct {
+	OrderId             string
+	PartNumber          string
+	PartDescription     string
+	Quantity            int32
+	QuantityCompleted   int32
+	QuantityScrap       int32
+	DueDate             time.Time
+	Customer            string
+	Priority            int32
+	Status              string
+	StartedAt           time.Time
+	EstimatedCompletion time.Time
+}
+
+// plannedBreak is the value of a PlannedBreakDataType
+type plannedBreak struct {
+	Start time.Time
+	End   time.Time
+	Type  string
+}
+
+func init() {
+	ua.RegisterBinaryEncodingID(reflect.TypeOf(productionOrder{}), encodingID(dataTypeProductionOrder))
+	ua.RegisterBinaryEncodingID(reflect.TypeOf(plannedBreak{}), encodingID(dataTypePlannedBreak))
+}
+
+// encodingID returns the Default Binary encoding of a DataType. The namespace
+// is given by URI as its index is only known once the server runs.
+func encodingID(dataType string) ua.ExpandedNodeID {
+	return ua.ExpandedNodeID{NamespaceURI: NamespaceURI, NodeID: ua.NodeIDString{ID: dataType + ".DefaultBinary"}}
+}
+
+// structField is a field of a structured DataType. Objects holding a
+// structure publish each field as a variable of the same name.
+type structField struct {
+	name, description string
+	dataType          ua.NodeID
+}
+
+var orderFields = []structField{
+	{"OrderId", "Order number", ua.DataTypeIDString},
+	{"PartNumber", "Part number to weld", ua.DataTypeIDString},
+	{"PartDescription", "Description of the part", ua.DataTypeIDString},
+	{"Quantity", "Ordered quantity", ua.DataTypeIDInt32},
+	{"QuantityCompleted", "Good parts welded", ua.DataTypeIDInt32},
+	{"QuantityScrap", "Scrap parts welded", ua.DataTypeIDInt32},
+	{"DueDate", "Time the order is due", ua.DataTypeIDDateTime},
+	{"Customer", "Customer of the order", ua.DataTypeIDString},
+	{"Priority", "Priority from 1 (urgent) to 4 (low)", ua.DataTypeIDInt32},
+	{"Status", "QUEUED, IN_PROGRESS, COMPLETED or CANCELLED", ua.DataTypeIDString},
+	{"StartedAt", "Time welding of the order started", ua.DataTypeIDDateTime},
+	{"EstimatedCompletion", "Estimated time the order is finished", ua.DataTypeIDDateTime},
+}
+
+var plannedBreakFields = []structField{
+	{"Start", "Start of the break", ua.DataTypeIDDateTime},
+	{"End", "End of the break", ua.DataTypeIDDateTime},
+	{"Type", "break or lunch", ua.DataTypeIDString},
+}
+
+// shiftFields are the scalar variables of the Shift object
+var shiftFields = []struct {
+	name, description string
+	dataType          ua.NodeID
+	value             func(shift *simulator.Shift) interface{}
+}{
+	{"ShiftId", "Shift identifier", ua.DataTypeIDString, func(sh *simulator.Shift) interface{} { return sh.ShiftID }},
+	{"ShiftName", "Name of the shift", ua.DataTypeIDString, func(sh *simulator.Shift) interface{} { return sh.ShiftName }},
+	{"ShiftNumber", "Number of the shift within the day", ua.DataTypeIDInt32, func(sh *simulator.Shift) interface{} { return int32(sh.ShiftNumber) }},
+	{"Crew", "Crew working the shift", ua.DataTypeIDString, func(sh *simulator.Shift) interface{} { return sh.Crew }},
+	{"StartTime", "Start of the shift", ua.DataTypeIDDateTime, func(sh *simulator.Shift) interface{} { return sh.StartTime.UTC() }},
+	{"EndTime", "End of the shift", ua.DataTypeIDDateTime, func(sh *simulator.Shift) interface{} { return sh.EndTime.UTC() }},
+	{"WorkCenterId", "Work center of the machine", ua.DataTypeIDString, func(sh *simulator.Shift) interface{} { return sh.WorkCenterID }},
+	{"Status", "ACTIVE, ENDED or UPCOMING, empty while no shift is scheduled", ua.DataTypeIDString, func(sh *simulator.Shift) interface{} { return sh.Status }},
+}
+
+// newProductionOrder returns the structure of order, empty if order is nil
+func newProductionOrder(order *simulator.ProductionOrder) productionOrder {
+	if order == nil {
+		return productionOrder{}
+	}
+	return productionOrder{
+		OrderId:             order.OrderID,
+		PartNumber:          order.PartNumber,
+		PartDescription:     order.PartDescription,
+		Quantity:            int32(order.Quantity),
+		QuantityCompleted:   int32(order.QuantityCompleted),
+		QuantityScrap:       int32(order.QuantityScrap),
+		DueDate:             order.DueDate.UTC(),
+		Customer:            order.Customer,
+		Priority:            int32(order.Priority),
+		Status:              order.Status,
+		StartedAt:           order.StartedAt.UTC(),
+		EstimatedCompletion: order.EstimatedCompletion.UTC(),
+	}
+}
+
+// createDataTypes registers the structured DataTypes with their Default
+// Binary encodings, so clients can read their definitions and decode values
+func (s *Server) createDataTypes(nm *server.NamespaceManager) {
+	for _, t := range []struct {
+		name, description string
+		fields            []structField
+	}{
+		{dataTypeProductionOrder, "Production order with its quantities, due date and customer", orderFields},
+		{dataTypePlannedBreak, "Planned break within a shift", plannedBreakFields},
+	} {
+		encoding := s.nodeID(t.name + ".DefaultBinary")
+		fields := make([]ua.StructureField, len(t.fields))
+		for i, f := range t.fields {
+			fields[i] = ua.StructureField{
+				Name:            f.name,
+				Description:     ua.LocalizedText{Text: f.description},
+				DataType:        f.dataType,
+				ValueRank:       ua.ValueRankScalar,
+				ArrayDimensions: []uint32{},
+			}
+		}
+
+		nm.AddNode(server.NewDataTypeNode(
+			s.srv,
+			s.nodeID(t.name),
+			s.browseName(t.name),
+			ua.LocalizedText{Text: t.name},
+			ua.LocalizedText{Text: t.description},
+			nil,
+			[]ua.Reference{
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasSubtype,
+					IsInverse:       true,
+					TargetID:        ua.ExpandedNodeID{NodeID: ua.DataTypeIDStructure},
+				},
+			},
+			false,
+			ua.StructureDefinition{
+				DefaultEncodingID: encoding,
+				BaseDataType:      ua.DataTypeIDStructure,
+				StructureType:     ua.StructureTypeStructure,
+				Fields:            fields,
+			},
+		))
+		nm.AddNode(server.NewObjectNode(
+			s.srv,
+			encoding,
+			ua.QualifiedName{Name: "Default Binary"},
+			ua.LocalizedText{Text: "Default Binary"},
+			ua.LocalizedText{},
+			nil,
+			[]ua.Reference{
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasEncoding,
+					IsInverse:       true,
+					TargetID:        ua.ExpandedNodeID{NodeID: s.nodeID(t.name)},
+				},
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+					TargetID:        ua.ExpandedNodeID{NodeID: ua.ObjectTypeIDDataTypeEncodingType},
+				},
+			},
+			0,
+		))
+	}
+}
+
+// createProduction adds the current order to the current job, the order
+// queue to the job list and the current shift to the robot
+func (s *Server) createProduction(robot, jobs, job objectRef) {
+	order := s.addOrder(job, "Robot.ProductionJobList.CurrentJob.Order", "Order", "Production order being welded", "Order")
+	s.declare(job, order, ua.ObjectIDModellingRuleMandatory)
+
+	// Queued orders are added and removed as the queue changes
+	s.orderQueue = s.addObject(jobs, ua.ReferenceTypeIDHasComponent, "Robot.ProductionJobList.OrderQueue", "OrderQueue", "Order Queue",
+		"Production orders waiting to be welded", s.nodeID(typeOrderQueue))
+	s.queuedOrders = make(map[string]*server.ObjectNode)
+	s.addVariable(s.orderQueue, "OrderQueue.Orders", "Orders", "Queued orders in production sequence",
+		s.nodeID(dataTypeProductionOrder), ua.ValueRankOneDimension, []ua.ExtensionObject{})
+	s.declare(s.orderQueue, server.NewObjectNode(
+		s.srv,
+		s.nodeID("Robot.ProductionJobList.OrderQueue.<OrderId>"),
+		s.browseName("<OrderId>"),
+		ua.LocalizedText{Text: "<OrderId>"},
+		ua.LocalizedText{Text: "Queued production order, named by its order number"},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.orderQueue.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.nodeID(typeProductionOrder)},
+			},
+		},
+		0,
+	), ua.ObjectIDModellingRuleOptionalPlaceholder)
+
+	shift := s.addObject(robot, ua.ReferenceTypeIDHasComponent, "Robot.Shift", "Shift", "Shift", "Current work shift", s.nodeID(typeShift))
+	for _, f := range shiftFields {
+		s.addVariable(shift, "Shift."+f.name, f.name, f.description, f.dataType, ua.ValueRankScalar, f.value(&simulator.Shift{}))
+	}
+	s.addVariable(shift, "Shift.PlannedBreaks", "PlannedBreaks", "Planned breaks of the shift",
+		s.nodeID(dataTypePlannedBreak), ua.ValueRankOneDimension, []ua.ExtensionObject{})
+}
+
+// addOrder creates a ProductionOrderType object below parent with a variable
+// for each order field, whose NodeIds are Robot.<prefix>.<field>
+func (s *Server) addOrder(parent objectRef, id, browseName, description, prefix string) *server.ObjectNode {
+	node := server.NewObjectNode(
+		s.srv,
+		s.nodeID(id),
+		s.browseName(browseName),
+		ua.LocalizedText{Text: browseName},
+		ua.LocalizedText{Text: description},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: parent.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.nodeID(typeProductionOrder)},
+			},
+		},
+		0,
+	)
+	s.srv.NamespaceManager().AddNode(node)
+
+	order := objectRef{id: node.NodeID(), typeID: s.nodeID(typeProductionOrder)}
+	empty := reflect.ValueOf(productionOrder{})
+	for _, f := range orderFields {
+		s.addVariable(order, prefix+"."+f.name, f.name, f.description, f.dataType, ua.ValueRankScalar, empty.FieldByName(f.name).Interface())
+	}
+	return node
+}
+
+// addVariable creates a read-only variable with the NodeId Robot.<name>
+// below parent. The production context changes rarely and is not historized.
+func (s *Server) addVariable(parent objectRef, name, browseName, description string, dataType ua.NodeID, valueRank int32, value interface{}) {
+	dimensions := []uint32{}
+	if valueRank == ua.ValueRankOneDimension {
+		dimensions = []uint32{0}
+	}
+	node := server.NewVariableNode(
+		s.srv,
+		s.nodeID("Robot."+name),
+		s.browseName(browseName),
+		ua.LocalizedText{Text: browseName},
+		ua.LocalizedText{Text: description},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: parent.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: ua.VariableTypeIDBaseDataVariableType},
+			},
+		},
+		ua.NewDataValue(value, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+		dataType,
+		valueRank,
+		dimensions,
+		ua.AccessLevelsCurrentRead,
+		250.0,
+		false,
+		nil,
+	)
+	s.srv.NamespaceManager().AddNode(node)
+	s.declare(parent, node, ua.ObjectIDModellingRuleMandatory)
+	s.varNodes[name] = node
+}
+
+// UpdateProduction publishes the current order, the order queue and the
+// current shift. It is only called in the simulation loop, after Publish,
+// and completes the tick by updating the bound NodeSet variables.
+func (s *Server) UpdateProduction(order *simulator.ProductionOrder, queue []*simulator.ProductionOrder, shift *simulator.Shift) {
+	if s.srv == nil || len(s.varNodes) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+
+	s.setOrderValues("Order", newProductionOrder(order), now)
+
+	s.syncQueue(queue)
+	orders := make([]ua.ExtensionObject, len(queue))
+	for i, queued := range queue {
+		value := newProductionOrder(queued)
+		s.setOrderValues("OrderQueue."+queued.OrderID, value, now)
+		orders[i] = value
+	}
+	s.setNodeValue("OrderQueue.Orders", orders, ua.Good, now)
+
+	if shift == nil {
+		shift = &simulator.Shift{}
+	}
+	for _, f := range shiftFields {
+		s.setNodeValue("Shift."+f.name, f.value(shift), ua.Good, now)
+	}
+	breaks := make([]ua.ExtensionObject, len(shift.PlannedBreaks))
+	for i, b := range shift.PlannedBreaks {
+		breaks[i] = plannedBreak{Start: b.Start.UTC(), End: b.End.UTC(), Type: b.Type}
+	}
+	s.setNodeValue("Shift.PlannedBreaks", breaks, ua.Good, now)
+
+	s.updateBindings()
+}
+
+// setOrderValues sets the field variables of the order object at prefix
+func (s *Server) setOrderValues(prefix string, order productionOrder, now time.Time) {
+	value := reflect.ValueOf(order)
+	for _, f := range orderFields {
+		s.setNodeValue(prefix+"."+f.name, value.FieldByName(f.name).Interface(), ua.Good, now)
+	}
+}
+
+// syncQueue adds an object for each newly queued order and removes the
+// objects of orders that left the queue
+func (s *Server) syncQueue(queue []*simulator.ProductionOrder) {
+	queued := make(map[string]bool, len(queue))
+	for _, order := range queue {
+		queued[order.OrderID] = true
+		if _, ok := s.queuedOrders[order.OrderID]; !ok {
+			s.queuedOrders[order.OrderID] = s.addOrder(s.orderQueue, "Robot.ProductionJobList.OrderQueue."+order.OrderID,
+				order.OrderID, "Queued production order", "OrderQueue."+order.OrderID)
+		}
+	}
+
+	nm := s.srv.NamespaceManager()
+	for id, node := range s.queuedOrders {
+		if queued[id] {
+			continue
+		}
+		nm.DeleteNode(node, true)
+		for _, f := range orderFields {
+			delete(s.varNodes, "OrderQueue."+id+"."+f.name)
+		}
+		delete(s.queuedOrders, id)
+	}
+}
@@ -0,0 +1,234 @@
+package opcua
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// Trust list directories below the PKI directory. Client application
+// certificates are trusted by copying them to trustedCerts; certificates of
+// rejected clients and users are written to rejected so they can be moved
+// there.
+var (
+	trustedCerts = []string{"trusted", "certs"}
+	trustedCRL   = []string{"trusted", "crl"}
+	issuerCerts  = []string{"issuers", "certs"}
+	issuerCRL    = []string{"issuers", "crl"}
+	userCerts    = []string{"users", "certs"}
+	rejected     = []string{"rejected"}
+)
+
+// securityPolicyURIs maps the configured policy names to their URIs
+var securityPolicyURIs = map[string]string{
+	config.SecurityPolicyNone:                ua.SecurityPolicyURINone,
+	config.SecurityPolicyBasic256Sha256:      ua.SecurityPolicyURIBasic256Sha256,
+	config.SecurityPolicyAes128Sha256RsaOaep: ua.SecurityPolicyURIAes128Sha256RsaOaep,
+	config.SecurityPolicyAes256Sha256RsaPss:  ua.SecurityPolicyURIAes256Sha256RsaPss,
+}
+
+// securityModes maps the configured mode names to message security modes
+var securityModes = map[string]ua.MessageSecurityMode{
+	config.SecurityModeSign:           ua.MessageSecurityModeSign,
+	config.SecurityModeSignAndEncrypt: ua.MessageSecurityModeSignAndEncrypt,
+}
+
+// pkiPath returns the path of a trust list directory
+func (s *Server) pkiPath(dir []string) string {
+	return filepath.Join(append([]string{s.cfg.OPCUAPKIDir}, dir...)...)
+}
+
+// ensureTrustList creates the trust list directories if they don't exist
+func (s *Server) ensureTrustList() error {
+	for _, dir := range [][]string{trustedCerts, trustedCRL, issuerCerts, issuerCRL, userCerts, rejected} {
+		if err := os.MkdirAll(s.pkiPath(dir), 0755); err != nil {
+			return fmt.Errorf("failed to create trust list directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// securityOptions returns the server options for the configured security
+// policies, user authentication and trust list
+func (s *Server) securityOptions() []server.Option {
+	options := []server.Option{
+		server.WithSecurityPolicyNone(slices.Contains(s.cfg.OPCUASecurityPolicies, config.SecurityPolicyNone)),
+		server.WithAnonymousIdentity(s.cfg.OPCUAAllowAnonymous),
+		server.WithTrustedCertificatesPaths(s.pkiPath(trustedCerts), s.pkiPath(trustedCRL)),
+		server.WithIssuerCertificatesPaths(s.pkiPath(issuerCerts), s.pkiPath(issuerCRL)),
+		server.WithRejectedCertificatesPath(s.pkiPath(rejected)),
+	}
+	if s.cfg.OPCUATrustAllClients {
+		options = append(options, server.WithInsecureSkipVerify())
+	}
+	if len(s.cfg.OPCUAUsers) > 0 {
+		users := make(map[string]string, len(s.cfg.OPCUAUsers))
+		for _, user := range s.cfg.OPCUAUsers {
+			name, password, _ := strings.Cut(user, ":")
+			users[name] = password
+		}
+		options = append(options, server.WithAuthenticateUserNameIdentityFunc(
+			func(identity ua.UserNameIdentity, applicationURI string, endpointURL string) error {
+				return authenticateUser(users, identity, applicationURI)
+			}))
+	}
+	if s.cfg.OPCUAX509Users {
+		options = append(options, server.WithAuthenticateX509IdentityFunc(s.authenticateCertificate))
+	}
+	return options
+}
+
+// restrictEndpoints disables the endpoints whose security policy or mode is
+// not configured. The library always offers every policy it implements, so
+// the disabled endpoints stay listed but carry no user token policies, so no
+// session can be activated on them.
+func (s *Server) restrictEndpoints() {
+	policies := map[string]bool{}
+	for _, name := range s.cfg.OPCUASecurityPolicies {
+		policies[securityPolicyURIs[name]] = true
+	}
+	modes := map[ua.MessageSecurityMode]bool{ua.MessageSecurityModeNone: true}
+	for _, name := range s.cfg.OPCUASecurityModes {
+		modes[securityModes[name]] = true
+	}
+
+	// Endpoints returns the slice the server hands out to clients
+	endpoints := s.srv.Endpoints()
+	for i, ep := range endpoints {
+		if !policies[ep.SecurityPolicyURI] || !modes[ep.SecurityMode] {
+			endpoints[i].UserIdentityTokens = nil
+			continue
+		}
+		log.Info().
+			Str("policy", strings.TrimPrefix(ep.SecurityPolicyURI, "http://opcfoundation.org/UA/SecurityPolicy#")).
+			Str("mode", ep.SecurityMode.String()).
+			Msg("OPC UA endpoint enabled")
+	}
+}
+
+// authenticateUser checks a username and password against the configured users
+func authenticateUser(users map[string]string, identity ua.UserNameIdentity, applicationURI string) error {
+	password, ok := users[identity.UserName]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(identity.Password)) != 1 {
+		log.Warn().
+			Str("user", identity.UserName).
+			Str("application", applicationURI).
+			Msg("OPC UA user authentication failed")
+		return ua.BadUserAccessDenied
+	}
+	log.Info().
+		Str("user", identity.UserName).
+		Str("application", applicationURI).
+		Msg("OPC UA user authenticated")
+	return nil
+}
+
+// authenticateCertificate accepts a user certificate that is in the users
+// trust list or issued by a CA certificate there. The server has already
+// checked that the client holds its private key.
+func (s *Server) authenticateCertificate(identity ua.X509Identity, applicationURI string, endpointURL string) error {
+	cert, err := x509.ParseCertificate([]byte(identity.Certificate))
+	if err != nil {
+		return ua.BadIdentityTokenInvalid
+	}
+
+	if err := s.verifyUserCertificate(cert); err != nil {
+		log.Warn().
+			Err(err).
+			Str("subject", cert.Subject.String()).
+			Str("application", applicationURI).
+			Msg("OPC UA user certificate rejected")
+		s.rejectCertificate(cert)
+		return ua.BadIdentityTokenRejected
+	}
+	log.Info().
+		Str("subject", cert.Subject.String()).
+		Str("application", applicationURI).
+		Msg("OPC UA user authenticated by certificate")
+	return nil
+}
+
+// verifyUserCertificate checks cert against the trusted user certificates
+func (s *Server) verifyUserCertificate(cert *x509.Certificate) error {
+	trusted, err := readCertificates(s.pkiPath(userCerts))
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	for _, t := range trusted {
+		if t.Equal(cert) {
+			now := time.Now()
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				return fmt.Errorf("certificate is not valid at %s", now.Format(time.RFC3339))
+			}
+			return nil
+		}
+		if t.IsCA {
+			roots.AddCert(t)
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// readCertificates reads the PEM or DER certificates in dir
+func readCertificates(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var certs []*x509.Certificate
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		if block, _ := pem.Decode(data); block == nil {
+			if cert, err := x509.ParseCertificate(data); err == nil {
+				certs = append(certs, cert)
+			}
+			continue
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+			}
+		}
+	}
+	return certs, nil
+}
+
+// rejectCertificate stores cert with the rejected certificates, named by its
+// thumbprint like the rejected client certificates
+func (s *Server) rejectCertificate(cert *x509.Certificate) {
+	path := filepath.Join(s.pkiPath(rejected), fmt.Sprintf("%x.crt", sha1.Sum(cert.Raw)))
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to store rejected certificate")
+	}
+}
@@ -2,16 +2,8 @@ package opcua
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
-	"net"
-	"net/url"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -20,214 +12,124 @@ import (
 	"github.com/awcullen/opcua/ua"
 	"github.com/rs/zerolog/log"
 
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
 	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
 )
 
-const (
-	pkiDir   = "./pki"
-	certFile = "./pki/server.crt"
-	keyFile  = "./pki/server.key"
-)
-
 // Server wraps the OPC UA server and manages node values
 type Server struct {
 	srv       *server.Server
+	cfg       *config.Config
 	port      int
 	namespace uint16
-	nodes     map[string]*NodeInfo
 	varNodes  map[string]*server.VariableNode // OPC UA variable nodes for value updates
 	mu        sync.RWMutex
 
-	// Node references for quick access
-	currentNode       ua.NodeID
-	voltageNode       ua.NodeID
-	wireFeedNode      ua.NodeID
-	gasFlowNode       ua.NodeID
-	travelSpeedNode   ua.NodeID
-	arcTimeNode       ua.NodeID
-	posXNode          ua.NodeID
-	posYNode          ua.NodeID
-	posZNode          ua.NodeID
-	torchAngleNode    ua.NodeID
-	stateNode         ua.NodeID
-	goodPartsNode     ua.NodeID
-	scrapPartsNode    ua.NodeID
-	orderIdNode       ua.NodeID
-	partNumberNode    ua.NodeID
-	cycleProgressNode ua.NodeID
-	errorCodeNode     ua.NodeID
-	errorMessageNode  ua.NodeID
-	errorTimeNode     ua.NodeID
-}
-
-// NodeInfo holds information about an OPC UA node
-type NodeInfo struct {
-	NodeID   ua.NodeID
-	Name     string
-	DataType ua.NodeID
-	Value    interface{}
-}
-
-// NewServer creates a new OPC UA server
-func NewServer(port int, simulatorName string) (*Server, error) {
-	s := &Server{
-		port:     port,
-		nodes:    make(map[string]*NodeInfo),
-		varNodes: make(map[string]*server.VariableNode),
-	}
-
-	return s, nil
-}
-
-// ensurePKI creates PKI directory and self-signed certificates if they don't exist
-func ensurePKI(appName string) error {
-	// Check if cert already exists
-	if _, err := os.Stat(certFile); err == nil {
-		log.Info().Str("certFile", certFile).Msg("Using existing PKI certificates")
-		return nil
-	}
-
-	log.Info().Msg("Generating self-signed certificates for OPC UA server")
+	// Records the values of the historized variables, nil if history is off
+	historian *historian
 
-	// Create PKI directory
-	if err := os.MkdirAll(pkiDir, 0755); err != nil {
-		return fmt.Errorf("failed to create PKI directory: %w", err)
-	}
+	// Server certificate in use, guarded by mu
+	cert *x509.Certificate
 
-	// Generate self-signed certificate
-	return createSelfSignedCert(appName, certFile, keyFile)
-}
+	// Closed when the running server has stopped listening
+	stopped chan struct{}
 
-// createSelfSignedCert generates a self-signed certificate for OPC UA server
-func createSelfSignedCert(appName, certPath, keyPath string) error {
-	// Generate RSA key pair
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("failed to generate private key: %w", err)
-	}
+	// Targets of the methods, only used by commands in the simulation loop
+	stateMachine *simulator.StateMachine
+	generator    *simulator.TimeseriesGenerator
+	recipes      func(name string) (simulator.WeldRecipe, bool)
+	commands     chan Command
 
-	// Create certificate template
-	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
-	if err != nil {
-		return fmt.Errorf("failed to generate serial number: %w", err)
-	}
+	// Alarm conditions of the machine errors, only changed in the simulation
+	// loop
+	alarms      []*alarm
+	alarmSource objectRef
+	alarmParent objectRef
 
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			CommonName:   appName,
-			Organization: []string{"Shopfloor Simulator"},
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour), // 1 year validity
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		BasicConstraintsValid: true,
-		DNSNames:              []string{"localhost", appName, "welding-simulator", "welding-robot"},
-		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("0.0.0.0")},
-	}
+	// Objects of the queued orders by order ID, only changed in the
+	// simulation loop
+	queuedOrders map[string]*server.ObjectNode
+	orderQueue   objectRef
 
-	// Add OPC UA application URI as SAN
-	template.URIs = []*url.URL{
-		{Scheme: "urn", Opaque: "shopfloor-simulator:welding-robot"},
-	}
+	// Namespaces of the loaded companion NodeSets
+	companions []uint16
 
-	// Create self-signed certificate
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
-	if err != nil {
-		return fmt.Errorf("failed to create certificate: %w", err)
-	}
+	// NodeIds of the MachineryItemState states by name
+	itemStates map[string]ua.NodeID
 
-	// Write certificate to file
-	certFileHandle, err := os.Create(certPath)
-	if err != nil {
-		return fmt.Errorf("failed to create cert file: %w", err)
-	}
-	defer certFileHandle.Close()
+	// Variables of the custom NodeSet bound to simulator variables, only
+	// changed in the simulation loop
+	bindings []binding
 
-	if err := pem.Encode(certFileHandle, &pem.Block{Type: "CERTIFICATE", Bytes: certDER}); err != nil {
-		return fmt.Errorf("failed to encode certificate: %w", err)
-	}
+	// Last published values of the signals, guarded by mu
+	values map[string]interface{}
+}
 
-	// Write private key to file
-	keyFileHandle, err := os.Create(keyPath)
-	if err != nil {
-		return fmt.Errorf("failed to create key file: %w", err)
+// NewServer creates a new OPC UA server. Its methods act on stateMachine and
+// generator; recipes looks up weld recipes by name.
+func NewServer(cfg *config.Config, stateMachine *simulator.StateMachine, generator *simulator.TimeseriesGenerator,
+	recipes func(name string) (simulator.WeldRecipe, bool)) (*Server, error) {
+	s := &Server{
+		cfg:          cfg,
+		port:         cfg.OPCUAPort,
+		varNodes:     make(map[string]*server.VariableNode),
+		values:       make(map[string]interface{}),
+		stateMachine: stateMachine,
+		generator:    generator,
+		recipes:      recipes,
+		commands:     make(chan Command),
 	}
-	defer keyFileHandle.Close()
-
-	keyDER := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err := pem.Encode(keyFileHandle, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyDER}); err != nil {
-		return fmt.Errorf("failed to encode private key: %w", err)
+	if cfg.OPCUAHistorySize > 0 {
+		s.historian = newHistorian(cfg.OPCUAHistorySize)
 	}
 
-	log.Info().
-		Str("certPath", certPath).
-		Str("keyPath", keyPath).
-		Msg("Self-signed certificates generated successfully")
-
-	return nil
+	return s, nil
 }
 
 // Start starts the OPC UA server
 func (s *Server) Start(ctx context.Context) error {
-	endpoint := fmt.Sprintf("opc.tcp://0.0.0.0:%d", s.port)
-
 	log.Info().
 		Int("port", s.port).
-		Str("endpoint", endpoint).
+		Str("endpoint", s.endpointURL()).
 		Msg("Starting OPC UA server")
 
-	// Initialize node references (for value storage even if server fails to start)
-	s.initializeNodeReferences()
-
 	// Generate self-signed certificates if needed
-	if err := ensurePKI("WeldingRobotSimulator"); err != nil {
+	if err := s.ensureCertificate(time.Now()); err != nil {
 		log.Warn().Err(err).Msg("Failed to create PKI - OPC UA server disabled")
 		log.Info().Msg("OPC UA server disabled - running simulator in data generation mode only")
 		return nil
 	}
+	if err := s.ensureTrustList(); err != nil {
+		log.Warn().Err(err).Msg("Failed to create trust list - OPC UA server disabled")
+		log.Info().Msg("OPC UA server disabled - running simulator in data generation mode only")
+		return nil
+	}
 
-	// Try to create the OPC UA server with panic recovery
-	var srv *server.Server
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Warn().
-					Interface("panic", r).
-					Msg("OPC UA server creation panicked - running in value storage mode only")
-			}
-		}()
+	if err := s.serve(); err != nil {
+		return err
+	}
+	go s.watchCertificate(ctx)
+	return nil
+}
 
-		var err error
-		srv, err = server.New(
-			ua.ApplicationDescription{
-				ApplicationURI:  "urn:shopfloor-simulator:welding-robot",
-				ProductURI:      "urn:shopfloor-simulator",
-				ApplicationName: ua.LocalizedText{Text: "Welding Robot Simulator", Locale: "en"},
-				ApplicationType: ua.ApplicationTypeServer,
-			},
-			certFile, // Self-signed certificate
-			keyFile,  // Private key
-			endpoint,
-			server.WithAnonymousIdentity(true),
-			server.WithSecurityPolicyNone(true),
-			server.WithInsecureSkipVerify(),
-		)
-		if err != nil {
-			log.Warn().
-				Err(err).
-				Msg("OPC UA server creation failed - running in value storage mode only")
-			srv = nil
-		}
-	}()
+func (s *Server) endpointURL() string {
+	return fmt.Sprintf("opc.tcp://0.0.0.0:%d", s.port)
+}
 
-	if srv == nil {
+// serve creates the OPC UA server with the current certificate, registers
+// the nodes and starts listening
+func (s *Server) serve() error {
+	srv, err := s.newServer()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("OPC UA server creation failed - running in value storage mode only")
 		log.Info().Msg("OPC UA server disabled - running simulator in data generation mode only")
 		return nil
 	}
 
 	s.srv = srv
+	s.restrictEndpoints()
 
 	// Register nodes in address space BEFORE starting server
 	if err := s.createNodes(); err != nil {
@@ -236,13 +138,16 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	// Start server in background
+	stopped := make(chan struct{})
+	s.stopped = stopped
 	go func() {
+		defer close(stopped)
 		defer func() {
 			if r := recover(); r != nil {
 				log.Error().Interface("panic", r).Msg("OPC UA server panic")
 			}
 		}()
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && err != ua.BadServerHalted {
 			log.Error().Err(err).Msg("OPC UA server error")
 		}
 	}()
@@ -251,6 +156,62 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
+// newServer creates the OPC UA server with the current certificate
+func (s *Server) newServer() (srv *server.Server, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("OPC UA server creation panicked: %v", r)
+		}
+	}()
+
+	endpoint := s.endpointURL()
+	certPath, keyPath, _ := s.certificatePaths()
+	options := append(s.securityOptions(),
+		// Condition methods of namespace 0 are shared by all servers and
+		// cannot carry permissions of their own
+		server.WithRolePermissions(clientPermissions(ua.PermissionTypeCall)),
+	)
+	if s.historian != nil {
+		options = append(options, server.WithHistorian(s.historian))
+	}
+	return server.New(
+		ua.ApplicationDescription{
+			ApplicationURI:  s.applicationURI(),
+			ProductURI:      "urn:shopfloor-simulator",
+			ApplicationName: ua.LocalizedText{Text: "Welding Robot Simulator", Locale: "en"},
+			ApplicationType: ua.ApplicationTypeServer,
+			// Discovery-only connections use it when policy None is disabled
+			DiscoveryURLs: []string{endpoint},
+		},
+		certPath,
+		keyPath,
+		endpoint,
+		options...,
+	)
+}
+
+// restart replaces the running server by one with the current certificate.
+// Clients have to reconnect; values, history and alarms are kept.
+func (s *Server) restart() {
+	log.Info().Msg("Restarting OPC UA server")
+	if s.srv != nil {
+		if err := s.srv.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to stop OPC UA server")
+		}
+		<-s.stopped
+	}
+
+	// The address space is rebuilt between simulation ticks
+	ok := s.execute(func(time.Time) {
+		if err := s.serve(); err != nil {
+			log.Error().Err(err).Msg("Failed to restart OPC UA server")
+		}
+	})
+	if !ok {
+		log.Error().Msg("Simulation loop did not accept the OPC UA server restart")
+	}
+}
+
 // Stop stops the OPC UA server
 func (s *Server) Stop(ctx context.Context) error {
 	if s.srv != nil {
@@ -260,213 +221,153 @@ func (s *Server) Stop(ctx context.Context) error {
 }
 
 func (s *Server) createNodes() error {
-	s.namespace = 2 // Use namespace index 2 for our custom nodes
 	nm := s.srv.NamespaceManager()
+	s.namespace = nm.Add(NamespaceURI)
+	if err := s.loadCompanionNodeSets(); err != nil {
+		return err
+	}
 
-	// Create folder node for Robot under Objects folder
-	robotFolder := server.NewObjectNode(
+	s.createTypes(nm)
+	s.createDataTypes(nm)
+
+	// Machines folder, nameplate and state machine of OPC UA for Machinery
+	machinery, err := s.machinery()
+	if err != nil {
+		return err
+	}
+	s.itemStates = machinery.states
+
+	robot := s.addNotifier(machinery.machines, ua.ReferenceTypeIDOrganizes, "Robot", "Robot", s.cfg.SimulatorName, "Welding Robot Data", s.nodeID(typeWeldingRobot),
+		ua.EventNotifierSubscribeToEvents)
+	identification := s.addQualified(robot, ua.ReferenceTypeIDHasAddIn, "Robot.Identification", machinery.identification, "Identification", "Machine nameplate", machinery.identificationType, 0)
+	itemState := s.addQualified(robot, ua.ReferenceTypeIDHasAddIn, "Robot.MachineryItemState", machinery.itemState, "Machinery Item State", "Operating state of the machine", machinery.itemStateType, 0)
+	controller := s.addObject(robot, ua.ReferenceTypeIDHasComponent, "Robot.Controller", "Controller", "Controller", "Robot controller", s.nodeID(typeWeldingController))
+	process := s.addObject(robot, ua.ReferenceTypeIDHasComponent, "Robot.Process", "Process", "Welding Process", "Arc welding process", s.nodeID(typeWeldingProcess))
+	jobs := s.addObject(robot, ua.ReferenceTypeIDHasComponent, "Robot.ProductionJobList", "ProductionJobList", "Production Job List", "Production jobs of the robot", s.nodeID(typeJobList))
+	job := s.addObject(jobs, ua.ReferenceTypeIDHasComponent, "Robot.ProductionJobList.CurrentJob", "CurrentJob", "Current Job", "Production order being welded", s.nodeID(typeJob))
+
+	// Nameplate properties
+	for _, p := range []struct {
+		name, description string
+		dataType          ua.NodeID
+		value             interface{}
+	}{
+		{"Manufacturer", "Manufacturer of the machine", ua.DataTypeIDLocalizedText, ua.LocalizedText{Text: manufacturer}},
+		{"Model", "Model of the machine", ua.DataTypeIDLocalizedText, ua.LocalizedText{Text: model}},
+		{"ProductInstanceUri", "Globally unique identifier of the machine", ua.DataTypeIDString, "urn:shopfloor-simulator:" + s.cfg.SimulatorName},
+		{"SerialNumber", "Serial number of the machine", ua.DataTypeIDString, s.cfg.SimulatorName},
+		{"ComponentName", "Name of the machine", ua.DataTypeIDLocalizedText, ua.LocalizedText{Text: s.cfg.SimulatorName}},
+		{"DeviceClass", "Class of the machine", ua.DataTypeIDString, deviceClass},
+	} {
+		property := createProperty(s, "Robot.Identification", machinery.nameplate(p.name), p.description, p.dataType, p.value)
+		nm.AddNode(property)
+		s.declare(identification, property, ua.ObjectIDModellingRuleMandatory)
+	}
+
+	// Variables of the signals below the object of their component
+	nodes, properties := s.createSignals(map[simulator.Component]objectRef{
+		simulator.ComponentRobot:      robot,
+		simulator.ComponentController: controller,
+		simulator.ComponentProcess:    process,
+		simulator.ComponentJob:        job,
+	})
+
+	// Current MachineryItemState, with the NodeId of the state as Id
+	currentState := server.NewVariableNode(
 		s.srv,
-		ua.NodeIDString{NamespaceIndex: 2, ID: "Robot"},
-		ua.QualifiedName{NamespaceIndex: 2, Name: "Robot"},
-		ua.LocalizedText{Text: "Robot"},
-		ua.LocalizedText{Text: "Welding Robot Data"},
+		s.nodeID("Robot.MachineryItemState.CurrentState"),
+		ua.QualifiedName{Name: "CurrentState"},
+		ua.LocalizedText{Text: "Current State"},
+		ua.LocalizedText{Text: "NotAvailable, OutOfService, NotExecuting or Executing"},
 		nil,
 		[]ua.Reference{
 			{
-				ReferenceTypeID: ua.ReferenceTypeIDOrganizes,
+				ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
 				IsInverse:       true,
-				TargetID:        ua.ExpandedNodeID{NodeID: ua.ObjectIDObjectsFolder},
+				TargetID:        ua.ExpandedNodeID{NodeID: itemState.id},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: ua.VariableTypeIDFiniteStateVariableType},
 			},
 		},
-		0,
+		ua.NewDataValue(ua.LocalizedText{Text: "NotAvailable"}, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+		ua.DataTypeIDLocalizedText,
+		ua.ValueRankScalar,
+		[]uint32{},
+		ua.AccessLevelsCurrentRead,
+		250.0,
+		false,
+		nil,
+	)
+	s.declare(itemState, currentState, ua.ObjectIDModellingRuleMandatory)
+	nodes = append(nodes, currentState)
+	properties = append(properties,
+		createProperty(s, "Robot.MachineryItemState.CurrentState", ua.QualifiedName{Name: "Id"}, "NodeId of the current state",
+			ua.DataTypeIDNodeID, s.itemStates["NotAvailable"]),
 	)
-	nm.AddNode(robotFolder)
-
-	// Helper function to create a variable node
-	createVar := func(name, displayName, description string, dataType ua.NodeID, initialValue interface{}) *server.VariableNode {
-		return server.NewVariableNode(
-			s.srv,
-			ua.NodeIDString{NamespaceIndex: 2, ID: "Robot." + name},
-			ua.QualifiedName{NamespaceIndex: 2, Name: name},
-			ua.LocalizedText{Text: displayName},
-			ua.LocalizedText{Text: description},
-			nil,
-			[]ua.Reference{
-				{
-					ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
-					IsInverse:       true,
-					TargetID:        ua.ExpandedNodeID{NodeID: ua.NodeIDString{NamespaceIndex: 2, ID: "Robot"}},
-				},
-			},
-			ua.NewDataValue(initialValue, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
-			dataType,
-			ua.ValueRankScalar,
-			[]uint32{},
-			ua.AccessLevelsCurrentRead,
-			250.0,
-			false,
-			nil,
-		)
-	}
-
-	// Create all welding parameter nodes
-	nodes := []*server.VariableNode{
-		createVar("WeldingCurrent", "Welding Current", "Current in Amps", ua.DataTypeIDDouble, 0.0),
-		createVar("Voltage", "Voltage", "Arc voltage in Volts", ua.DataTypeIDDouble, 0.0),
-		createVar("WireFeedSpeed", "Wire Feed Speed", "Wire feed in m/min", ua.DataTypeIDDouble, 0.0),
-		createVar("GasFlow", "Gas Flow", "Shielding gas flow l/min", ua.DataTypeIDDouble, 0.0),
-		createVar("TravelSpeed", "Travel Speed", "Travel speed mm/s", ua.DataTypeIDDouble, 0.0),
-		createVar("ArcTime", "Arc Time", "Cumulative arc time seconds", ua.DataTypeIDDouble, 0.0),
-		createVar("Position.X", "Position X", "X position mm", ua.DataTypeIDDouble, 0.0),
-		createVar("Position.Y", "Position Y", "Y position mm", ua.DataTypeIDDouble, 0.0),
-		createVar("Position.Z", "Position Z", "Z position mm", ua.DataTypeIDDouble, 200.0),
-		createVar("TorchAngle", "Torch Angle", "Torch angle degrees", ua.DataTypeIDDouble, 0.0),
-		createVar("State", "State", "Machine state (0-4)", ua.DataTypeIDInt32, int32(0)),
-		createVar("GoodParts", "Good Parts", "Good parts count", ua.DataTypeIDInt32, int32(0)),
-		createVar("ScrapParts", "Scrap Parts", "Scrap parts count", ua.DataTypeIDInt32, int32(0)),
-		createVar("CurrentOrderId", "Current Order ID", "Active order ID", ua.DataTypeIDString, ""),
-		createVar("CurrentPartNumber", "Current Part Number", "Active part number", ua.DataTypeIDString, ""),
-		createVar("CycleProgress", "Cycle Progress", "Progress 0-100%", ua.DataTypeIDDouble, 0.0),
-		createVar("ErrorCode", "Error Code", "Current error code", ua.DataTypeIDString, ""),
-		createVar("ErrorMessage", "Error Message", "Error description", ua.DataTypeIDString, ""),
-	}
 
-	// Register nodes and store references
-	for _, node := range nodes {
+	// Register nodes and store references. Properties follow their variables
+	// so the variables can be browsed to them.
+	for _, node := range append(nodes, properties...) {
 		nm.AddNode(node)
+		if node.Historizing() {
+			s.historian.track(node.NodeID())
+		}
 		// Extract name from NodeID for lookup
 		nodeID := node.NodeID().(ua.NodeIDString)
 		name := strings.TrimPrefix(nodeID.ID, "Robot.")
 		s.varNodes[name] = node
 	}
 
+	s.createProduction(robot, jobs, job)
+	s.createMethods(robot)
+	s.createAlarms(robot, controller)
+	if s.historian != nil {
+		s.advertiseHistory()
+	}
+	if err := s.loadNodeSet(); err != nil {
+		return err
+	}
+
 	log.Info().Int("count", len(nodes)).Msg("OPC UA nodes registered in address space")
 	return nil
 }
 
-func (s *Server) initializeNodeReferences() {
-	// Create string node IDs matching our spec
-	ns := s.namespace
-
-	s.currentNode = ua.NewNodeIDString(ns, "Robot.WeldingCurrent")
-	s.voltageNode = ua.NewNodeIDString(ns, "Robot.Voltage")
-	s.wireFeedNode = ua.NewNodeIDString(ns, "Robot.WireFeedSpeed")
-	s.gasFlowNode = ua.NewNodeIDString(ns, "Robot.GasFlow")
-	s.travelSpeedNode = ua.NewNodeIDString(ns, "Robot.TravelSpeed")
-	s.arcTimeNode = ua.NewNodeIDString(ns, "Robot.ArcTime")
-	s.posXNode = ua.NewNodeIDString(ns, "Robot.Position.X")
-	s.posYNode = ua.NewNodeIDString(ns, "Robot.Position.Y")
-	s.posZNode = ua.NewNodeIDString(ns, "Robot.Position.Z")
-	s.torchAngleNode = ua.NewNodeIDString(ns, "Robot.TorchAngle")
-	s.stateNode = ua.NewNodeIDString(ns, "Robot.State")
-	s.goodPartsNode = ua.NewNodeIDString(ns, "Robot.GoodParts")
-	s.scrapPartsNode = ua.NewNodeIDString(ns, "Robot.ScrapParts")
-	s.orderIdNode = ua.NewNodeIDString(ns, "Robot.CurrentOrderId")
-	s.partNumberNode = ua.NewNodeIDString(ns, "Robot.CurrentPartNumber")
-	s.cycleProgressNode = ua.NewNodeIDString(ns, "Robot.CycleProgress")
-	s.errorCodeNode = ua.NewNodeIDString(ns, "Robot.ErrorCode")
-	s.errorMessageNode = ua.NewNodeIDString(ns, "Robot.ErrorMessage")
-	s.errorTimeNode = ua.NewNodeIDString(ns, "Robot.ErrorTimestamp")
-
-	// Initialize node info map
-	s.nodes["WeldingCurrent"] = &NodeInfo{NodeID: s.currentNode, Name: "WeldingCurrent", Value: 0.0}
-	s.nodes["Voltage"] = &NodeInfo{NodeID: s.voltageNode, Name: "Voltage", Value: 0.0}
-	s.nodes["WireFeedSpeed"] = &NodeInfo{NodeID: s.wireFeedNode, Name: "WireFeedSpeed", Value: 0.0}
-	s.nodes["GasFlow"] = &NodeInfo{NodeID: s.gasFlowNode, Name: "GasFlow", Value: 0.0}
-	s.nodes["TravelSpeed"] = &NodeInfo{NodeID: s.travelSpeedNode, Name: "TravelSpeed", Value: 0.0}
-	s.nodes["ArcTime"] = &NodeInfo{NodeID: s.arcTimeNode, Name: "ArcTime", Value: 0.0}
-	s.nodes["PositionX"] = &NodeInfo{NodeID: s.posXNode, Name: "PositionX", Value: 0.0}
-	s.nodes["PositionY"] = &NodeInfo{NodeID: s.posYNode, Name: "PositionY", Value: 0.0}
-	s.nodes["PositionZ"] = &NodeInfo{NodeID: s.posZNode, Name: "PositionZ", Value: 200.0}
-	s.nodes["TorchAngle"] = &NodeInfo{NodeID: s.torchAngleNode, Name: "TorchAngle", Value: 0.0}
-	s.nodes["State"] = &NodeInfo{NodeID: s.stateNode, Name: "State", Value: int32(0)}
-	s.nodes["GoodParts"] = &NodeInfo{NodeID: s.goodPartsNode, Name: "GoodParts", Value: int32(0)}
-	s.nodes["ScrapParts"] = &NodeInfo{NodeID: s.scrapPartsNode, Name: "ScrapParts", Value: int32(0)}
-	s.nodes["CurrentOrderId"] = &NodeInfo{NodeID: s.orderIdNode, Name: "CurrentOrderId", Value: ""}
-	s.nodes["CurrentPartNumber"] = &NodeInfo{NodeID: s.partNumberNode, Name: "CurrentPartNumber", Value: ""}
-	s.nodes["CycleProgress"] = &NodeInfo{NodeID: s.cycleProgressNode, Name: "CycleProgress", Value: 0.0}
-	s.nodes["ErrorCode"] = &NodeInfo{NodeID: s.errorCodeNode, Name: "ErrorCode", Value: ""}
-	s.nodes["ErrorMessage"] = &NodeInfo{NodeID: s.errorMessageNode, Name: "ErrorMessage", Value: ""}
-	s.nodes["ErrorTimestamp"] = &NodeInfo{NodeID: s.errorTimeNode, Name: "ErrorTimestamp", Value: time.Time{}}
+// createProperty creates a constant property of the node parentID
+func createProperty(s *Server, parentID string, browseName ua.QualifiedName, description string, dataType ua.NodeID, value interface{}) *server.VariableNode {
+	return server.NewVariableNode(
+		s.srv,
+		s.nodeID(parentID+"."+browseName.Name),
+		browseName,
+		ua.LocalizedText{Text: browseName.Name},
+		ua.LocalizedText{Text: description},
+		nil,
+		[]ua.Reference{
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasProperty,
+				IsInverse:       true,
+				TargetID:        ua.ExpandedNodeID{NodeID: s.nodeID(parentID)},
+			},
+			{
+				ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+				TargetID:        ua.ExpandedNodeID{NodeID: ua.VariableTypeIDPropertyType},
+			},
+		},
+		ua.NewDataValue(value, 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+		dataType,
+		ua.ValueRankScalar,
+		[]uint32{},
+		ua.AccessLevelsCurrentRead,
+		0,
+		false,
+		nil,
+	)
 }
 
-// setNodeValue sets the value of an OPC UA variable node
-func (s *Server) setNodeValue(name string, value interface{}, timestamp time.Time) {
+// setNodeValue sets the value and status of an OPC UA variable node
+func (s *Server) setNodeValue(name string, value interface{}, status ua.StatusCode, timestamp time.Time) {
 	if node, ok := s.varNodes[name]; ok {
-		node.SetValue(ua.NewDataValue(value, 0, timestamp, 0, timestamp, 0))
-	}
-}
-
-// UpdateValues updates all OPC UA node values from timeseries data
-func (s *Server) UpdateValues(data *simulator.TimeseriesData) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Update stored values (keep for fallback/local access)
-	s.nodes["WeldingCurrent"].Value = data.WeldingCurrent
-	s.nodes["Voltage"].Value = data.Voltage
-	s.nodes["WireFeedSpeed"].Value = data.WireFeedSpeed
-	s.nodes["GasFlow"].Value = data.GasFlow
-	s.nodes["TravelSpeed"].Value = data.TravelSpeed
-	s.nodes["ArcTime"].Value = data.ArcTime
-	s.nodes["PositionX"].Value = data.PositionX
-	s.nodes["PositionY"].Value = data.PositionY
-	s.nodes["PositionZ"].Value = data.PositionZ
-	s.nodes["TorchAngle"].Value = data.TorchAngle
-	s.nodes["State"].Value = int32(data.State)
-	s.nodes["GoodParts"].Value = int32(data.GoodParts)
-	s.nodes["ScrapParts"].Value = int32(data.ScrapParts)
-	s.nodes["CurrentOrderId"].Value = data.CurrentOrderID
-	s.nodes["CurrentPartNumber"].Value = data.CurrentPartNumber
-	s.nodes["CycleProgress"].Value = data.CycleProgress
-	s.nodes["ErrorCode"].Value = data.ErrorCode
-	s.nodes["ErrorMessage"].Value = data.ErrorMessage
-	s.nodes["ErrorTimestamp"].Value = data.ErrorTimestamp
-
-	// Update OPC UA server nodes (if server is running)
-	if s.srv != nil && len(s.varNodes) > 0 {
-		now := time.Now().UTC()
-
-		s.setNodeValue("WeldingCurrent", data.WeldingCurrent, now)
-		s.setNodeValue("Voltage", data.Voltage, now)
-		s.setNodeValue("WireFeedSpeed", data.WireFeedSpeed, now)
-		s.setNodeValue("GasFlow", data.GasFlow, now)
-		s.setNodeValue("TravelSpeed", data.TravelSpeed, now)
-		s.setNodeValue("ArcTime", data.ArcTime, now)
-		s.setNodeValue("Position.X", data.PositionX, now)
-		s.setNodeValue("Position.Y", data.PositionY, now)
-		s.setNodeValue("Position.Z", data.PositionZ, now)
-		s.setNodeValue("TorchAngle", data.TorchAngle, now)
-		s.setNodeValue("State", int32(data.State), now)
-		s.setNodeValue("GoodParts", int32(data.GoodParts), now)
-		s.setNodeValue("ScrapParts", int32(data.ScrapParts), now)
-		s.setNodeValue("CurrentOrderId", data.CurrentOrderID, now)
-		s.setNodeValue("CurrentPartNumber", data.CurrentPartNumber, now)
-		s.setNodeValue("CycleProgress", data.CycleProgress, now)
-		s.setNodeValue("ErrorCode", data.ErrorCode, now)
-		s.setNodeValue("ErrorMessage", data.ErrorMessage, now)
-	}
-}
-
-// GetNodeValue returns the current value of a node
-func (s *Server) GetNodeValue(name string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if node, ok := s.nodes[name]; ok {
-		return node.Value, true
-	}
-	return nil, false
-}
-
-// GetAllValues returns all current node values as a map
-func (s *Server) GetAllValues() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	values := make(map[string]interface{})
-	for name, node := range s.nodes {
-		values[name] = node.Value
+		node.SetValue(ua.NewDataValue(value, status, timestamp, 0, timestamp, 0))
 	}
-	return values
 }
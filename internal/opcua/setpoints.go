@@ -0,0 +1,54 @@
+package opcua
+
+import (
+	"time"
+
+	"github.com/awcullen/opcua/ua"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// unitsNamespace is the namespace of the UNECE unit codes used as
+// EngineeringUnits
+const unitsNamespace = "http://www.opcfoundation.org/UA/units/un/cefact"
+
+// unit returns the EUInformation of a unit, identified by its UNECE code
+func unit(u *simulator.Unit) ua.EUInformation {
+	var id int32
+	for _, c := range u.Code {
+		id = id<<8 | int32(c)
+	}
+	return ua.EUInformation{
+		NamespaceURI: unitsNamespace,
+		UnitID:       id,
+		DisplayName:  ua.LocalizedText{Text: u.Symbol},
+		Description:  ua.LocalizedText{Text: u.Name},
+	}
+}
+
+// writeSetpoint validates a write against the engineering range and applies
+// it to the generator in the simulation loop
+func (s *Server) writeSetpoint(sig *simulator.Signal, req ua.WriteValue) (ua.DataValue, ua.StatusCode) {
+	if req.IndexRange != "" {
+		return ua.DataValue{}, ua.BadIndexRangeInvalid
+	}
+	value, ok := req.Value.Value.(float64)
+	if !ok {
+		return ua.DataValue{}, ua.BadTypeMismatch
+	}
+	if !sig.Range.Contains(value) {
+		log.Debug().Str("setpoint", sig.Name).Float64("value", value).Msg("OPC UA write out of range")
+		return ua.DataValue{}, ua.BadOutOfRange
+	}
+
+	if !s.execute(func(now time.Time) {
+		sig.Set(s.generator, value)
+	}) {
+		return ua.DataValue{}, ua.BadTimeout
+	}
+	log.Info().Str("setpoint", sig.Name).Float64("value", value).Msg("Setpoint changed via OPC UA")
+
+	now := time.Now().UTC()
+	return ua.NewDataValue(value, ua.Good, now, 0, now, 0), ua.Good
+}
@@ -0,0 +1,168 @@
+package opcua
+
+import (
+	"time"
+
+	"github.com/awcullen/opcua/server"
+	"github.com/awcullen/opcua/ua"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/simulator"
+)
+
+// dataTypes are the OPC UA data types of the signal types
+var dataTypes = map[simulator.SignalType]ua.NodeID{
+	simulator.TypeDouble:      ua.DataTypeIDDouble,
+	simulator.TypeInt32:       ua.DataTypeIDInt32,
+	simulator.TypeString:      ua.DataTypeIDString,
+	simulator.TypeDateTime:    ua.DataTypeIDDateTime,
+	simulator.TypeDoubleArray: ua.DataTypeIDDouble,
+}
+
+// createSignals creates a variable for every signal of the registry below the
+// object of its component. Signals with a range are AnalogItems, those with
+// only a unit BaseAnalog variables.
+func (s *Server) createSignals(components map[simulator.Component]objectRef) (variables, properties []*server.VariableNode) {
+	historyAccess, historizing, historian := s.historized()
+	for _, sig := range simulator.Signals {
+		if sig.HighRate && !s.cfg.HighRateMode {
+			continue
+		}
+		sig := sig
+		name := "Robot." + sig.Name
+		parent := components[sig.Component]
+
+		typeDefinition := ua.VariableTypeIDBaseDataVariableType
+		switch {
+		case sig.Range != nil:
+			typeDefinition = ua.VariableTypeIDAnalogItemType
+		case sig.Unit != nil:
+			typeDefinition = ua.VariableTypeIDBaseAnalogType
+		}
+
+		valueRank, arrayDimensions := int32(ua.ValueRankScalar), []uint32{}
+		accessLevel := ua.AccessLevelsCurrentRead
+		signalHistorizing, signalHistorian := historizing, historian
+		if sig.Type == simulator.TypeDoubleArray {
+			// Waveforms are replaced every tick and not historized
+			valueRank, arrayDimensions = ua.ValueRankOneDimension, []uint32{0}
+			signalHistorizing, signalHistorian = false, nil
+		} else {
+			accessLevel |= historyAccess
+		}
+		var permissions []ua.RolePermissionType
+		if sig.Set != nil {
+			accessLevel |= ua.AccessLevelsCurrentWrite
+			permissions = clientPermissions(ua.PermissionTypeWrite)
+		}
+
+		node := server.NewVariableNode(
+			s.srv,
+			s.nodeID(name),
+			s.browseName(sig.Name),
+			ua.LocalizedText{Text: sig.DisplayName},
+			ua.LocalizedText{Text: sig.Description},
+			permissions,
+			[]ua.Reference{
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasComponent,
+					IsInverse:       true,
+					TargetID:        ua.ExpandedNodeID{NodeID: parent.id},
+				},
+				{
+					ReferenceTypeID: ua.ReferenceTypeIDHasTypeDefinition,
+					TargetID:        ua.ExpandedNodeID{NodeID: typeDefinition},
+				},
+			},
+			ua.NewDataValue(sig.Initial(), 0, time.Now().UTC(), 0, time.Now().UTC(), 0),
+			dataTypes[sig.Type],
+			valueRank,
+			arrayDimensions,
+			accessLevel,
+			250.0,
+			signalHistorizing,
+			signalHistorian,
+		)
+		if sig.Set != nil {
+			node.SetWriteValueHandler(func(session *server.Session, req ua.WriteValue) (ua.DataValue, ua.StatusCode) {
+				return s.writeSetpoint(sig, req)
+			})
+		}
+
+		// High-rate signals only exist in high-rate mode
+		modellingRule := ua.ObjectIDModellingRuleMandatory
+		if sig.HighRate {
+			modellingRule = ua.ObjectIDModellingRuleOptional
+		}
+		s.declare(parent, node, modellingRule)
+		variables = append(variables, node)
+
+		if sig.Range != nil {
+			properties = append(properties, createProperty(s, name, ua.QualifiedName{Name: "EURange"}, "Engineering range of the value",
+				ua.DataTypeIDRange, ua.Range{Low: sig.Range.Min, High: sig.Range.Max}))
+		}
+		if sig.Unit != nil {
+			properties = append(properties, createProperty(s, name, ua.QualifiedName{Name: "EngineeringUnits"}, "Unit of the value",
+				ua.DataTypeIDEUInformation, unit(sig.Unit)))
+		}
+		if sig.HighRate {
+			properties = append(properties, createProperty(s, name, s.browseName("SampleRate"), "Sample rate in Hz",
+				ua.DataTypeIDDouble, float64(s.cfg.WaveformSampleRate)))
+		}
+	}
+	return variables, properties
+}
+
+// statusOf returns the OPC UA status code of a sensor quality
+func statusOf(quality simulator.SensorQuality) ua.StatusCode {
+	switch quality {
+	case simulator.QualityUncertainLastUsable:
+		return ua.UncertainLastUsableValue
+	case simulator.QualityBadSensorFailure:
+		return ua.BadSensorFailure
+	case simulator.QualityBadCommunication:
+		return ua.BadCommunicationError
+	default:
+		return ua.Good
+	}
+}
+
+// Publish updates the variables of the signals and the MachineryItemState
+// derived from the machine state. It is only called in the simulation loop.
+func (s *Server) Publish(timestamp time.Time, samples []simulator.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestamp = timestamp.UTC()
+	for _, sample := range samples {
+		s.values[sample.Signal.Name] = sample.Value
+		s.setNodeValue(sample.Signal.Name, sample.Value, statusOf(sample.Quality), timestamp)
+
+		if sample.Signal.Name == "State" {
+			itemState := machineryItemState(simulator.MachineState(sample.Value.(int32)))
+			s.values["MachineryItemState"] = itemState
+			s.setNodeValue("MachineryItemState.CurrentState", ua.LocalizedText{Text: itemState}, ua.Good, timestamp)
+			s.setNodeValue("MachineryItemState.CurrentState.Id", s.itemStates[itemState], ua.Good, timestamp)
+		}
+	}
+}
+
+// GetNodeValue returns the last published value of a signal
+func (s *Server) GetNodeValue(name string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// GetAllValues returns the last published values of all signals
+func (s *Server) GetAllValues() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make(map[string]interface{}, len(s.values))
+	for name, value := range s.values {
+		values[name] = value
+	}
+	return values
+}
@@ -0,0 +1,275 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// EffectType is what a cascade does to the machine
+type EffectType string
+
+const (
+	EffectScrap    EffectType = "scrap"    // Raises the scrap probability
+	EffectError    EffectType = "error"    // Makes another error more likely
+	EffectPosition EffectType = "position" // Degrades positional accuracy
+)
+
+// Cascade is a causal link from an error to a follow-on effect
+type Cascade struct {
+	Cause  ErrorCode     // Error that starts the effect
+	Count  int           // Occurrences of Cause within Window needed
+	Window time.Duration // Only used when Count is above 1
+
+	Effect   EffectType
+	Duration time.Duration // How long the effect lasts
+	Reason   string        // Physical cause shown in the event log
+
+	// Scrap effects: added scrap probability per part. Error effects:
+	// probability of Code per cycle.
+	Probability float64
+	Code        ErrorCode
+
+	// Position effects: multiplier of the position deviation
+	Factor float64
+}
+
+// defaultCascades are the built-in fault chains
+func defaultCascades() []Cascade {
+	return []Cascade{
+		// Air drawn into the shielding gas leaves pores in the next welds
+		{Cause: ErrorGasFlowFault, Count: 1, Effect: EffectScrap, Probability: 0.15, Duration: 30 * time.Minute, Reason: "Porosity"},
+		// Repeated arc faults point to a worn contact tip that soon jams the wire
+		{Cause: ErrorArcFault, Count: 2, Window: time.Hour, Effect: EffectError, Code: ErrorWireFeedJam, Probability: 0.05, Duration: time.Hour, Reason: "Contact tip wear"},
+		// Recalibration after a collision leaves a residual tool center point error
+		{Cause: ErrorRobotCollision, Count: 1, Effect: EffectPosition, Factor: 3, Duration: 8 * time.Hour, Reason: "Tool center point offset"},
+	}
+}
+
+func (c Cascade) String() string {
+	switch c.Effect {
+	case EffectScrap:
+		return fmt.Sprintf("%s: scrap probability +%g%% for %s", c.Reason, c.Probability*100, c.Duration)
+	case EffectError:
+		return fmt.Sprintf("%s: %s probability %g%% per cycle for %s", c.Reason, c.Code, c.Probability*100, c.Duration)
+	case EffectPosition:
+		return fmt.Sprintf("%s: position deviation x%g for %s", c.Reason, c.Factor, c.Duration)
+	default:
+		return c.Reason
+	}
+}
+
+// activeEffect is a cascade in effect
+type activeEffect struct {
+	cascade Cascade
+	until   time.Time
+	eventID int64 // Event that started the effect
+}
+
+// faultRecord is a past error, kept to count repeated faults
+type faultRecord struct {
+	code    ErrorCode
+	at      time.Time
+	eventID int64
+}
+
+// startCascades records an error and starts the effects it causes
+func (sm *StateMachine) startCascades(code ErrorCode, eventID int64, now time.Time) {
+	sm.faults = append(sm.faults, faultRecord{code: code, at: now, eventID: eventID})
+
+	// Forget faults older than the longest window
+	var window time.Duration
+	for _, c := range sm.errorCatalog.cascades {
+		if c.Window > window {
+			window = c.Window
+		}
+	}
+	for len(sm.faults) > 0 && sm.faults[0].at.Before(now.Add(-window)) {
+		sm.faults = sm.faults[1:]
+	}
+
+	for _, c := range sm.errorCatalog.cascades {
+		if c.Cause != code {
+			continue
+		}
+
+		causes := []int64{eventID}
+		if c.Count > 1 {
+			causes = causes[:0]
+			for _, f := range sm.faults {
+				if f.code == code && !f.at.Before(now.Add(-c.Window)) {
+					causes = append(causes, f.eventID)
+				}
+			}
+			if len(causes) < c.Count {
+				continue
+			}
+		}
+
+		effectID := sm.events.Add(Event{
+			Time:     now,
+			Type:     EventEffect,
+			Code:     code,
+			Message:  c.String(),
+			CausedBy: causes,
+		})
+		effect := activeEffect{cascade: c, until: now.Add(c.Duration), eventID: effectID}
+
+		// A repeated cause renews the running effect
+		renewed := false
+		for i := range sm.effects {
+			if sm.effects[i].cascade == c {
+				sm.effects[i] = effect
+				renewed = true
+			}
+		}
+		if !renewed {
+			sm.effects = append(sm.effects, effect)
+		}
+	}
+}
+
+// expireEffects ends the effects that have worn off
+func (sm *StateMachine) expireEffects(now time.Time) {
+	active := sm.effects[:0]
+	for _, e := range sm.effects {
+		if now.Before(e.until) {
+			active = append(active, e)
+			continue
+		}
+		sm.events.Add(Event{
+			Time:     now,
+			Type:     EventEffectEnd,
+			Code:     e.cascade.Cause,
+			Message:  e.cascade.Reason + " no longer in effect",
+			CausedBy: []int64{e.eventID},
+		})
+	}
+	sm.effects = active
+}
+
+// drawScrap decides whether a finished part is scrap. If an active effect
+// caused it, that effect is returned as well.
+func (sm *StateMachine) drawScrap() (bool, *activeEffect) {
+	r := sm.rng.Float64()
+	if r < sm.cfg.ScrapRate {
+		return true, nil
+	}
+	r -= sm.cfg.ScrapRate
+
+	for i := range sm.effects {
+		e := &sm.effects[i]
+		if e.cascade.Effect != EffectScrap {
+			continue
+		}
+		if r < e.cascade.Probability {
+			return true, e
+		}
+		r -= e.cascade.Probability
+	}
+	return false, nil
+}
+
+// triggerCascadedError raises errors made more likely by active effects. It
+// reports whether an error was raised.
+func (sm *StateMachine) triggerCascadedError(now time.Time) bool {
+	for _, e := range sm.effects {
+		if e.cascade.Effect == EffectError && sm.rng.Float64() < e.cascade.Probability {
+			sm.raiseError(e.cascade.Code, now, []int64{e.eventID})
+			return true
+		}
+	}
+	return false
+}
+
+// PositionDegradation returns the multiplier of the position deviation, 1
+// while the robot is accurately calibrated
+func (sm *StateMachine) PositionDegradation() float64 {
+	factor := 1.0
+	for _, e := range sm.effects {
+		if e.cascade.Effect == EffectPosition && e.cascade.Factor > factor {
+			factor = e.cascade.Factor
+		}
+	}
+	return factor
+}
+
+// Events returns the logged events with an ID greater than id
+func (sm *StateMachine) Events(since int64) []Event {
+	return sm.events.Since(since)
+}
+
+type cascadeFile struct {
+	Cause       string          `yaml:"cause" json:"cause"`
+	Count       int             `yaml:"count" json:"count"`
+	Window      config.Duration `yaml:"window" json:"window"`
+	Effect      string          `yaml:"effect" json:"effect"`
+	Duration    config.Duration `yaml:"duration" json:"duration"`
+	Reason      string          `yaml:"reason" json:"reason"`
+	Probability float64         `yaml:"probability" json:"probability"`
+	Code        string          `yaml:"code" json:"code"`
+	Factor      float64         `yaml:"factor" json:"factor"`
+}
+
+// parseCascades converts and validates the cascades of an error catalog file
+func parseCascades(files []cascadeFile, catalog *ErrorCatalog, fail func(format string, args ...interface{})) []Cascade {
+	cascades := make([]Cascade, 0, len(files))
+	for i, cf := range files {
+		c := Cascade{
+			Cause:       ErrorCode(strings.TrimSpace(cf.Cause)),
+			Count:       cf.Count,
+			Window:      time.Duration(cf.Window),
+			Effect:      EffectType(cf.Effect),
+			Duration:    time.Duration(cf.Duration),
+			Reason:      cf.Reason,
+			Probability: cf.Probability,
+			Code:        ErrorCode(strings.TrimSpace(cf.Code)),
+			Factor:      cf.Factor,
+		}
+		if c.Count == 0 {
+			c.Count = 1
+		}
+
+		cause, ok := catalog.Lookup(c.Cause)
+		if !ok {
+			fail("cascades[%d].cause: unknown error code %q", i, c.Cause)
+		}
+		if c.Reason == "" {
+			c.Reason = cause.Message
+		}
+		if c.Count < 1 {
+			fail("cascades[%d].count must be at least 1, got %d", i, c.Count)
+		}
+		if c.Count > 1 && c.Window <= 0 {
+			fail("cascades[%d].window must be positive when count is above 1, got %s", i, c.Window)
+		}
+		if c.Duration <= 0 {
+			fail("cascades[%d].duration must be positive, got %s", i, c.Duration)
+		}
+
+		switch c.Effect {
+		case EffectScrap:
+			if c.Probability <= 0 || c.Probability > 1 {
+				fail("cascades[%d].probability must be above 0 and at most 1, got %g", i, c.Probability)
+			}
+		case EffectError:
+			if _, ok := catalog.Lookup(c.Code); !ok {
+				fail("cascades[%d].code: unknown error code %q", i, c.Code)
+			}
+			if c.Probability <= 0 || c.Probability > 1 {
+				fail("cascades[%d].probability must be above 0 and at most 1, got %g", i, c.Probability)
+			}
+		case EffectPosition:
+			if c.Factor < 1 {
+				fail("cascades[%d].factor must be at least 1, got %g", i, c.Factor)
+			}
+		default:
+			fail("cascades[%d].effect must be %s, %s or %s, got %q", i, EffectScrap, EffectError, EffectPosition, c.Effect)
+		}
+
+		cascades = append(cascades, c)
+	}
+	return cascades
+}
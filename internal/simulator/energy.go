@@ -0,0 +1,115 @@
+package simulator
+
+import (
+	"time"
+)
+
+// UtilityTotals holds accumulated utility consumption
+type UtilityTotals struct {
+	EnergyKWh float64 `json:"energyKWh"`
+	GasLiters float64 `json:"gasLiters"`
+	AirLiters float64 `json:"airLiters"`
+}
+
+func (u *UtilityTotals) add(other UtilityTotals) {
+	u.EnergyKWh += other.EnergyKWh
+	u.GasLiters += other.GasLiters
+	u.AirLiters += other.AirLiters
+}
+
+// baseLoadKW is the electrical load of robot, controller and peripherals per state
+var baseLoadKW = map[MachineState]float64{
+	StateIdle:          1.2, // Controller, cooling unit and power source on standby
+	StateSetup:         2.8, // Robot axes moving, fixture clamping
+	StateRunning:       3.2, // Robot axes, wire feeder and cooling unit
+	StatePlannedStop:   0.8, // Servo drives off, controller on
+	StateUnplannedStop: 1.0, // Controller on, service lighting
+	StateNotScheduled:  0.3, // Controller in energy-saving standby
+}
+
+// airFlowLPM is the compressed air consumption in l/min per state
+var airFlowLPM = map[MachineState]float64{
+	StateIdle:          4.0,  // Leakage
+	StateSetup:         60.0, // Clamping and torch cleaning
+	StateRunning:       25.0, // Fixture clamps and torch cooling blow-off
+	StatePlannedStop:   4.0,
+	StateUnplannedStop: 4.0,
+	StateNotScheduled:  0.0, // Shut-off valve closed
+}
+
+// powerSourceEfficiency converts arc power to electrical input power
+const powerSourceEfficiency = 0.85
+
+// EnergyModel computes electrical power and utility consumption and rolls it
+// up per part, order and shift
+type EnergyModel struct {
+	arcPower float64 // kW
+	power    float64 // kW
+	total    UtilityTotals
+	part     UtilityTotals
+	lastPart UtilityTotals
+	order    UtilityTotals
+	shift    UtilityTotals
+}
+
+// NewEnergyModel creates a new energy model
+func NewEnergyModel() *EnergyModel {
+	return &EnergyModel{}
+}
+
+// Update accumulates consumption for one publish interval of the machine
+// described by data
+func (em *EnergyModel) Update(data *TimeseriesData, interval time.Duration) {
+	em.arcPower = data.WeldingCurrent * data.Voltage / 1000
+	em.power = baseLoadKW[data.State] + em.arcPower/powerSourceEfficiency
+
+	delta := UtilityTotals{
+		EnergyKWh: em.power * interval.Hours(),
+		GasLiters: data.GasFlow * interval.Minutes(),
+		AirLiters: airFlowLPM[data.State] * interval.Minutes(),
+	}
+	em.total.add(delta)
+	em.part.add(delta)
+	em.order.add(delta)
+	em.shift.add(delta)
+}
+
+// Apply fills the energy fields of data
+func (em *EnergyModel) Apply(data *TimeseriesData) {
+	data.ArcPower = em.arcPower
+	data.Power = em.power
+	data.EnergyTotal = em.total.EnergyKWh
+	data.GasTotal = em.total.GasLiters
+	data.AirTotal = em.total.AirLiters
+	data.LastPartUtilities = em.lastPart
+	data.OrderUtilities = em.order
+	data.ShiftUtilities = em.shift
+}
+
+// CompletePart closes the consumption of the current part and returns it
+func (em *EnergyModel) CompletePart() UtilityTotals {
+	em.lastPart = em.part
+	em.part = UtilityTotals{}
+	return em.lastPart
+}
+
+// AbortPart drops the consumption of an unfinished part. It stays in the
+// order and shift totals, but is not reported as the last part.
+func (em *EnergyModel) AbortPart() {
+	em.part = UtilityTotals{}
+}
+
+// OrderTotals returns the consumption of the current order so far
+func (em *EnergyModel) OrderTotals() UtilityTotals {
+	return em.order
+}
+
+// ResetOrder starts accumulating consumption for a new order
+func (em *EnergyModel) ResetOrder() {
+	em.order = UtilityTotals{}
+}
+
+// ResetShift starts accumulating consumption for a new shift
+func (em *EnergyModel) ResetShift() {
+	em.shift = UtilityTotals{}
+}
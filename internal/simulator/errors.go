@@ -0,0 +1,264 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// ErrorDefinition describes an error the machine can raise
+type ErrorDefinition struct {
+	Code        ErrorCode
+	Message     string
+	MinDuration time.Duration // Shortest repair time
+	MaxDuration time.Duration // Longest repair time
+
+	// Relative probability among random errors; 0 for errors that are only
+	// raised by the process models or the control API
+	Probability float64
+
+	// OPC UA severity from 1 (lowest) to 1000 (highest)
+	Severity int
+
+	// Steps after the repair, e.g. a recalibration, and how the order
+	// continues afterwards
+	Recovery []RecoveryStep
+	Resume   ResumeMode
+}
+
+// RecoveryStep is a step between the repair of an error and production
+type RecoveryStep struct {
+	Name        string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+}
+
+// ResumeMode selects how production continues after an error
+type ResumeMode string
+
+const (
+	ResumeSetup   ResumeMode = "setup"   // Set up the order again
+	ResumeRunning ResumeMode = "running" // Continue welding without a setup
+)
+
+// ErrorCatalog holds the errors known to the simulator and the fault chains
+// between them
+type ErrorCatalog struct {
+	codes    []ErrorCode // In definition order, for reproducible selection
+	defs     map[ErrorCode]ErrorDefinition
+	cascades []Cascade
+}
+
+// DefaultErrorCatalog returns the built-in errors
+func DefaultErrorCatalog() *ErrorCatalog {
+	c := &ErrorCatalog{
+		defs:     make(map[ErrorCode]ErrorDefinition),
+		cascades: defaultCascades(),
+	}
+	for _, def := range []ErrorDefinition{
+		{
+			Code: ErrorWireFeedJam, Message: "Wire feed jam detected", MinDuration: 5 * time.Minute, MaxDuration: 10 * time.Minute, Probability: 1, Severity: 600,
+			// Clearing the jam only needs a short restart of the wire feeder
+			Recovery: []RecoveryStep{{Name: "Restart", MinDuration: 30 * time.Second, MaxDuration: 1 * time.Minute}},
+			Resume:   ResumeRunning,
+		},
+		{Code: ErrorGasFlowFault, Message: "Gas flow fault", MinDuration: 2 * time.Minute, MaxDuration: 5 * time.Minute, Probability: 1, Severity: 500, Resume: ResumeSetup},
+		{Code: ErrorArcFault, Message: "Arc fault detected", MinDuration: 1 * time.Minute, MaxDuration: 3 * time.Minute, Probability: 1, Severity: 500, Resume: ResumeSetup},
+		{
+			Code: ErrorRobotCollision, Message: "Robot collision detected", MinDuration: 15 * time.Minute, MaxDuration: 30 * time.Minute, Probability: 1, Severity: 900,
+			// The tool center point has to be recalibrated before a new setup
+			Recovery: []RecoveryStep{{Name: "Recalibration", MinDuration: 10 * time.Minute, MaxDuration: 20 * time.Minute}},
+			Resume:   ResumeSetup,
+		},
+		{
+			Code: ErrorQualityReject, Message: "Quality reject", MinDuration: 1 * time.Minute, MaxDuration: 2 * time.Minute, Probability: 1, Severity: 300,
+			// Parts welded before the reject are inspected, then welding continues
+			Recovery: []RecoveryStep{{Name: "Inspection", MinDuration: 3 * time.Minute, MaxDuration: 8 * time.Minute}},
+			Resume:   ResumeRunning,
+		},
+		{Code: ErrorTorchOverheat, Message: "Torch overheating - duty cycle limit exceeded", MinDuration: 10 * time.Minute, MaxDuration: 20 * time.Minute, Probability: 0, Severity: 700, Resume: ResumeSetup},
+	} {
+		c.add(def)
+	}
+	return c
+}
+
+func (c *ErrorCatalog) add(def ErrorDefinition) {
+	if _, ok := c.defs[def.Code]; !ok {
+		c.codes = append(c.codes, def.Code)
+	}
+	c.defs[def.Code] = def
+}
+
+// Lookup returns the definition of an error code
+func (c *ErrorCatalog) Lookup(code ErrorCode) (ErrorDefinition, bool) {
+	def, ok := c.defs[code]
+	return def, ok
+}
+
+// Cascades returns the fault chains
+func (c *ErrorCatalog) Cascades() []Cascade {
+	return c.cascades
+}
+
+// Definitions returns all errors in definition order
+func (c *ErrorCatalog) Definitions() []ErrorDefinition {
+	defs := make([]ErrorDefinition, 0, len(c.codes))
+	for _, code := range c.codes {
+		defs = append(defs, c.defs[code])
+	}
+	return defs
+}
+
+// pick selects a random error weighted by probability. It reports false if
+// no error has a probability.
+func (c *ErrorCatalog) pick(rng *rand.Rand) (ErrorCode, bool) {
+	var total float64
+	for _, code := range c.codes {
+		total += c.defs[code].Probability
+	}
+	if total <= 0 {
+		return ErrorNone, false
+	}
+
+	r := rng.Float64() * total
+	for _, code := range c.codes {
+		p := c.defs[code].Probability
+		if p > 0 && r < p {
+			return code, true
+		}
+		r -= p
+	}
+	// Rounding left r at the upper bound; take the last candidate
+	for i := len(c.codes) - 1; i >= 0; i-- {
+		if c.defs[c.codes[i]].Probability > 0 {
+			return c.codes[i], true
+		}
+	}
+	return ErrorNone, false
+}
+
+// errorCatalogFile is the YAML/JSON layout of an error catalog file
+type errorCatalogFile struct {
+	Errors   []errorFile    `yaml:"errors" json:"errors"`
+	Cascades *[]cascadeFile `yaml:"cascades" json:"cascades"`
+}
+
+type errorFile struct {
+	Code        string          `yaml:"code" json:"code"`
+	Message     string          `yaml:"message" json:"message"`
+	MinDuration config.Duration `yaml:"minDuration" json:"minDuration"`
+	MaxDuration config.Duration `yaml:"maxDuration" json:"maxDuration"`
+	Probability *float64        `yaml:"probability" json:"probability"`
+	Severity    *int            `yaml:"severity" json:"severity"`
+	Recovery    []recoveryFile  `yaml:"recovery" json:"recovery"`
+	Resume      string          `yaml:"resume" json:"resume"`
+}
+
+type recoveryFile struct {
+	Step        string          `yaml:"step" json:"step"`
+	MinDuration config.Duration `yaml:"minDuration" json:"minDuration"`
+	MaxDuration config.Duration `yaml:"maxDuration" json:"maxDuration"`
+}
+
+// LoadErrorCatalog reads error definitions from a YAML or JSON file on top
+// of the built-in errors. An entry with a built-in code replaces it. Cascades
+// replace the built-in fault chains when present. Without a path the built-in
+// errors are returned.
+func LoadErrorCatalog(path string) (*ErrorCatalog, error) {
+	catalog := DefaultErrorCatalog()
+	if path == "" {
+		return catalog, nil
+	}
+
+	var file errorCatalogFile
+	if err := config.DecodeFile(path, &file); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	seen := make(map[ErrorCode]bool)
+	for i, ef := range file.Errors {
+		def := ErrorDefinition{
+			Code:        ErrorCode(strings.TrimSpace(ef.Code)),
+			Message:     ef.Message,
+			MinDuration: time.Duration(ef.MinDuration),
+			MaxDuration: time.Duration(ef.MaxDuration),
+			Probability: 1,
+			Severity:    500,
+			Resume:      ResumeMode(ef.Resume),
+		}
+		if def.Resume == "" {
+			def.Resume = ResumeSetup
+		}
+		if ef.Probability != nil {
+			def.Probability = *ef.Probability
+		}
+		if ef.Severity != nil {
+			def.Severity = *ef.Severity
+		}
+
+		if def.Code == ErrorNone {
+			fail("errors[%d].code must not be empty", i)
+			continue
+		}
+		if seen[def.Code] {
+			fail("errors[%d].code %q is defined twice", i, def.Code)
+		}
+		seen[def.Code] = true
+		if strings.TrimSpace(def.Message) == "" {
+			fail("errors[%d] %s: message must not be empty", i, def.Code)
+		}
+		if def.MinDuration <= 0 {
+			fail("errors[%d] %s: minDuration must be positive, got %s", i, def.Code, def.MinDuration)
+		}
+		if def.MaxDuration < def.MinDuration {
+			fail("errors[%d] %s: maxDuration must be at least minDuration %s, got %s", i, def.Code, def.MinDuration, def.MaxDuration)
+		}
+		if def.Probability < 0 {
+			fail("errors[%d] %s: probability must not be negative, got %g", i, def.Code, def.Probability)
+		}
+		if def.Severity < 1 || def.Severity > 1000 {
+			fail("errors[%d] %s: severity must be between 1 and 1000, got %d", i, def.Code, def.Severity)
+		}
+		if def.Resume != ResumeSetup && def.Resume != ResumeRunning {
+			fail("errors[%d] %s: resume must be %s or %s, got %q", i, def.Code, ResumeSetup, ResumeRunning, def.Resume)
+		}
+		for j, rf := range ef.Recovery {
+			step := RecoveryStep{
+				Name:        rf.Step,
+				MinDuration: time.Duration(rf.MinDuration),
+				MaxDuration: time.Duration(rf.MaxDuration),
+			}
+			if strings.TrimSpace(step.Name) == "" {
+				fail("errors[%d] %s: recovery[%d].step must not be empty", i, def.Code, j)
+			}
+			if step.MinDuration <= 0 {
+				fail("errors[%d] %s: recovery[%d].minDuration must be positive, got %s", i, def.Code, j, step.MinDuration)
+			}
+			if step.MaxDuration < step.MinDuration {
+				fail("errors[%d] %s: recovery[%d].maxDuration must be at least minDuration %s, got %s",
+					i, def.Code, j, step.MinDuration, step.MaxDuration)
+			}
+			def.Recovery = append(def.Recovery, step)
+		}
+		catalog.add(def)
+	}
+
+	if file.Cascades != nil {
+		catalog.cascades = parseCascades(*file.Cascades, catalog, fail)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid error catalog %s:\n%w", path, errors.Join(errs...))
+	}
+	return catalog, nil
+}
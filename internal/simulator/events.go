@@ -0,0 +1,63 @@
+package simulator
+
+import "time"
+
+// eventLogSize is the number of events kept in the event log
+const eventLogSize = 1000
+
+// EventType classifies entries of the event log
+type EventType string
+
+const (
+	EventError     EventType = "error"     // An error stopped the machine
+	EventRecovery  EventType = "recovery"  // A recovery step started
+	EventEffect    EventType = "effect"    // A fault started a follow-on effect
+	EventEffectEnd EventType = "effectEnd" // A follow-on effect wore off
+	EventScrap     EventType = "scrap"     // A part was scrapped
+)
+
+// Event is an entry of the event log. CausedBy lists the IDs of the events
+// that led to it, so fault chains can be followed back to their root cause.
+type Event struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	Code     ErrorCode `json:"code,omitempty"`
+	OrderID  string    `json:"orderId,omitempty"`
+	Message  string    `json:"message"`
+	CausedBy []int64   `json:"causedBy,omitempty"`
+}
+
+// EventLog keeps the most recent events in order
+type EventLog struct {
+	events []Event
+	nextID int64
+}
+
+// NewEventLog creates an empty event log
+func NewEventLog() *EventLog {
+	return &EventLog{nextID: 1}
+}
+
+// Add appends an event, assigning its ID, and returns the ID
+func (l *EventLog) Add(e Event) int64 {
+	e.ID = l.nextID
+	l.nextID++
+
+	if len(l.events) == eventLogSize {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:eventLogSize-1]
+	}
+	l.events = append(l.events, e)
+	return e.ID
+}
+
+// Since returns the events with an ID greater than id, oldest first
+func (l *EventLog) Since(id int64) []Event {
+	for i, e := range l.events {
+		if e.ID > id {
+			return append([]Event(nil), l.events[i:]...)
+		}
+	}
+	return []Event{}
+}
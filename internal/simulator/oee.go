@@ -0,0 +1,77 @@
+package simulator
+
+import (
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// OEETracker accumulates availability, performance and quality for the
+// current shift. Unscheduled time and planned stops are not part of the
+// planned production time, so they do not lower availability.
+type OEETracker struct {
+	cfg *config.Config
+
+	plannedTime time.Duration // Scheduled time without planned stops
+	runTime     time.Duration // Time spent Running
+	idealTime   time.Duration // Ideal cycle time of all parts produced
+	goodParts   int
+	totalParts  int
+}
+
+// NewOEETracker creates a new OEE tracker
+func NewOEETracker(cfg *config.Config) *OEETracker {
+	return &OEETracker{cfg: cfg}
+}
+
+// Update accounts one publish interval spent in state
+func (t *OEETracker) Update(state MachineState, interval time.Duration) {
+	switch state {
+	case StateNotScheduled, StatePlannedStop:
+		// Excluded from planned production time
+	case StateRunning:
+		t.runTime += interval
+		t.plannedTime += interval
+	default:
+		t.plannedTime += interval
+	}
+}
+
+// CompletePart counts a part welded at the ideal cycle time of its part.
+// welded is the share of the cycle that was welded; an aborted part only
+// earns that share of the ideal cycle time.
+func (t *OEETracker) CompletePart(isScrap bool, idealCycleTime time.Duration, welded float64) {
+	t.totalParts++
+	if !isScrap {
+		t.goodParts++
+	}
+	t.idealTime += time.Duration(float64(idealCycleTime) * welded)
+}
+
+// Reset starts accounting for a new shift
+func (t *OEETracker) Reset() {
+	*t = OEETracker{cfg: t.cfg}
+}
+
+// Apply fills the OEE fields of data in percent. Factors without data yet
+// are reported as 0.
+func (t *OEETracker) Apply(data *TimeseriesData) {
+	availability := ratio(t.runTime.Seconds(), t.plannedTime.Seconds())
+	performance := ratio(t.idealTime.Seconds(), t.runTime.Seconds())
+	if performance > 1 {
+		performance = 1
+	}
+	quality := ratio(float64(t.goodParts), float64(t.totalParts))
+
+	data.OEEAvailability = availability * 100
+	data.OEEPerformance = performance * 100
+	data.OEEQuality = quality * 100
+	data.OEE = availability * performance * quality * 100
+}
+
+func ratio(numerator, denominator float64) float64 {
+	if denominator <= 0 {
+		return 0
+	}
+	return numerator / denominator
+}
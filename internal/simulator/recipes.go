@@ -0,0 +1,22 @@
+package simulator
+
+// transferProfile describes the 1 Hz signal statistics of a transfer mode
+type transferProfile struct {
+	currentNoise float64 // Relative standard deviation of current
+	voltageNoise float64 // Relative standard deviation of voltage
+	correlation  float64 // Correlation between current and voltage noise
+	spikeRate    float64 // Probability of a current spike per sample
+	spikeSize    float64 // Spike amplitude relative to target current
+}
+
+// transferProfiles holds the signature of each transfer mode
+var transferProfiles = map[TransferMode]transferProfile{
+	// Frequent shorts: current up while voltage collapses
+	TransferShortCircuit: {currentNoise: 0.06, voltageNoise: 0.05, correlation: -0.6, spikeRate: 0.01, spikeSize: 0.15},
+	// Large irregular droplets: high variance and spatter spikes
+	TransferGlobular: {currentNoise: 0.09, voltageNoise: 0.07, correlation: -0.3, spikeRate: 0.03, spikeSize: 0.25},
+	// Stable open arc: low noise, current and voltage move together
+	TransferSpray: {currentNoise: 0.015, voltageNoise: 0.01, correlation: 0.5, spikeRate: 0.003, spikeSize: 0.10},
+	// Source-controlled pulses: tight averages, strongly coupled
+	TransferPulsed: {currentNoise: 0.025, voltageNoise: 0.02, correlation: 0.8, spikeRate: 0.002, spikeSize: 0.08},
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// SensorQuality represents the quality of a measured signal
+type SensorQuality int
+
+const (
+	QualityGood SensorQuality = iota
+	QualityUncertainLastUsable
+	QualityBadSensorFailure
+	QualityBadCommunication
+)
+
+func (q SensorQuality) String() string {
+	switch q {
+	case QualityGood:
+		return "Good"
+	case QualityUncertainLastUsable:
+		return "Uncertain_LastUsableValue"
+	case QualityBadSensorFailure:
+		return "Bad_SensorFailure"
+	case QualityBadCommunication:
+		return "Bad_CommunicationError"
+	default:
+		return "Unknown"
+	}
+}
+
+// sensorFault is an active degradation of a single signal
+type sensorFault struct {
+	quality SensorQuality
+	until   time.Time
+}
+
+// SensorHealthModel degrades individual signals for periods of time
+type SensorHealthModel struct {
+	cfg      *config.Config
+	rng      *rand.Rand
+	faults   map[string]*sensorFault
+	lastGood map[string]float64
+}
+
+// NewSensorHealthModel creates a new sensor health model
+func NewSensorHealthModel(cfg *config.Config) *SensorHealthModel {
+	return &SensorHealthModel{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		faults:   make(map[string]*sensorFault),
+		lastGood: make(map[string]float64),
+	}
+}
+
+// Apply updates fault state and overrides values and quality of degraded signals
+func (sh *SensorHealthModel) Apply(now time.Time, data *TimeseriesData) {
+	for _, sig := range Signals {
+		if !sig.Sensor {
+			continue
+		}
+		name := sig.Name
+		value := sig.Field(data)
+
+		fault := sh.faults[name]
+		if fault != nil && !now.Before(fault.until) {
+			delete(sh.faults, name)
+			fault = nil
+		}
+		if fault == nil && sh.rng.Float64() < sh.cfg.SensorFaultRate {
+			fault = sh.newFault(now)
+			sh.faults[name] = fault
+		}
+
+		if fault == nil {
+			sh.lastGood[name] = *value
+			continue
+		}
+
+		switch fault.quality {
+		case QualityBadSensorFailure:
+			// Open-circuit sensor reads zero
+			*value = 0
+		default:
+			// Value is held at the last reading that got through
+			*value = sh.lastGood[name]
+		}
+
+		if data.Quality == nil {
+			data.Quality = make(map[string]SensorQuality)
+		}
+		data.Quality[name] = fault.quality
+	}
+}
+
+func (sh *SensorHealthModel) newFault(now time.Time) *sensorFault {
+	qualities := []SensorQuality{
+		QualityUncertainLastUsable,
+		QualityBadSensorFailure,
+		QualityBadCommunication,
+	}
+
+	minDur := sh.cfg.SensorFaultMinDuration
+	maxDur := sh.cfg.SensorFaultMaxDuration
+	duration := minDur + time.Duration(sh.rng.Float64()*float64(maxDur-minDur))
+
+	return &sensorFault{
+		quality: qualities[sh.rng.Intn(len(qualities))],
+		until:   now.Add(duration),
+	}
+}
@@ -0,0 +1,445 @@
+package simulator
+
+import (
+	"fmt"
+	"time"
+)
+
+// Component is the part of the machine a signal belongs to
+type Component int
+
+const (
+	ComponentRobot Component = iota
+	ComponentController
+	ComponentProcess
+	ComponentJob
+)
+
+// SignalType is the data type of a signal value
+type SignalType int
+
+const (
+	TypeDouble      SignalType = iota // float64
+	TypeInt32                         // int32
+	TypeString                        // string
+	TypeDateTime                      // time.Time in UTC
+	TypeDoubleArray                   // []float64
+)
+
+// Unit is a unit of measure with its code from UNECE Recommendation 20
+type Unit struct {
+	Symbol string
+	Name   string
+	Code   string
+}
+
+// Units of the signals
+var (
+	UnitAmpere           = &Unit{"A", "ampere", "AMP"}
+	UnitVolt             = &Unit{"V", "volt", "VLT"}
+	UnitMetrePerMinute   = &Unit{"m/min", "metre per minute", "2X"}
+	UnitLitrePerMinute   = &Unit{"l/min", "litre per minute", "L2"}
+	UnitMillimetrePerSec = &Unit{"mm/s", "millimetre per second", "C16"}
+	UnitSecond           = &Unit{"s", "second", "SEC"}
+	UnitMillimetre       = &Unit{"mm", "millimetre", "MMT"}
+	UnitDegree           = &Unit{"°", "degree", "DD"}
+	UnitKilowatt         = &Unit{"kW", "kilowatt", "KWT"}
+	UnitKilowattHour     = &Unit{"kWh", "kilowatt hour", "KWH"}
+	UnitLitre            = &Unit{"l", "litre", "LTR"}
+	UnitDegreeCelsius    = &Unit{"°C", "degree Celsius", "CEL"}
+	UnitPercent          = &Unit{"%", "percent", "P1"}
+)
+
+// percentRange is the range of shares in percent
+var percentRange = &Range{Min: 0, Max: 100}
+
+// Signal is a value the simulator publishes. It is declared once here;
+// outputs create their variables from Signals and publish the Samples of
+// every tick.
+type Signal struct {
+	Name        string // Unique, dots group related signals, e.g. Energy.Power
+	DisplayName string
+	Description string
+	Component   Component
+	Type        SignalType
+	Unit        *Unit  // nil if the value has no unit
+	Range       *Range // Engineering range, nil if open
+
+	// Sensor signals are degraded by the SensorHealthModel. Signals derived
+	// from a sensor name it in QualityOf and share its quality.
+	Sensor    bool
+	QualityOf string
+
+	// HighRate signals are only filled in high-rate mode
+	HighRate bool
+
+	// Source of the value: Field for Double signals, Get for the others
+	Field func(d *TimeseriesData) *float64
+	Get   func(d *TimeseriesData) interface{}
+
+	// Set changes the setpoint behind a writable signal, nil if read-only.
+	// It is only called in the simulation loop.
+	Set func(tg *TimeseriesGenerator, value float64)
+}
+
+// Value returns the value of the signal in d
+func (s *Signal) Value(d *TimeseriesData) interface{} {
+	if s.Field != nil {
+		return *s.Field(d)
+	}
+	return s.Get(d)
+}
+
+// Quality returns the sensor quality of the signal in d
+func (s *Signal) Quality(d *TimeseriesData) SensorQuality {
+	if s.QualityOf != "" {
+		return d.Quality[s.QualityOf]
+	}
+	return d.Quality[s.Name]
+}
+
+// Initial returns the value published before the first tick
+func (s *Signal) Initial() interface{} {
+	return s.Value(&initialData)
+}
+
+// initialData holds the robot at its home position with the torch at coolant
+// and the workpiece at ambient temperature
+var initialData = TimeseriesData{
+	PositionZ:                200,
+	TorchTemperature:         coolantSupplyTemp,
+	CoolantReturnTemperature: coolantSupplyTemp,
+	InterpassTemperature:     ambientTemp,
+	CurrentWaveform:          []float64{},
+	VoltageWaveform:          []float64{},
+}
+
+// Signals is the registry of all published signals
+var Signals = []*Signal{
+	// Welding parameters
+	{
+		Name: "WeldingCurrent", DisplayName: "Welding Current", Description: "Current in Amps",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitAmpere, Range: &Range{Min: 0, Max: CurrentRange.Max}, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.WeldingCurrent },
+	},
+	{
+		Name: "Voltage", DisplayName: "Voltage", Description: "Arc voltage in Volts",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitVolt, Range: &Range{Min: 0, Max: VoltageRange.Max}, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.Voltage },
+	},
+	{
+		Name: "WireFeedSpeed", DisplayName: "Wire Feed Speed", Description: "Wire feed in m/min",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitMetrePerMinute, Range: &Range{Min: 0, Max: WireFeedSpeedRange.Max}, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.WireFeedSpeed },
+	},
+	{
+		Name: "GasFlow", DisplayName: "Gas Flow", Description: "Shielding gas flow l/min",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitLitrePerMinute, Range: &Range{Min: 0, Max: GasFlowRange.Max}, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.GasFlow },
+	},
+	{
+		Name: "TravelSpeed", DisplayName: "Travel Speed", Description: "Travel speed mm/s",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitMillimetrePerSec, Range: &Range{Min: 0, Max: TravelSpeedRange.Max}, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.TravelSpeed },
+	},
+	{
+		Name: "ArcTime", DisplayName: "Arc Time", Description: "Cumulative arc time seconds",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitSecond,
+		Field: func(d *TimeseriesData) *float64 { return &d.ArcTime },
+	},
+	{
+		Name: "TransferMode", DisplayName: "Transfer Mode", Description: "Metal transfer mode of the active recipe",
+		Component: ComponentProcess, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.TransferMode.String() },
+	},
+
+	// Setpoints the welding parameters follow
+	{
+		Name: "Setpoint.WeldingCurrent", DisplayName: "Welding Current Setpoint", Description: "Target welding current in Amps",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitAmpere, Range: &CurrentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.SetpointCurrent },
+		Set:   func(tg *TimeseriesGenerator, value float64) { tg.TargetCurrent = value },
+	},
+	{
+		Name: "Setpoint.Voltage", DisplayName: "Voltage Setpoint", Description: "Target arc voltage in Volts",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitVolt, Range: &VoltageRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.SetpointVoltage },
+		Set:   func(tg *TimeseriesGenerator, value float64) { tg.TargetVoltage = value },
+	},
+	{
+		Name: "Setpoint.WireFeedSpeed", DisplayName: "Wire Feed Speed Setpoint", Description: "Target wire feed in m/min",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitMetrePerMinute, Range: &WireFeedSpeedRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.SetpointWireFeedSpeed },
+		Set:   func(tg *TimeseriesGenerator, value float64) { tg.TargetWireFeedSpeed = value },
+	},
+	{
+		Name: "Setpoint.GasFlow", DisplayName: "Gas Flow Setpoint", Description: "Target shielding gas flow l/min",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitLitrePerMinute, Range: &GasFlowRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.SetpointGasFlow },
+		Set:   func(tg *TimeseriesGenerator, value float64) { tg.TargetGasFlow = value },
+	},
+	{
+		Name: "Setpoint.TravelSpeed", DisplayName: "Travel Speed Setpoint", Description: "Target travel speed mm/s",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitMillimetrePerSec, Range: &TravelSpeedRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.SetpointTravelSpeed },
+		Set:   func(tg *TimeseriesGenerator, value float64) { tg.TargetTravelSpeed = value },
+	},
+
+	// Position
+	{
+		Name: "Position.X", DisplayName: "Position X", Description: "X position mm",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitMillimetre, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.PositionX },
+	},
+	{
+		Name: "Position.Y", DisplayName: "Position Y", Description: "Y position mm",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitMillimetre, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.PositionY },
+	},
+	{
+		Name: "Position.Z", DisplayName: "Position Z", Description: "Z position mm",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitMillimetre, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.PositionZ },
+	},
+	{
+		Name: "TorchAngle", DisplayName: "Torch Angle", Description: "Torch angle degrees",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitDegree, Sensor: true,
+		Field: func(d *TimeseriesData) *float64 { return &d.TorchAngle },
+	},
+
+	// Production
+	{
+		Name: "State", DisplayName: "State", Description: "Machine state (0-5)",
+		Component: ComponentController, Type: TypeInt32,
+		Get: func(d *TimeseriesData) interface{} { return int32(d.State) },
+	},
+	{
+		Name: "GoodParts", DisplayName: "Good Parts", Description: "Good parts count",
+		Component: ComponentRobot, Type: TypeInt32,
+		Get: func(d *TimeseriesData) interface{} { return int32(d.GoodParts) },
+	},
+	{
+		Name: "ScrapParts", DisplayName: "Scrap Parts", Description: "Scrap parts count",
+		Component: ComponentRobot, Type: TypeInt32,
+		Get: func(d *TimeseriesData) interface{} { return int32(d.ScrapParts) },
+	},
+	{
+		Name: "CurrentOrderId", DisplayName: "Current Order ID", Description: "Active order ID",
+		Component: ComponentJob, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.CurrentOrderID },
+	},
+	{
+		Name: "CurrentPartNumber", DisplayName: "Current Part Number", Description: "Active part number",
+		Component: ComponentJob, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.CurrentPartNumber },
+	},
+	{
+		Name: "CycleProgress", DisplayName: "Cycle Progress", Description: "Progress 0-100%",
+		Component: ComponentJob, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.CycleProgress },
+	},
+
+	// Errors
+	{
+		Name: "ErrorCode", DisplayName: "Error Code", Description: "Current error code",
+		Component: ComponentController, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.ErrorCode },
+	},
+	{
+		Name: "ErrorMessage", DisplayName: "Error Message", Description: "Error description",
+		Component: ComponentController, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.ErrorMessage },
+	},
+	{
+		Name: "ErrorTimestamp", DisplayName: "Error Timestamp", Description: "Time the current error occurred",
+		Component: ComponentController, Type: TypeDateTime,
+		Get: func(d *TimeseriesData) interface{} { return d.ErrorTimestamp.UTC() },
+	},
+	{
+		Name: "RecoveryStep", DisplayName: "Recovery Step", Description: "Recovery step after an error, e.g. Recalibration",
+		Component: ComponentController, Type: TypeString,
+		Get: func(d *TimeseriesData) interface{} { return d.RecoveryStep },
+	},
+
+	// Energy and utilities
+	{
+		Name: "Energy.Power", DisplayName: "Power", Description: "Electrical input power kW",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowatt,
+		Field: func(d *TimeseriesData) *float64 { return &d.Power },
+	},
+	{
+		Name: "Energy.ArcPower", DisplayName: "Arc Power", Description: "Arc power kW",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowatt,
+		Field: func(d *TimeseriesData) *float64 { return &d.ArcPower },
+	},
+	{
+		Name: "Energy.EnergyTotal", DisplayName: "Energy Total", Description: "Cumulative energy meter kWh",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowattHour,
+		Field: func(d *TimeseriesData) *float64 { return &d.EnergyTotal },
+	},
+	{
+		Name: "Energy.GasTotal", DisplayName: "Gas Total", Description: "Cumulative shielding gas liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.GasTotal },
+	},
+	{
+		Name: "Energy.AirTotal", DisplayName: "Air Total", Description: "Cumulative compressed air liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.AirTotal },
+	},
+	{
+		Name: "Energy.LastPart.Energy", DisplayName: "Last Part Energy", Description: "Last part energy kWh",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowattHour,
+		Field: func(d *TimeseriesData) *float64 { return &d.LastPartUtilities.EnergyKWh },
+	},
+	{
+		Name: "Energy.LastPart.Gas", DisplayName: "Last Part Gas", Description: "Last part shielding gas liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.LastPartUtilities.GasLiters },
+	},
+	{
+		Name: "Energy.LastPart.Air", DisplayName: "Last Part Air", Description: "Last part compressed air liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.LastPartUtilities.AirLiters },
+	},
+	{
+		Name: "Energy.Order.Energy", DisplayName: "Order Energy", Description: "Order energy kWh",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowattHour,
+		Field: func(d *TimeseriesData) *float64 { return &d.OrderUtilities.EnergyKWh },
+	},
+	{
+		Name: "Energy.Order.Gas", DisplayName: "Order Gas", Description: "Order shielding gas liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.OrderUtilities.GasLiters },
+	},
+	{
+		Name: "Energy.Order.Air", DisplayName: "Order Air", Description: "Order compressed air liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.OrderUtilities.AirLiters },
+	},
+	{
+		Name: "Energy.Shift.Energy", DisplayName: "Shift Energy", Description: "Shift energy kWh",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitKilowattHour,
+		Field: func(d *TimeseriesData) *float64 { return &d.ShiftUtilities.EnergyKWh },
+	},
+	{
+		Name: "Energy.Shift.Gas", DisplayName: "Shift Gas", Description: "Shift shielding gas liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.ShiftUtilities.GasLiters },
+	},
+	{
+		Name: "Energy.Shift.Air", DisplayName: "Shift Air", Description: "Shift compressed air liters",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitLitre,
+		Field: func(d *TimeseriesData) *float64 { return &d.ShiftUtilities.AirLiters },
+	},
+
+	// Thermal
+	{
+		Name: "Thermal.TorchTemperature", DisplayName: "Torch Temperature", Description: "Torch temperature °C",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitDegreeCelsius,
+		Field: func(d *TimeseriesData) *float64 { return &d.TorchTemperature },
+	},
+	{
+		Name: "Thermal.CoolantFlow", DisplayName: "Coolant Flow", Description: "Torch coolant flow l/min",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitLitrePerMinute,
+		Field: func(d *TimeseriesData) *float64 { return &d.CoolantFlow },
+	},
+	{
+		Name: "Thermal.CoolantReturnTemperature", DisplayName: "Coolant Return Temperature", Description: "Coolant return temperature °C",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitDegreeCelsius,
+		Field: func(d *TimeseriesData) *float64 { return &d.CoolantReturnTemperature },
+	},
+	{
+		Name: "Thermal.InterpassTemperature", DisplayName: "Interpass Temperature", Description: "Workpiece interpass temperature °C",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitDegreeCelsius,
+		Field: func(d *TimeseriesData) *float64 { return &d.InterpassTemperature },
+	},
+	{
+		Name: "Thermal.DutyCycle", DisplayName: "Duty Cycle", Description: "Arc-on percentage over the last 10 minutes",
+		Component: ComponentProcess, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.DutyCycle },
+	},
+
+	// Overall equipment effectiveness
+	{
+		Name: "OEE.Availability", DisplayName: "Availability", Description: "Run time share of planned production time in the current shift, unscheduled time excluded (%)",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.OEEAvailability },
+	},
+	{
+		Name: "OEE.Performance", DisplayName: "Performance", Description: "Ideal cycle time of produced parts relative to run time (%)",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.OEEPerformance },
+	},
+	{
+		Name: "OEE.Quality", DisplayName: "Quality", Description: "Good parts share of all parts (%)",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.OEEQuality },
+	},
+	{
+		Name: "OEE.OEE", DisplayName: "OEE", Description: "Overall equipment effectiveness of the current shift (%)",
+		Component: ComponentRobot, Type: TypeDouble, Unit: UnitPercent, Range: percentRange,
+		Field: func(d *TimeseriesData) *float64 { return &d.OEE },
+	},
+
+	// High-rate waveforms
+	{
+		Name: "Waveform.Current", DisplayName: "Current Waveform", Description: "Welding current samples in Amps for the last publish window",
+		Component: ComponentProcess, Type: TypeDoubleArray, Unit: UnitAmpere, QualityOf: "WeldingCurrent", HighRate: true,
+		Get: func(d *TimeseriesData) interface{} { return d.CurrentWaveform },
+	},
+	{
+		Name: "Waveform.Voltage", DisplayName: "Voltage Waveform", Description: "Arc voltage samples in Volts for the last publish window",
+		Component: ComponentProcess, Type: TypeDoubleArray, Unit: UnitVolt, QualityOf: "Voltage", HighRate: true,
+		Get: func(d *TimeseriesData) interface{} { return d.VoltageWaveform },
+	},
+}
+
+var signalsByName = make(map[string]*Signal)
+
+func init() {
+	for _, s := range Signals {
+		if _, ok := signalsByName[s.Name]; ok {
+			panic(fmt.Sprintf("signal %s declared twice", s.Name))
+		}
+		if (s.Field == nil) == (s.Get == nil) || (s.Field != nil) != (s.Type == TypeDouble) {
+			panic(fmt.Sprintf("signal %s needs Field if it is a Double and Get otherwise", s.Name))
+		}
+		if s.Set != nil && s.Range == nil {
+			panic(fmt.Sprintf("writable signal %s needs a Range", s.Name))
+		}
+		signalsByName[s.Name] = s
+	}
+}
+
+// LookupSignal returns the signal of the given name
+func LookupSignal(name string) (*Signal, bool) {
+	s, ok := signalsByName[name]
+	return s, ok
+}
+
+// Sample is the value of a signal at a tick
+type Sample struct {
+	Signal  *Signal
+	Value   interface{}
+	Quality SensorQuality
+}
+
+// Samples returns the values of all signals in d. HighRate signals are left
+// out unless d holds waveforms, which it only does in high-rate mode.
+func (d *TimeseriesData) Samples() []Sample {
+	samples := make([]Sample, 0, len(Signals))
+	for _, s := range Signals {
+		if s.HighRate && d.WaveformSampleRate == 0 {
+			continue
+		}
+		samples = append(samples, Sample{Signal: s, Value: s.Value(d), Quality: s.Quality(d)})
+	}
+	return samples
+}
+
+// Sink is an output of the signals, such as the OPC UA server
+type Sink interface {
+	// Publish is called in the simulation loop with the samples of a tick
+	Publish(timestamp time.Time, samples []Sample)
+}
@@ -1,6 +1,8 @@
 package simulator
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
@@ -12,10 +14,17 @@ type StateMachine struct {
 	state           *SimulatorState
 	cfg             *config.Config
 	rng             *rand.Rand
+	errorCatalog    *ErrorCatalog
+	partCycleTime   func(partNumber string) (time.Duration, bool)
+	events          *EventLog
+	faults          []faultRecord  // Recent errors, for repeated-fault cascades
+	effects         []activeEffect // Follow-on effects of past errors
 	onStateChange   func(from, to MachineState)
-	onCycleComplete func(isScrap bool)
+	onCycleComplete func(isScrap bool, welded float64)
 	onOrderComplete func(order *ProductionOrder)
 	onError         func(err *ErrorInfo)
+	onRecoveryStep  func(err *ErrorInfo)
+	onErrorCleared  func(err *ErrorInfo)
 }
 
 // NewStateMachine creates a new state machine
@@ -27,22 +36,44 @@ func NewStateMachine(cfg *config.Config) *StateMachine {
 			StateEnteredAt: time.Now(),
 			OrderQueue:     make([]*ProductionOrder, 0),
 		},
-		cfg: cfg,
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		cfg:          cfg,
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		errorCatalog: DefaultErrorCatalog(),
+		events:       NewEventLog(),
 	}
 }
 
+// SetPartCycleTimes sets the lookup of the cycle times of parts by part
+// number
+func (sm *StateMachine) SetPartCycleTimes(lookup func(partNumber string) (time.Duration, bool)) {
+	sm.partCycleTime = lookup
+}
+
+// SetErrorCatalog replaces the errors the machine can raise
+func (sm *StateMachine) SetErrorCatalog(catalog *ErrorCatalog) {
+	sm.errorCatalog = catalog
+}
+
+// ErrorCatalog returns the errors the machine can raise
+func (sm *StateMachine) ErrorCatalog() *ErrorCatalog {
+	return sm.errorCatalog
+}
+
 // SetCallbacks sets the callback functions for state events
 func (sm *StateMachine) SetCallbacks(
 	onStateChange func(from, to MachineState),
-	onCycleComplete func(isScrap bool),
+	onCycleComplete func(isScrap bool, welded float64),
 	onOrderComplete func(order *ProductionOrder),
 	onError func(err *ErrorInfo),
+	onRecoveryStep func(err *ErrorInfo),
+	onErrorCleared func(err *ErrorInfo),
 ) {
 	sm.onStateChange = onStateChange
 	sm.onCycleComplete = onCycleComplete
 	sm.onOrderComplete = onOrderComplete
 	sm.onError = onError
+	sm.onRecoveryStep = onRecoveryStep
+	sm.onErrorCleared = onErrorCleared
 }
 
 // State returns the current machine state
@@ -73,6 +104,7 @@ func (sm *StateMachine) TransitionTo(newState MachineState) {
 	// Reset weld phase when not running
 	if newState != StateRunning {
 		sm.state.WeldPhase = PhaseOff
+		sm.state.StopAfterCycle = false
 	}
 
 	if sm.onStateChange != nil {
@@ -86,42 +118,112 @@ func (sm *StateMachine) SetWeldPhase(phase WeldPhase) {
 	sm.state.PhaseStartedAt = time.Now()
 }
 
-// Update is called every tick to update the state machine
-func (sm *StateMachine) Update(now time.Time, isBreakTime bool) {
+// Update is called every tick to update the state machine. isScheduled is
+// false outside of shifts.
+func (sm *StateMachine) Update(now time.Time, isBreakTime, isScheduled bool) {
+	if sm.state.Paused {
+		return
+	}
+
+	sm.expireEffects(now)
+
+	// A held state stays put until the hold expires
+	if sm.state.Held {
+		if sm.state.HoldUntil.IsZero() || now.Before(sm.state.HoldUntil) {
+			return
+		}
+		sm.state.Held = false
+		sm.state.HoldUntil = time.Time{}
+	}
+
 	elapsed := now.Sub(sm.state.StateEnteredAt)
 
 	switch sm.state.State {
 	case StateIdle:
-		sm.updateIdle(now)
+		sm.updateIdle(now, isBreakTime, isScheduled)
 
 	case StateSetup:
-		sm.updateSetup(elapsed, now)
+		sm.updateSetup(elapsed, now, isBreakTime, isScheduled)
 
 	case StateRunning:
-		sm.updateRunning(now, isBreakTime)
+		sm.updateRunning(now, isBreakTime, isScheduled)
 
 	case StatePlannedStop:
-		sm.updatePlannedStop(isBreakTime)
+		sm.updatePlannedStop(isBreakTime, isScheduled)
 
 	case StateUnplannedStop:
-		sm.updateUnplannedStop(now)
+		sm.updateUnplannedStop(now, isBreakTime, isScheduled)
+
+	case StateNotScheduled:
+		sm.updateNotScheduled(isScheduled)
 	}
 }
 
-func (sm *StateMachine) updateIdle(now time.Time) {
+func (sm *StateMachine) updateIdle(now time.Time, isBreakTime, isScheduled bool) {
+	if !isScheduled {
+		sm.TransitionTo(StateNotScheduled)
+		return
+	}
+	if isBreakTime {
+		sm.TransitionTo(StatePlannedStop)
+		return
+	}
+
 	// Check if there's an order to work on
-	if sm.state.CurrentOrder != nil || len(sm.state.OrderQueue) > 0 {
-		if sm.state.CurrentOrder == nil {
-			sm.state.CurrentOrder = sm.state.OrderQueue[0]
-			sm.state.OrderQueue = sm.state.OrderQueue[1:]
-			sm.state.CurrentOrder.Status = OrderStatusInProgress
-			sm.state.CurrentOrder.StartedAt = now
-		}
+	if sm.takeNextOrder(now) {
 		sm.TransitionTo(StateSetup)
 	}
 }
 
-func (sm *StateMachine) updateSetup(elapsed time.Duration, now time.Time) {
+// takeNextOrder makes sure there is a current order, starting the next one
+// from the queue if needed, and reports whether one is available
+func (sm *StateMachine) takeNextOrder(now time.Time) bool {
+	if sm.state.CurrentOrder == nil {
+		if len(sm.state.OrderQueue) == 0 {
+			return false
+		}
+		sm.state.CurrentOrder = sm.state.OrderQueue[0]
+		sm.state.OrderQueue = sm.state.OrderQueue[1:]
+		sm.state.CurrentOrder.Status = OrderStatusInProgress
+		sm.state.CurrentOrder.StartedAt = now
+		sm.resolveCycleTime()
+		sm.estimateCompletion(now.Add(sm.cfg.SetupTime))
+	}
+	return true
+}
+
+// resolveCycleTime looks up the cycle time of the current order's part.
+// Parts the lookup does not know are welded at the configured cycle time.
+func (sm *StateMachine) resolveCycleTime() {
+	sm.state.OrderCycleTime = 0
+	if sm.state.CurrentOrder == nil || sm.partCycleTime == nil {
+		return
+	}
+	if cycleTime, ok := sm.partCycleTime(sm.state.CurrentOrder.PartNumber); ok {
+		sm.state.OrderCycleTime = cycleTime
+	}
+}
+
+// CycleTime returns the cycle time of the part being welded
+func (sm *StateMachine) CycleTime() time.Duration {
+	if sm.state.OrderCycleTime > 0 {
+		return sm.state.OrderCycleTime
+	}
+	return sm.cfg.CycleTime
+}
+
+func (sm *StateMachine) updateSetup(elapsed time.Duration, now time.Time, isBreakTime, isScheduled bool) {
+	// Park the order outside of shifts and during breaks; setup restarts
+	// afterwards
+	if !isScheduled {
+		sm.TransitionTo(StateNotScheduled)
+		return
+	}
+	if isBreakTime {
+		sm.TransitionTo(StatePlannedStop)
+		return
+	}
+
 	// Setup complete after configured time
 	if elapsed >= sm.cfg.SetupTime {
 		sm.TransitionTo(StateRunning)
@@ -130,12 +232,18 @@ func (sm *StateMachine) updateSetup(elapsed time.Duration, now time.Time) {
 	}
 }
 
-func (sm *StateMachine) updateRunning(now time.Time, isBreakTime bool) {
-	// Check for break time
-	if isBreakTime {
-		sm.TransitionTo(StatePlannedStop)
+func (sm *StateMachine) updateRunning(now time.Time, isBreakTime, isScheduled bool) {
+	// Breaks and the shift end stop the cycle according to the stop policy
+	stopState, stop := StatePlannedStop, isBreakTime
+	if !isScheduled {
+		stopState, stop = StateNotScheduled, true
+	}
+	if stop && sm.cfg.StopPolicy == config.StopPolicyAbortCycle {
+		sm.abortCycle(now)
+		sm.TransitionTo(stopState)
 		return
 	}
+	sm.state.StopAfterCycle = stop
 
 	// Check for random error
 	if sm.shouldTriggerError() {
@@ -145,12 +253,7 @@ func (sm *StateMachine) updateRunning(now time.Time, isBreakTime bool) {
 
 	// Update weld phase and check cycle completion
 	cycleElapsed := now.Sub(sm.state.CycleStartedAt)
-	cycleTime := sm.cfg.CycleTime
-
-	// Get part-specific cycle time if available
-	if sm.state.CurrentOrder != nil {
-		// Could look up part-specific cycle time here
-	}
+	cycleTime := sm.CycleTime()
 
 	// Calculate phase timing
 	rampUpDuration := time.Duration(float64(cycleTime) * 0.05)   // 5% of cycle
@@ -161,6 +264,11 @@ func (sm *StateMachine) updateRunning(now time.Time, isBreakTime bool) {
 	case PhaseRampUp:
 		if cycleElapsed >= rampUpDuration {
 			sm.SetWeldPhase(PhaseSteady)
+
+			// Faults made likely by earlier errors strike once per cycle
+			if sm.triggerCascadedError(now) {
+				return
+			}
 		}
 
 	case PhaseSteady:
@@ -173,26 +281,69 @@ func (sm *StateMachine) updateRunning(now time.Time, isBreakTime bool) {
 
 	case PhaseRampDown:
 		if cycleElapsed >= cycleTime {
-			sm.completeCycle(now)
+			sm.completeCycle(now, stopState)
 		}
 	}
 }
 
-func (sm *StateMachine) updatePlannedStop(isBreakTime bool) {
+func (sm *StateMachine) updatePlannedStop(isBreakTime, isScheduled bool) {
+	if !isScheduled {
+		sm.TransitionTo(StateNotScheduled)
+		return
+	}
+
 	// Return to idle when break is over
 	if !isBreakTime {
 		sm.TransitionTo(StateIdle)
 	}
 }
 
-func (sm *StateMachine) updateUnplannedStop(now time.Time) {
-	// Check if error duration has passed
-	if sm.state.CurrentError != nil && now.After(sm.state.CurrentError.ExpectedEnd) {
-		sm.clearError()
+func (sm *StateMachine) updateNotScheduled(isScheduled bool) {
+	// Resume when the next shift starts
+	if isScheduled {
 		sm.TransitionTo(StateIdle)
 	}
 }
 
+func (sm *StateMachine) updateUnplannedStop(now time.Time, isBreakTime, isScheduled bool) {
+	// Check if the repair or the current recovery step is done
+	err := sm.state.CurrentError
+	if err == nil || !now.After(err.ExpectedEnd) {
+		return
+	}
+
+	// Work through the recovery steps of the error
+	if len(err.recovery) > 0 {
+		step := err.recovery[0]
+		err.recovery = err.recovery[1:]
+		err.RecoveryStep = step.Name
+		err.ExpectedEnd = now.Add(sm.randomDuration(step.MinDuration, step.MaxDuration))
+		sm.events.Add(Event{
+			Time:     now,
+			Type:     EventRecovery,
+			Code:     err.Code,
+			Message:  step.Name,
+			CausedBy: []int64{err.EventID},
+		})
+		if sm.onRecoveryStep != nil {
+			sm.onRecoveryStep(err)
+		}
+		return
+	}
+
+	resume := err.resume
+	sm.clearError()
+
+	// Continue welding the order without a new setup if the error allows it
+	if resume == ResumeRunning && sm.state.CurrentOrder != nil && isScheduled && !isBreakTime {
+		sm.TransitionTo(StateRunning)
+		sm.state.CycleStartedAt = now
+		sm.SetWeldPhase(PhaseRampUp)
+		return
+	}
+	sm.TransitionTo(StateIdle)
+}
+
 func (sm *StateMachine) shouldTriggerError() bool {
 	// Only trigger errors during steady state
 	if sm.state.WeldPhase != PhaseSteady {
@@ -202,42 +353,130 @@ func (sm *StateMachine) shouldTriggerError() bool {
 }
 
 func (sm *StateMachine) triggerError(now time.Time) {
-	// Select random error type
-	errors := []ErrorCode{
-		ErrorWireFeedJam,
-		ErrorGasFlowFault,
-		ErrorArcFault,
-		ErrorRobotCollision,
-		ErrorQualityReject,
+	// Select random error type weighted by the catalog probabilities
+	if code, ok := sm.errorCatalog.pick(sm.rng); ok {
+		sm.TriggerError(code, now)
+	}
+}
+
+// TriggerError raises a specific error and stops the machine. Codes missing
+// from the error catalog are rejected.
+func (sm *StateMachine) TriggerError(errorCode ErrorCode, now time.Time) error {
+	return sm.raiseError(errorCode, now, nil)
+}
+
+// raiseError raises an error caused by the events causedBy, if known
+func (sm *StateMachine) raiseError(errorCode ErrorCode, now time.Time, causedBy []int64) error {
+	def, ok := sm.errorCatalog.Lookup(errorCode)
+	if !ok {
+		return fmt.Errorf("unknown error code %q", errorCode)
 	}
-	errorCode := errors[sm.rng.Intn(len(errors))]
-	message, minDur, maxDur := GetErrorInfo(errorCode)
 
-	// Random duration within range
-	duration := minDur + time.Duration(sm.rng.Float64()*float64(maxDur-minDur))
+	// A new error supersedes the current one
+	sm.clearError()
 
 	sm.state.CurrentError = &ErrorInfo{
 		Code:        errorCode,
-		Message:     message,
+		Message:     def.Message,
+		Severity:    def.Severity,
 		OccurredAt:  now,
-		ExpectedEnd: now.Add(duration),
+		ExpectedEnd: now.Add(sm.randomDuration(def.MinDuration, def.MaxDuration)),
+		recovery:    def.Recovery,
+		resume:      def.Resume,
 	}
+	sm.state.CurrentError.EventID = sm.events.Add(Event{
+		Time:     now,
+		Type:     EventError,
+		Code:     errorCode,
+		OrderID:  sm.currentOrderID(),
+		Message:  def.Message,
+		CausedBy: causedBy,
+	})
+	sm.startCascades(errorCode, sm.state.CurrentError.EventID, now)
+
+	// An error ends any hold so the recovery timer runs
+	sm.state.Held = false
+	sm.state.HoldUntil = time.Time{}
 
 	sm.TransitionTo(StateUnplannedStop)
 
 	if sm.onError != nil {
 		sm.onError(sm.state.CurrentError)
 	}
+	return nil
+}
+
+// randomDuration returns a random duration within [min, max]
+func (sm *StateMachine) randomDuration(min, max time.Duration) time.Duration {
+	return min + time.Duration(sm.rng.Float64()*float64(max-min))
 }
 
 func (sm *StateMachine) clearError() {
+	err := sm.state.CurrentError
+	if err == nil {
+		return
+	}
 	sm.state.CurrentError = nil
+	if sm.onErrorCleared != nil {
+		sm.onErrorCleared(err)
+	}
 }
 
-func (sm *StateMachine) completeCycle(now time.Time) {
+// completeCycle counts the finished part and starts the next cycle, or
+// enters stopState if the machine is to stop after this cycle
+func (sm *StateMachine) completeCycle(now time.Time, stopState MachineState) {
 	// Determine if part is scrap
-	isScrap := sm.rng.Float64() < sm.cfg.ScrapRate
+	isScrap, effect := sm.drawScrap()
+	if isScrap {
+		event := Event{Time: now, Type: EventScrap, OrderID: sm.currentOrderID(), Message: "Part scrapped"}
+		if effect != nil {
+			event.Code = effect.cascade.Cause
+			event.Message = "Part scrapped: " + effect.cascade.Reason
+			event.CausedBy = []int64{effect.eventID}
+		}
+		sm.events.Add(event)
+	}
 
+	if sm.recordPart(isScrap, 1) {
+		sm.TransitionTo(StateIdle)
+		return
+	}
+	sm.estimateCompletion(now)
+
+	// Stop after this part for a break or the shift end
+	if sm.state.StopAfterCycle {
+		sm.TransitionTo(stopState)
+		return
+	}
+
+	// Start next cycle
+	sm.state.CycleStartedAt = now
+	sm.SetWeldPhase(PhaseRampUp)
+}
+
+// abortCycle stops welding mid-cycle. The unfinished part counts as scrap.
+// It reports whether that completed the order.
+func (sm *StateMachine) abortCycle(now time.Time) bool {
+	sm.events.Add(Event{Time: now, Type: EventScrap, OrderID: sm.currentOrderID(), Message: "Part scrapped: cycle aborted"})
+	welded := math.Min(float64(now.Sub(sm.state.CycleStartedAt))/float64(sm.CycleTime()), 1)
+	if sm.recordPart(true, welded) {
+		return true
+	}
+	sm.estimateCompletion(now)
+	return false
+}
+
+func (sm *StateMachine) currentOrderID() string {
+	if sm.state.CurrentOrder == nil {
+		return ""
+	}
+	return sm.state.CurrentOrder.OrderID
+}
+
+// recordPart counts a part and completes the current order once its
+// quantity is reached. welded is the share of the cycle that was welded,
+// below 1 for an aborted cycle. It reports whether the order was completed.
+func (sm *StateMachine) recordPart(isScrap bool, welded float64) bool {
 	if isScrap {
 		sm.state.ScrapParts++
 		if sm.state.CurrentOrder != nil {
@@ -251,7 +490,7 @@ func (sm *StateMachine) completeCycle(now time.Time) {
 	}
 
 	if sm.onCycleComplete != nil {
-		sm.onCycleComplete(isScrap)
+		sm.onCycleComplete(isScrap, welded)
 	}
 
 	// Check if order is complete
@@ -263,14 +502,189 @@ func (sm *StateMachine) completeCycle(now time.Time) {
 				sm.onOrderComplete(sm.state.CurrentOrder)
 			}
 			sm.state.CurrentOrder = nil
+			return true
+		}
+	}
+	return false
+}
+
+// estimateCompletion sets when the current order is finished if its
+// remaining parts are welded back to back from start
+func (sm *StateMachine) estimateCompletion(start time.Time) {
+	order := sm.state.CurrentOrder
+	if order == nil {
+		return
+	}
+	remaining := max(order.Quantity-order.QuantityCompleted-order.QuantityScrap, 0)
+	order.EstimatedCompletion = start.Add(time.Duration(remaining) * sm.CycleTime())
+}
+
+// Pause freezes the state machine. It reports false if already paused.
+func (sm *StateMachine) Pause(now time.Time) bool {
+	if sm.state.Paused {
+		return false
+	}
+	sm.state.Paused = true
+	sm.state.PausedAt = now
+	return true
+}
+
+// Resume continues a paused state machine, shifting all timers by the
+// paused duration so cycles, setups and errors pick up where they stopped.
+// It reports false if not paused.
+func (sm *StateMachine) Resume(now time.Time) bool {
+	if !sm.state.Paused {
+		return false
+	}
+	pausedFor := now.Sub(sm.state.PausedAt)
+	shift := func(t *time.Time) {
+		if !t.IsZero() {
+			*t = t.Add(pausedFor)
+		}
+	}
+
+	shift(&sm.state.StateEnteredAt)
+	shift(&sm.state.CycleStartedAt)
+	shift(&sm.state.PhaseStartedAt)
+	shift(&sm.state.HoldUntil)
+	if sm.state.CurrentError != nil {
+		shift(&sm.state.CurrentError.ExpectedEnd)
+	}
+	for i := range sm.effects {
+		shift(&sm.effects[i].until)
+	}
+
+	sm.state.Paused = false
+	sm.state.PausedAt = time.Time{}
+	return true
+}
+
+// ForceState transitions to a state on request, bypassing the normal
+// schedule. Idle, PlannedStop and NotScheduled can be held for a duration, or
+// indefinitely with hold < 0; a zero hold lets the machine continue
+// normally from the forced state. UnplannedStop is entered by injecting an
+// error with TriggerError instead.
+func (sm *StateMachine) ForceState(newState MachineState, hold time.Duration, now time.Time) error {
+	switch newState {
+	case StateIdle, StatePlannedStop, StateNotScheduled:
+	case StateSetup, StateRunning:
+		if hold != 0 {
+			return fmt.Errorf("%s cannot be held", newState)
+		}
+		if !sm.takeNextOrder(now) {
+			return fmt.Errorf("no production order available for %s", newState)
+		}
+	case StateUnplannedStop:
+		return fmt.Errorf("%s is entered by injecting an error code", newState)
+	default:
+		return fmt.Errorf("unknown machine state %d", newState)
+	}
+
+	sm.clearError()
+	sm.state.Held = hold != 0
+	sm.state.HoldUntil = time.Time{}
+	if hold > 0 {
+		sm.state.HoldUntil = now.Add(hold)
+	}
+
+	wasRunning := sm.state.State == StateRunning
+	sm.TransitionTo(newState)
+
+	// Start a fresh cycle unless one is already in progress
+	if newState == StateRunning && !wasRunning {
+		sm.state.CycleStartedAt = now
+		sm.SetWeldPhase(PhaseRampUp)
+	}
+
+	return nil
+}
+
+// StartOrder makes a queued order the current order and sets it up, ahead
+// of the orders queued before it. An order that was current but is not being
+// welded goes back to the front of the queue.
+func (sm *StateMachine) StartOrder(orderID string, now time.Time) error {
+	switch sm.state.State {
+	case StateRunning, StateUnplannedStop:
+		return fmt.Errorf("cannot start an order in state %s", sm.state.State)
+	}
+
+	current := sm.state.CurrentOrder
+	if current == nil || current.OrderID != orderID {
+		index := -1
+		for i, order := range sm.state.OrderQueue {
+			if order.OrderID == orderID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("order %q is not queued", orderID)
+		}
+
+		order := sm.state.OrderQueue[index]
+		queue := append(sm.state.OrderQueue[:index:index], sm.state.OrderQueue[index+1:]...)
+		if current != nil {
+			current.Status = OrderStatusQueued
+			queue = append([]*ProductionOrder{current}, queue...)
+		}
+		sm.state.OrderQueue = queue
+
+		order.Status = OrderStatusInProgress
+		order.StartedAt = now
+		sm.state.CurrentOrder = order
+	}
+	sm.resolveCycleTime()
+	sm.estimateCompletion(now.Add(sm.cfg.SetupTime))
+
+	sm.state.Held = false
+	sm.state.HoldUntil = time.Time{}
+
+	// A setup in progress was for the previous order; start over
+	if sm.state.State == StateSetup {
+		sm.TransitionTo(StateIdle)
+	}
+	sm.TransitionTo(StateSetup)
+	return nil
+}
+
+// AbortOrder cancels the current order and returns it. A part being welded
+// is scrapped. Like completed orders, the cancelled order is passed to the
+// order complete callback.
+func (sm *StateMachine) AbortOrder(now time.Time) (*ProductionOrder, error) {
+	order := sm.state.CurrentOrder
+	if order == nil {
+		return nil, fmt.Errorf("no order in progress")
+	}
+
+	if sm.state.State == StateRunning {
+		// The scrapped part may have been the last one of the order
+		if sm.abortCycle(now) {
 			sm.TransitionTo(StateIdle)
-			return
+			return order, nil
 		}
 	}
 
-	// Start next cycle
-	sm.state.CycleStartedAt = now
-	sm.SetWeldPhase(PhaseRampUp)
+	order.Status = OrderStatusCancelled
+	sm.state.CurrentOrder = nil
+	if sm.onOrderComplete != nil {
+		sm.onOrderComplete(order)
+	}
+
+	if sm.state.State == StateSetup || sm.state.State == StateRunning {
+		sm.TransitionTo(StateIdle)
+	}
+	return order, nil
+}
+
+// AcknowledgeError confirms that the current error has been dealt with. The
+// repair, or the recovery step in progress, ends with the next tick.
+func (sm *StateMachine) AcknowledgeError(now time.Time) (*ErrorInfo, error) {
+	err := sm.state.CurrentError
+	if err == nil {
+		return nil, fmt.Errorf("no error to acknowledge")
+	}
+	err.ExpectedEnd = now
+	return err, nil
 }
 
 // AddOrder adds a production order to the queue
@@ -305,8 +719,12 @@ func (sm *StateMachine) GetCycleProgress() float64 {
 	if sm.state.State != StateRunning {
 		return 0
 	}
-	elapsed := time.Since(sm.state.CycleStartedAt)
-	progress := float64(elapsed) / float64(sm.cfg.CycleTime) * 100
+	now := time.Now()
+	if sm.state.Paused {
+		now = sm.state.PausedAt
+	}
+	elapsed := now.Sub(sm.state.CycleStartedAt)
+	progress := float64(elapsed) / float64(sm.CycleTime()) * 100
 	if progress > 100 {
 		progress = 100
 	}
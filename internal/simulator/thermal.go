@@ -0,0 +1,158 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+const (
+	ambientTemp        = 22.0 // °C
+	coolantSupplyTemp  = 20.0 // °C, regulated by the chiller
+	nominalCoolantFlow = 4.0  // l/min
+	dutyCycleWindow    = 10 * time.Minute
+	torchTimeConstant  = 90.0   // Seconds for the torch to approach equilibrium
+	workpieceCapacity  = 4.9    // kJ/K, ~10 kg of steel
+	workpieceCooling   = 0.015  // kW/K convection and radiation losses
+	workpieceHeatShare = 0.8    // Share of arc power entering the workpiece
+	coolantFoulingRate = 2e-5   // Mean flow loss per second from a clogging circuit
+	waterHeatCapacity  = 4.186  // kJ/(kg·K)
+	torchHeatTransfer  = 0.0114 // kW/K from torch to coolant at nominal flow
+)
+
+// ThermalModel simulates torch, coolant and workpiece temperatures driven by
+// arc power and duty cycle
+type ThermalModel struct {
+	cfg *config.Config
+	rng *rand.Rand
+
+	torchTemp     float64
+	returnTemp    float64
+	workpieceTemp float64
+	coolantFlow   float64
+	fouling       float64 // Fraction of coolant flow lost to clogging (0-1)
+	dutyCycle     float64 // Arc-on share of the window (0-1)
+
+	// Arc-on seconds and current² seconds per tick over the duty cycle
+	// window
+	arcOn     []float64
+	load      []float64
+	windowIdx int
+	arcOnSum  float64
+	loadSum   float64
+}
+
+// NewThermalModel creates a new thermal model at ambient temperature
+func NewThermalModel(cfg *config.Config) *ThermalModel {
+	windowTicks := int(dutyCycleWindow / cfg.PublishInterval)
+	if windowTicks < 1 {
+		windowTicks = 1
+	}
+	return &ThermalModel{
+		cfg:           cfg,
+		rng:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		torchTemp:     coolantSupplyTemp,
+		returnTemp:    coolantSupplyTemp,
+		workpieceTemp: ambientTemp,
+		coolantFlow:   nominalCoolantFlow,
+		arcOn:         make([]float64, windowTicks),
+		load:          make([]float64, windowTicks),
+	}
+}
+
+// Update advances the thermal state by one publish interval of the machine
+// described by data and reports whether the torch is overheating: its
+// duty cycle exceeds the permissible duty cycle at the RMS current of the
+// window, or it is hotter than its maximum temperature.
+func (tm *ThermalModel) Update(data *TimeseriesData, interval time.Duration) bool {
+	dt := interval.Seconds()
+	arcPowerKW := data.WeldingCurrent * data.Voltage / 1000
+	arcBurning := data.WeldingCurrent > 0
+
+	// Duty cycle and mean current² over the sliding window
+	var arcSeconds, load float64
+	if arcBurning {
+		arcSeconds = dt
+		load = data.WeldingCurrent * data.WeldingCurrent * dt
+	}
+	tm.arcOnSum += arcSeconds - tm.arcOn[tm.windowIdx]
+	tm.loadSum += load - tm.load[tm.windowIdx]
+	tm.arcOn[tm.windowIdx] = arcSeconds
+	tm.load[tm.windowIdx] = load
+	tm.windowIdx = (tm.windowIdx + 1) % len(tm.arcOn)
+	window := float64(len(tm.arcOn)) * dt
+	tm.dutyCycle = tm.arcOnSum / window
+
+	// The permissible duty cycle falls with the square of the RMS current
+	// while the arc burned in the window
+	permissibleDutyCycle := 1.0
+	if tm.arcOnSum > 0 && tm.loadSum > 0 {
+		ratio := tm.cfg.TorchRatedCurrent / math.Sqrt(tm.loadSum/tm.arcOnSum)
+		permissibleDutyCycle = math.Min(tm.cfg.TorchDutyCycleRating*ratio*ratio, 1)
+	}
+
+	// Coolant circuit slowly clogs while the pump runs
+	pumpOn := data.State != StatePlannedStop && data.State != StateNotScheduled
+	if pumpOn {
+		tm.fouling += tm.rng.ExpFloat64() * coolantFoulingRate * dt
+		if tm.fouling > 0.9 {
+			tm.fouling = 0.9
+		}
+		tm.coolantFlow = nominalCoolantFlow * (1 - tm.fouling) * (1 + tm.rng.NormFloat64()*0.01)
+	} else {
+		tm.coolantFlow = 0
+	}
+
+	// Torch heating scales with the mean current² over the window, i.e.
+	// current² × duty cycle. The torch is calibrated so that welding at rated
+	// current and rated duty cycle with nominal coolant flow settles exactly
+	// at the maximum temperature; a fouled circuit heats it beyond.
+	ratedHeat := tm.cfg.TorchRatedCurrent * tm.cfg.TorchRatedCurrent * tm.cfg.TorchDutyCycleRating
+	heat := tm.loadSum / window * (tm.cfg.TorchMaxTemp - coolantSupplyTemp) / ratedHeat
+	flowRatio := tm.coolantFlow / nominalCoolantFlow
+	if flowRatio < 0.05 {
+		flowRatio = 0.05 // Residual natural convection
+	}
+	equilibrium := coolantSupplyTemp + heat/flowRatio
+	tm.torchTemp += (equilibrium - tm.torchTemp) * (1 - math.Exp(-dt/torchTimeConstant))
+
+	// Coolant return temperature from heat removed by the circuit
+	if tm.coolantFlow > 0 {
+		removedKW := (tm.torchTemp - coolantSupplyTemp) * torchHeatTransfer * flowRatio
+		massFlow := tm.coolantFlow / 60 // kg/s
+		tm.returnTemp = coolantSupplyTemp + removedKW/(massFlow*waterHeatCapacity)
+	} else {
+		tm.returnTemp += (ambientTemp - tm.returnTemp) * (1 - math.Exp(-dt/600))
+	}
+
+	// Workpiece heats from the arc and cools to ambient between passes
+	heatIn := 0.0
+	if arcBurning {
+		heatIn = arcPowerKW * workpieceHeatShare
+	}
+	heatOut := workpieceCooling * (tm.workpieceTemp - ambientTemp)
+	tm.workpieceTemp += (heatIn - heatOut) * dt / workpieceCapacity
+
+	return tm.dutyCycle > permissibleDutyCycle || tm.torchTemp > tm.cfg.TorchMaxTemp
+}
+
+// Apply fills the thermal fields of data
+func (tm *ThermalModel) Apply(data *TimeseriesData) {
+	data.TorchTemperature = tm.torchTemp + tm.rng.NormFloat64()*0.2
+	data.CoolantFlow = tm.coolantFlow
+	data.CoolantReturnTemperature = tm.returnTemp + tm.rng.NormFloat64()*0.1
+	data.InterpassTemperature = tm.workpieceTemp + tm.rng.NormFloat64()*0.5
+	data.DutyCycle = tm.dutyCycle * 100
+}
+
+// NewWorkpiece replaces the workpiece with a fresh part at ambient temperature
+func (tm *ThermalModel) NewWorkpiece() {
+	tm.workpieceTemp = ambientTemp
+}
+
+// ServiceCooling flushes the coolant circuit and restores nominal flow
+func (tm *ThermalModel) ServiceCooling() {
+	tm.fouling = 0
+}
@@ -4,6 +4,32 @@ import (
 	"math"
 	"math/rand"
 	"time"
+
+	"github.com/sebastiankruger/shopfloor-simulator/internal/config"
+)
+
+// Range is the engineering range of a signal. Setpoints may only be set
+// within it.
+type Range = config.Range
+
+// Engineering ranges of the setpoints, shared with the validation of the
+// configured setpoints
+var (
+	CurrentRange       = config.CurrentRange       // A
+	VoltageRange       = config.VoltageRange       // V
+	WireFeedSpeedRange = config.WireFeedSpeedRange // m/min
+	GasFlowRange       = config.GasFlowRange       // l/min
+	TravelSpeedRange   = config.TravelSpeedRange   // mm/s
+)
+
+// Time constants with which the process follows a setpoint change. The power
+// source ramps current and voltage, the wire feed motor and the robot
+// accelerate, and the gas regulator settles slowest.
+const (
+	electricalLag  = 1 * time.Second
+	wireFeedLag    = 1500 * time.Millisecond
+	travelSpeedLag = 500 * time.Millisecond
+	gasFlowLag     = 3 * time.Second
 )
 
 // TimeseriesGenerator generates realistic timeseries values for welding parameters
@@ -17,14 +43,26 @@ type TimeseriesGenerator struct {
 	TargetGasFlow       float64 // l/min
 	TargetTravelSpeed   float64 // mm/s
 
+	// Metal transfer mode of the active recipe
+	Mode TransferMode
+
+	// Process values following the targets with a lag
+	current       float64
+	voltage       float64
+	wireFeedSpeed float64
+	gasFlow       float64
+	travelSpeed   float64
+	lastFollow    time.Time
+
 	// State tracking for colored noise
 	coloredNoiseState float64
 	lastCurrent       float64
 	lastVoltage       float64
 
 	// Position simulation
-	weldPathProgress float64
-	weldPathLength   float64 // mm
+	weldPathProgress    float64
+	weldPathLength      float64 // mm
+	positionDegradation float64 // Multiplier of the position deviation
 }
 
 // NewTimeseriesGenerator creates a new timeseries generator with default welding parameters
@@ -38,17 +76,27 @@ func NewTimeseriesGenerator() *TimeseriesGenerator {
 		TargetWireFeedSpeed: 9.6,   // m/min (~380 IPM)
 		TargetGasFlow:       15.0,  // l/min (~32 CFH)
 		TargetTravelSpeed:   10.0,  // mm/s
+		Mode:                TransferSpray,
 
-		weldPathLength: 500.0, // mm total weld path
+		weldPathLength:      500.0, // mm total weld path
+		positionDegradation: 1,
 	}
 }
 
 // Generate generates timeseries data based on current state and phase
 func (tg *TimeseriesGenerator) Generate(state MachineState, phase WeldPhase, phaseProgress float64) TimeseriesData {
 	data := TimeseriesData{
-		State:     state,
-		Timestamp: time.Now(),
+		State:        state,
+		TransferMode: tg.Mode,
+		Timestamp:    time.Now(),
+
+		SetpointCurrent:       tg.TargetCurrent,
+		SetpointVoltage:       tg.TargetVoltage,
+		SetpointWireFeedSpeed: tg.TargetWireFeedSpeed,
+		SetpointGasFlow:       tg.TargetGasFlow,
+		SetpointTravelSpeed:   tg.TargetTravelSpeed,
 	}
+	tg.follow(data.Timestamp)
 
 	switch state {
 	case StateRunning:
@@ -91,16 +139,19 @@ func (tg *TimeseriesGenerator) generateRunningValues(data *TimeseriesData, phase
 		noiseLevel = 0
 	}
 
-	// Generate correlated current and voltage with noise
-	commonFactor := tg.rng.NormFloat64() * 0.02 // Shared variance for correlation
+	// Generate correlated current and voltage noise for the transfer mode
+	profile := transferProfiles[tg.Mode]
+	currentFactor := tg.rng.NormFloat64()
+	voltageFactor := profile.correlation*currentFactor +
+		math.Sqrt(1-profile.correlation*profile.correlation)*tg.rng.NormFloat64()
 
 	// Current with Gaussian noise and occasional spikes
-	currentNoise := commonFactor + tg.rng.NormFloat64()*noiseLevel
-	data.WeldingCurrent = tg.TargetCurrent * phaseMult * (1 + currentNoise)
+	currentNoise := currentFactor*profile.currentNoise + tg.rng.NormFloat64()*noiseLevel
+	data.WeldingCurrent = tg.current * phaseMult * (1 + currentNoise)
 
-	// Add occasional spikes (0.3% chance)
-	if tg.rng.Float64() < 0.003 {
-		spike := (tg.rng.Float64() - 0.5) * tg.TargetCurrent * 0.10
+	// Add occasional spikes
+	if tg.rng.Float64() < profile.spikeRate {
+		spike := (tg.rng.Float64() - 0.5) * tg.current * profile.spikeSize
 		data.WeldingCurrent += spike
 	}
 
@@ -109,23 +160,23 @@ func (tg *TimeseriesGenerator) generateRunningValues(data *TimeseriesData, phase
 		data.WeldingCurrent = 0
 	}
 
-	// Voltage correlated with current (0.4-0.6 correlation)
-	voltageNoise := commonFactor*0.5 + tg.rng.NormFloat64()*(noiseLevel*0.5)
-	data.Voltage = tg.TargetVoltage * phaseMult * (1 + voltageNoise)
+	// Voltage correlated with current according to the transfer mode
+	voltageNoise := voltageFactor*profile.voltageNoise + tg.rng.NormFloat64()*(noiseLevel*0.5)
+	data.Voltage = tg.voltage * phaseMult * (1 + voltageNoise)
 	if data.Voltage < 0 {
 		data.Voltage = 0
 	}
 
 	// Wire feed speed - very stable during running
 	wireNoise := tg.rng.NormFloat64() * 0.005 // Only 0.5% noise
-	data.WireFeedSpeed = tg.TargetWireFeedSpeed * phaseMult * (1 + wireNoise)
+	data.WireFeedSpeed = tg.wireFeedSpeed * phaseMult * (1 + wireNoise)
 	if data.WireFeedSpeed < 0 {
 		data.WireFeedSpeed = 0
 	}
 
 	// Gas flow - essentially constant, minimal noise
 	gasNoise := tg.rng.NormFloat64() * 0.003 // 0.3% noise
-	data.GasFlow = tg.TargetGasFlow * (1 + gasNoise)
+	data.GasFlow = tg.gasFlow * (1 + gasNoise)
 	// Gas keeps flowing during ramp phases
 	if phase == PhaseRampUp || phase == PhaseSteady || phase == PhaseRampDown {
 		// Gas is on
@@ -135,7 +186,7 @@ func (tg *TimeseriesGenerator) generateRunningValues(data *TimeseriesData, phase
 
 	// Travel speed - follows weld path
 	travelNoise := tg.rng.NormFloat64() * 0.02
-	data.TravelSpeed = tg.TargetTravelSpeed * phaseMult * (1 + travelNoise)
+	data.TravelSpeed = tg.travelSpeed * phaseMult * (1 + travelNoise)
 	if data.TravelSpeed < 0 {
 		data.TravelSpeed = 0
 	}
@@ -148,9 +199,10 @@ func (tg *TimeseriesGenerator) generateRunningValues(data *TimeseriesData, phase
 
 	// Simple X-Y motion along a line with some variation
 	progress := tg.weldPathProgress / tg.weldPathLength
-	data.PositionX = -250 + progress*500 + tg.rng.NormFloat64()*2
-	data.PositionY = math.Sin(progress*math.Pi*4)*50 + tg.rng.NormFloat64()*2 // Slight wave pattern
-	data.PositionZ = 200 + math.Sin(progress*math.Pi*2)*20 + tg.rng.NormFloat64()*1
+	deviation := tg.positionDegradation
+	data.PositionX = -250 + progress*500 + tg.rng.NormFloat64()*2*deviation
+	data.PositionY = math.Sin(progress*math.Pi*4)*50 + tg.rng.NormFloat64()*2*deviation // Slight wave pattern
+	data.PositionZ = 200 + math.Sin(progress*math.Pi*2)*20 + tg.rng.NormFloat64()*1*deviation
 
 	// Torch angle varies during weld
 	data.TorchAngle = 30 + math.Sin(progress*math.Pi*2)*10 + tg.rng.NormFloat64()*2
@@ -205,6 +257,46 @@ func (tg *TimeseriesGenerator) SetTargets(current, voltage, wireFeed, gasFlow, t
 	tg.TargetTravelSpeed = travelSpeed
 }
 
+// follow moves the process values towards the targets. The first call
+// starts at the targets.
+func (tg *TimeseriesGenerator) follow(now time.Time) {
+	if tg.lastFollow.IsZero() {
+		tg.current = tg.TargetCurrent
+		tg.voltage = tg.TargetVoltage
+		tg.wireFeedSpeed = tg.TargetWireFeedSpeed
+		tg.gasFlow = tg.TargetGasFlow
+		tg.travelSpeed = tg.TargetTravelSpeed
+		tg.lastFollow = now
+		return
+	}
+
+	dt := now.Sub(tg.lastFollow)
+	tg.lastFollow = now
+	tg.current = lag(tg.current, tg.TargetCurrent, dt, electricalLag)
+	tg.voltage = lag(tg.voltage, tg.TargetVoltage, dt, electricalLag)
+	tg.wireFeedSpeed = lag(tg.wireFeedSpeed, tg.TargetWireFeedSpeed, dt, wireFeedLag)
+	tg.gasFlow = lag(tg.gasFlow, tg.TargetGasFlow, dt, gasFlowLag)
+	tg.travelSpeed = lag(tg.travelSpeed, tg.TargetTravelSpeed, dt, travelSpeedLag)
+}
+
+// lag returns value after following target for dt as a first-order system
+// with time constant tau
+func lag(value, target float64, dt, tau time.Duration) float64 {
+	return target + (value-target)*math.Exp(-dt.Seconds()/tau.Seconds())
+}
+
+// SetPositionDegradation scales the deviation of the welding path, e.g. after
+// a collision has left the robot slightly out of calibration
+func (tg *TimeseriesGenerator) SetPositionDegradation(factor float64) {
+	tg.positionDegradation = factor
+}
+
+// ApplyRecipe sets the targets and transfer mode from a weld recipe
+func (tg *TimeseriesGenerator) ApplyRecipe(recipe WeldRecipe) {
+	tg.SetTargets(recipe.Current, recipe.Voltage, recipe.WireFeedSpeed, recipe.GasFlow, recipe.TravelSpeed)
+	tg.Mode = recipe.TransferMode
+}
+
 // CalculatePhaseProgress returns the progress within the current weld phase (0-1)
 func CalculatePhaseProgress(cycleStart time.Time, cycleTime time.Duration, phase WeldPhase) float64 {
 	elapsed := time.Since(cycleStart)
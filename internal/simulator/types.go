@@ -1,6 +1,7 @@
 package simulator
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -13,6 +14,7 @@ const (
 	StateRunning
 	StatePlannedStop
 	StateUnplannedStop
+	StateNotScheduled
 )
 
 func (s MachineState) String() string {
@@ -27,11 +29,29 @@ func (s MachineState) String() string {
 		return "PlannedStop"
 	case StateUnplannedStop:
 		return "UnplannedStop"
+	case StateNotScheduled:
+		return "NotScheduled"
 	default:
 		return "Unknown"
 	}
 }
 
+// MarshalText encodes the state by name
+func (s MachineState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses a state name such as "Running"
+func (s *MachineState) UnmarshalText(text []byte) error {
+	for candidate := StateIdle; candidate <= StateNotScheduled; candidate++ {
+		if candidate.String() == string(text) {
+			*s = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown machine state %q", text)
+}
+
 // WeldPhase represents the phase within a welding cycle
 type WeldPhase int
 
@@ -42,6 +62,62 @@ const (
 	PhaseRampDown
 )
 
+func (p WeldPhase) String() string {
+	switch p {
+	case PhaseOff:
+		return "Off"
+	case PhaseRampUp:
+		return "RampUp"
+	case PhaseSteady:
+		return "Steady"
+	case PhaseRampDown:
+		return "RampDown"
+	default:
+		return "Unknown"
+	}
+}
+
+// MarshalText encodes the phase by name
+func (p WeldPhase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// TransferMode represents the metal transfer mode of the welding process
+type TransferMode int
+
+const (
+	TransferShortCircuit TransferMode = iota
+	TransferGlobular
+	TransferSpray
+	TransferPulsed
+)
+
+func (m TransferMode) String() string {
+	switch m {
+	case TransferShortCircuit:
+		return "ShortCircuit"
+	case TransferGlobular:
+		return "Globular"
+	case TransferSpray:
+		return "Spray"
+	case TransferPulsed:
+		return "Pulsed"
+	default:
+		return "Unknown"
+	}
+}
+
+// UnmarshalText parses a transfer mode name such as "Spray"
+func (m *TransferMode) UnmarshalText(text []byte) error {
+	for candidate := TransferShortCircuit; candidate <= TransferPulsed; candidate++ {
+		if candidate.String() == string(text) {
+			*m = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown transfer mode %q, expected ShortCircuit, Globular, Spray or Pulsed", text)
+}
+
 // ErrorCode represents different error types
 type ErrorCode string
 
@@ -52,32 +128,26 @@ const (
 	ErrorArcFault       ErrorCode = "E003"
 	ErrorRobotCollision ErrorCode = "E004"
 	ErrorQualityReject  ErrorCode = "E005"
+	ErrorTorchOverheat  ErrorCode = "E006"
 )
 
 // ErrorInfo contains information about the current error
 type ErrorInfo struct {
-	Code        ErrorCode
-	Message     string
-	OccurredAt  time.Time
-	ExpectedEnd time.Time
-}
+	Code       ErrorCode `json:"code"`
+	Message    string    `json:"message"`
+	Severity   int       `json:"severity"`
+	OccurredAt time.Time `json:"occurredAt"`
+	EventID    int64     `json:"eventId"` // Entry in the event log
 
-// GetErrorInfo returns error details for a given error code
-func GetErrorInfo(code ErrorCode) (message string, minDuration, maxDuration time.Duration) {
-	switch code {
-	case ErrorWireFeedJam:
-		return "Wire feed jam detected", 5 * time.Minute, 10 * time.Minute
-	case ErrorGasFlowFault:
-		return "Gas flow fault", 2 * time.Minute, 5 * time.Minute
-	case ErrorArcFault:
-		return "Arc fault detected", 1 * time.Minute, 3 * time.Minute
-	case ErrorRobotCollision:
-		return "Robot collision detected", 15 * time.Minute, 30 * time.Minute
-	case ErrorQualityReject:
-		return "Quality reject", 1 * time.Minute, 2 * time.Minute
-	default:
-		return "", 0, 0
-	}
+	// Recovery step in progress, empty while the error is being repaired
+	RecoveryStep string `json:"recoveryStep,omitempty"`
+
+	// End of the repair or of the current recovery step
+	ExpectedEnd time.Time `json:"expectedEnd"`
+
+	// Remaining recovery steps and how production continues afterwards
+	recovery []RecoveryStep
+	resume   ResumeMode
 }
 
 // ProductionOrder represents a manufacturing order
@@ -94,6 +164,7 @@ type ProductionOrder struct {
 	Status              string    `json:"status"`
 	StartedAt           time.Time `json:"startedAt,omitempty"`
 	EstimatedCompletion time.Time `json:"estimatedCompletion,omitempty"`
+	Utilities           UtilityTotals `json:"utilities"`
 }
 
 // Order status constants
@@ -109,6 +180,7 @@ type Shift struct {
 	ShiftID       string        `json:"shiftId"`
 	ShiftName     string        `json:"shiftName"`
 	ShiftNumber   int           `json:"shiftNumber"`
+	Crew          string        `json:"crew,omitempty"`
 	StartTime     time.Time     `json:"startTime"`
 	EndTime       time.Time     `json:"endTime"`
 	WorkCenterID  string        `json:"workCenterId"`
@@ -135,6 +207,18 @@ type PartDefinition struct {
 	PartNumber  string
 	Description string
 	CycleTime   time.Duration
+	Recipe      string
+}
+
+// WeldRecipe defines the process parameters used to weld a part
+type WeldRecipe struct {
+	Name          string
+	TransferMode  TransferMode
+	Current       float64 // Amps
+	Voltage       float64 // Volts
+	WireFeedSpeed float64 // m/min
+	GasFlow       float64 // l/min
+	TravelSpeed   float64 // mm/s
 }
 
 // TimeseriesData holds all current timeseries values
@@ -147,12 +231,27 @@ type TimeseriesData struct {
 	TravelSpeed    float64
 	ArcTime        float64
 
+	// Setpoints the welding parameters follow
+	SetpointCurrent       float64
+	SetpointVoltage       float64
+	SetpointWireFeedSpeed float64
+	SetpointGasFlow       float64
+	SetpointTravelSpeed   float64
+
 	// Position
 	PositionX  float64
 	PositionY  float64
 	PositionZ  float64
 	TorchAngle float64
 
+	// Process
+	TransferMode TransferMode
+
+	// High-rate waveforms (only filled in high-rate mode)
+	CurrentWaveform    []float64
+	VoltageWaveform    []float64
+	WaveformSampleRate float64
+
 	// State info
 	State             MachineState
 	GoodParts         int
@@ -165,6 +264,33 @@ type TimeseriesData struct {
 	ErrorCode      string
 	ErrorMessage   string
 	ErrorTimestamp time.Time
+	RecoveryStep   string
+
+	// Energy and utilities
+	Power             float64 // Electrical input power in kW
+	ArcPower          float64 // Arc power in kW
+	EnergyTotal       float64 // Cumulative energy meter in kWh
+	GasTotal          float64 // Cumulative shielding gas in liters
+	AirTotal          float64 // Cumulative compressed air in liters
+	LastPartUtilities UtilityTotals
+	OrderUtilities    UtilityTotals
+	ShiftUtilities    UtilityTotals
+
+	// Thermal
+	TorchTemperature         float64 // °C
+	CoolantFlow              float64 // l/min
+	CoolantReturnTemperature float64 // °C
+	InterpassTemperature     float64 // °C
+	DutyCycle                float64 // Arc-on percentage over the last 10 minutes
+
+	// Overall equipment effectiveness of the current shift in percent
+	OEEAvailability float64
+	OEEPerformance  float64
+	OEEQuality      float64
+	OEE             float64
+
+	// Sensor quality per signal name (absent means good)
+	Quality map[string]SensorQuality
 
 	// Timestamp
 	Timestamp time.Time
@@ -173,32 +299,45 @@ type TimeseriesData struct {
 // SimulatorState holds the complete state of the simulator
 type SimulatorState struct {
 	// Current state
-	State     MachineState
-	WeldPhase WeldPhase
+	State     MachineState `json:"state"`
+	WeldPhase WeldPhase    `json:"weldPhase"`
 
 	// Timing
-	StateEnteredAt    time.Time
-	CycleStartedAt    time.Time
-	PhaseStartedAt    time.Time
-	LastPublishAt     time.Time
+	StateEnteredAt time.Time `json:"stateEnteredAt"`
+	CycleStartedAt time.Time `json:"cycleStartedAt"`
+	PhaseStartedAt time.Time `json:"phaseStartedAt"`
+	LastPublishAt  time.Time `json:"lastPublishAt"`
+
+	// Set when a cycle should be the last before leaving Running
+	StopAfterCycle bool `json:"stopAfterCycle"`
+
+	// Runtime control: a paused simulation freezes all timers, a held state
+	// is kept until HoldUntil (or indefinitely when zero)
+	Paused    bool      `json:"paused"`
+	PausedAt  time.Time `json:"pausedAt"`
+	Held      bool      `json:"held"`
+	HoldUntil time.Time `json:"holdUntil"`
 
 	// Current work
-	CurrentOrder *ProductionOrder
-	CurrentShift *Shift
+	CurrentOrder *ProductionOrder `json:"currentOrder"`
+	CurrentShift *Shift           `json:"currentShift"`
+
+	// Cycle time of the current order's part, zero to use the configured one
+	OrderCycleTime time.Duration `json:"orderCycleTime"`
 
 	// Counters (reset per shift)
-	GoodParts  int
-	ScrapParts int
-	ArcTime    float64
+	GoodParts  int     `json:"goodParts"`
+	ScrapParts int     `json:"scrapParts"`
+	ArcTime    float64 `json:"arcTime"`
 
 	// Error state
-	CurrentError *ErrorInfo
+	CurrentError *ErrorInfo `json:"currentError"`
 
 	// Order queue
-	OrderQueue []*ProductionOrder
+	OrderQueue []*ProductionOrder `json:"orderQueue"`
 
 	// Timeseries state (for colored noise)
-	LastCurrent float64
-	LastVoltage float64
-	ColoredNoiseState float64
+	LastCurrent       float64 `json:"lastCurrent"`
+	LastVoltage       float64 `json:"lastVoltage"`
+	ColoredNoiseState float64 `json:"coloredNoiseState"`
 }
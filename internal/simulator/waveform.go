@@ -0,0 +1,191 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// WaveformGenerator synthesizes high-rate arc current and voltage waveforms
+// for one publish window. Transfer state is carried over between windows so
+// consecutive windows join without discontinuities.
+type WaveformGenerator struct {
+	rng        *rand.Rand
+	sampleRate float64 // Hz
+
+	// Droplet and pulse transfer state
+	shorted      bool    // Droplet bridging the gap, or pulse peak in pulsed mode
+	periodTime   float64 // Seconds spent in the current period
+	periodLength float64 // Seconds until the next transition
+	current      float64 // Instantaneous current carried across windows
+	voltage      float64 // Instantaneous voltage carried across windows
+	ripplePhase  float64 // Inverter ripple phase in radians
+}
+
+// dropletTiming describes how long arc and short periods last for a droplet transfer mode
+type dropletTiming struct {
+	minShort, maxShort float64 // Seconds
+	minArc, maxArc     float64 // Seconds
+}
+
+var dropletTimings = map[TransferMode]dropletTiming{
+	// Droplet bridges the gap for 2-5 ms roughly every 6-14 ms (~70-120 Hz)
+	TransferShortCircuit: {minShort: 0.002, maxShort: 0.005, minArc: 0.006, maxArc: 0.014},
+	// Large droplets short irregularly every 30-150 ms
+	TransferGlobular: {minShort: 0.003, maxShort: 0.010, minArc: 0.030, maxArc: 0.150},
+}
+
+const (
+	riseTau       = 0.0012 // Current rise limited by source inductance
+	decayTau      = 0.0018 // Current decay after re-ignition
+	spikeTau      = 0.0003 // Re-ignition voltage spike decay
+	pulseEdgeTau  = 0.0002 // Pulse current slew
+	pulsePeak     = 0.0018 // Pulse peak duration in seconds
+	pulseFreq     = 160.0  // Pulses per second
+	rippleFreq    = 300.0  // Inverter ripple in Hz
+	shortVoltage  = 2.5    // Voltage across the shorted wire
+	pulsePeakMult = 2.4    // Peak current relative to mean
+	pulseBaseMult = 0.55   // Background current relative to mean
+)
+
+// NewWaveformGenerator creates a new waveform generator sampling at sampleRate Hz
+func NewWaveformGenerator(sampleRate float64) *WaveformGenerator {
+	return &WaveformGenerator{
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		sampleRate: sampleRate,
+	}
+}
+
+// SampleRate returns the waveform sample rate in Hz
+func (wg *WaveformGenerator) SampleRate() float64 {
+	return wg.sampleRate
+}
+
+// Generate returns current and voltage waveforms for a window whose averages
+// match the given mean values. Both slices are zero while the arc is off.
+func (wg *WaveformGenerator) Generate(mode TransferMode, meanCurrent, meanVoltage float64, window time.Duration) (current, voltage []float64) {
+	n := int(window.Seconds() * wg.sampleRate)
+	current = make([]float64, n)
+	voltage = make([]float64, n)
+
+	if meanCurrent <= 0 || meanVoltage <= 0 || n == 0 {
+		wg.shorted = false
+		wg.periodTime = 0
+		wg.periodLength = 0
+		wg.current = 0
+		wg.voltage = 0
+		return current, voltage
+	}
+
+	dt := 1 / wg.sampleRate
+	if wg.current == 0 {
+		wg.current = meanCurrent
+		wg.voltage = meanVoltage
+	}
+
+	for i := 0; i < n; i++ {
+		switch mode {
+		case TransferShortCircuit, TransferGlobular:
+			wg.stepDroplet(dropletTimings[mode], meanCurrent, meanVoltage, dt)
+		case TransferPulsed:
+			wg.stepPulsed(meanCurrent, meanVoltage, dt)
+		default:
+			wg.stepSpray(meanCurrent, meanVoltage, dt)
+		}
+
+		current[i] = wg.current + wg.rng.NormFloat64()*meanCurrent*0.01
+		voltage[i] = wg.voltage
+		wg.periodTime += dt
+	}
+
+	// Scale so the window averages match the published scalar values
+	scaleToMean(current, meanCurrent)
+	scaleToMean(voltage, meanVoltage)
+
+	return current, voltage
+}
+
+// stepDroplet advances a short-circuiting transfer by one sample: current
+// climbs while a droplet shorts the arc, then the arc re-ignites with a
+// voltage spike and the current decays back to the arc level.
+func (wg *WaveformGenerator) stepDroplet(timing dropletTiming, meanCurrent, meanVoltage, dt float64) {
+	if wg.periodTime >= wg.periodLength {
+		wg.shorted = !wg.shorted
+		wg.periodTime = 0
+		if wg.shorted {
+			wg.periodLength = timing.minShort + wg.rng.Float64()*(timing.maxShort-timing.minShort)
+		} else {
+			wg.periodLength = timing.minArc + wg.rng.Float64()*(timing.maxArc-timing.minArc)
+		}
+	}
+
+	arcCurrent := meanCurrent * 0.85
+	shortPeak := meanCurrent * 1.9
+	arcVoltage := meanVoltage * 1.12
+	reignitionVoltage := arcVoltage * 1.5
+
+	if wg.shorted {
+		wg.current = shortPeak - (shortPeak-wg.current)*math.Exp(-dt/riseTau)
+		wg.voltage = shortVoltage + wg.rng.NormFloat64()*0.3
+		return
+	}
+
+	wg.current = arcCurrent + (wg.current-arcCurrent)*math.Exp(-dt/decayTau)
+	wg.voltage = arcVoltage + (reignitionVoltage-arcVoltage)*math.Exp(-wg.periodTime/spikeTau)
+	wg.voltage += wg.rng.NormFloat64() * arcVoltage * 0.02
+}
+
+// stepSpray advances a spray transfer by one sample: a steady arc with
+// inverter ripple and fine droplet noise, voltage following current.
+func (wg *WaveformGenerator) stepSpray(meanCurrent, meanVoltage, dt float64) {
+	wg.ripplePhase += 2 * math.Pi * rippleFreq * dt
+	if wg.ripplePhase > 2*math.Pi {
+		wg.ripplePhase -= 2 * math.Pi
+	}
+	ripple := math.Sin(wg.ripplePhase)
+
+	wg.current = meanCurrent * (1 + 0.02*ripple + wg.rng.NormFloat64()*0.01)
+	wg.voltage = meanVoltage * (1 + 0.01*ripple + wg.rng.NormFloat64()*0.005)
+}
+
+// stepPulsed advances a pulsed transfer by one sample: the source alternates
+// between a short peak that detaches one droplet and a background current.
+func (wg *WaveformGenerator) stepPulsed(meanCurrent, meanVoltage, dt float64) {
+	if wg.periodTime >= wg.periodLength {
+		wg.shorted = !wg.shorted
+		wg.periodTime = 0
+		if wg.shorted {
+			wg.periodLength = pulsePeak
+		} else {
+			wg.periodLength = 1/pulseFreq - pulsePeak
+		}
+	}
+
+	target := meanCurrent * pulseBaseMult
+	if wg.shorted {
+		target = meanCurrent * pulsePeakMult
+	}
+	wg.current = target + (wg.current-target)*math.Exp(-dt/pulseEdgeTau)
+
+	// Arc voltage rises with current along the arc characteristic
+	wg.voltage = meanVoltage * (0.7 + 0.3*wg.current/meanCurrent)
+	wg.voltage += wg.rng.NormFloat64() * meanVoltage * 0.01
+}
+
+// scaleToMean scales samples in place so their average equals mean
+func scaleToMean(samples []float64, mean float64) {
+	var sum float64
+	for _, v := range samples {
+		sum += v
+	}
+	if sum <= 0 {
+		return
+	}
+	factor := mean * float64(len(samples)) / sum
+	for i := range samples {
+		samples[i] *= factor
+		if samples[i] < 0 {
+			samples[i] = 0
+		}
+	}
+}